@@ -0,0 +1,274 @@
+package addressbook
+
+import (
+	"Otternet/backend/api/blocklist"
+	"Otternet/backend/api/handlers"
+	"Otternet/backend/global"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// Entry maps a peer to the wallet address it should be paid at
+type Entry struct {
+	PeerID        string                      `json:"peerID"`
+	WalletAddress string                      `json:"walletAddress"`
+	Nickname      string                      `json:"nickname"`
+	Pinned        bool                        `json:"pinned"`   // payments must go to WalletAddress even if the peer claims otherwise
+	Verified      bool                        `json:"verified"` // WalletAddress came from a valid signed attestation
+	Attestation   *handlers.WalletAttestation `json:"attestation,omitempty"`
+	UpdatedAt     string                      `json:"updatedAt"`
+}
+
+const addressBookFilePath = "./api/addressbook/addressbook.json"
+
+// How long a verified attestation is trusted before the peer is asked again
+const attestationTTL = 24 * time.Hour
+
+var mutex = &sync.Mutex{}
+
+func readEntries() (map[string]Entry, error) {
+	entries := map[string]Entry{}
+	data, err := os.ReadFile(addressBookFilePath)
+	if os.IsNotExist(err) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading address book: %w", err)
+	}
+	var list []Entry
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("error unmarshalling address book: %w", err)
+	}
+	for _, entry := range list {
+		entries[entry.PeerID] = entry
+	}
+	return entries, nil
+}
+
+func writeEntries(entries map[string]Entry) error {
+	list := make([]Entry, 0, len(entries))
+	for _, entry := range entries {
+		list = append(list, entry)
+	}
+	data, err := json.MarshalIndent(list, "", " ")
+	if err != nil {
+		return fmt.Errorf("error marshalling address book: %w", err)
+	}
+	return os.WriteFile(addressBookFilePath, data, 0644)
+}
+
+// Lookup returns the address book entry for peerID, if any
+func Lookup(peerID string) (Entry, bool) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	entries, err := readEntries()
+	if err != nil {
+		fmt.Printf("Address book: %v\n", err)
+		return Entry{}, false
+	}
+	entry, ok := entries[peerID]
+	return entry, ok
+}
+
+// Resolve returns the wallet address to pay peerID at. A fresh verified entry is used as is; otherwise the peer is
+// asked for a signed attestation, which must match the pinned address if one is set
+func Resolve(peerID peer.ID) (string, error) {
+	entry, ok := Lookup(peerID.String())
+	if ok && entry.Verified && !attestationExpired(entry) {
+		return entry.WalletAddress, nil
+	}
+
+	attestation, err := RequestAttestation(peerID)
+	if err != nil {
+		if ok && entry.WalletAddress != "" {
+			fmt.Printf("Address book: using cached address for %s: %v\n", peerID, err)
+			return entry.WalletAddress, nil
+		}
+		return "", err
+	}
+	if err := recordAttestation(attestation); err != nil {
+		return "", err
+	}
+	return attestation.WalletAddress, nil
+}
+
+// CheckClaim verifies a wallet address a peer sent in-band (e.g. alongside a file) against the address book.
+// It fails if the address contradicts a pinned or verified entry
+func CheckClaim(peerID string, claimed string) error {
+	claimed = strings.TrimSpace(claimed)
+	entry, ok := Lookup(peerID)
+	if !ok || entry.WalletAddress == "" {
+		return nil
+	}
+	if (entry.Pinned || entry.Verified) && entry.WalletAddress != claimed {
+		return fmt.Errorf("peer %s claims wallet %s but the address book has %s", peerID, claimed, entry.WalletAddress)
+	}
+	return nil
+}
+
+// RequestAttestation asks peerID for its signed wallet address and verifies it
+func RequestAttestation(peerID peer.ID) (handlers.WalletAttestation, error) {
+	var attestation handlers.WalletAttestation
+	if global.DHTNode == nil {
+		return attestation, fmt.Errorf("DHT node is not initialized")
+	}
+	stream, err := global.DHTNode.Host.NewStream(global.DHTNode.Ctx, peerID, handlers.WalletAddressReqHandler)
+	if err != nil {
+		return attestation, fmt.Errorf("failed to open stream: %w", err)
+	}
+	defer stream.Close()
+	if err := json.NewDecoder(stream).Decode(&attestation); err != nil {
+		return attestation, fmt.Errorf("failed to decode wallet attestation: %w", err)
+	}
+	if err := attestation.Verify(peerID); err != nil {
+		blocklist.ReportViolation(peerID, "invalid wallet attestation: "+err.Error())
+		return attestation, fmt.Errorf("wallet attestation from %s rejected: %w", peerID, err)
+	}
+	return attestation, nil
+}
+
+// Stores a verified attestation, refusing it if it contradicts a pinned address
+func recordAttestation(attestation handlers.WalletAttestation) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	entries, err := readEntries()
+	if err != nil {
+		return err
+	}
+	entry := entries[attestation.PeerID]
+	if entry.Pinned && entry.WalletAddress != attestation.WalletAddress {
+		return fmt.Errorf("peer %s claims wallet %s but is pinned to %s", attestation.PeerID, attestation.WalletAddress, entry.WalletAddress)
+	}
+	entry.PeerID = attestation.PeerID
+	entry.WalletAddress = attestation.WalletAddress
+	entry.Verified = true
+	entry.Attestation = &attestation
+	entry.UpdatedAt = time.Now().Format(time.RFC3339)
+	entries[entry.PeerID] = entry
+	return writeEntries(entries)
+}
+
+func attestationExpired(entry Entry) bool {
+	if entry.Attestation == nil {
+		return true
+	}
+	return time.Since(time.Unix(entry.Attestation.Timestamp, 0)) > attestationTTL
+}
+
+// GetAddressBook lists every entry
+func GetAddressBook(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	mutex.Lock()
+	entries, err := readEntries()
+	mutex.Unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	list := make([]Entry, 0, len(entries))
+	for _, entry := range entries {
+		list = append(list, entry)
+	}
+	json.NewEncoder(w).Encode(list)
+}
+
+// PutAddressBookEntry adds or updates a nickname, a pinned address or both for a peer
+func PutAddressBookEntry(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var req struct {
+		PeerID        string `json:"peerID"`
+		Nickname      string `json:"nickname"`
+		WalletAddress string `json:"walletAddress"`
+		Pinned        bool   `json:"pinned"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if _, err := peer.Decode(req.PeerID); err != nil {
+		http.Error(w, "Invalid peer ID", http.StatusBadRequest)
+		return
+	}
+	req.WalletAddress = strings.TrimSpace(req.WalletAddress)
+	if req.Pinned && req.WalletAddress == "" {
+		http.Error(w, "A wallet address is required to pin a peer", http.StatusBadRequest)
+		return
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	entries, err := readEntries()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	entry := entries[req.PeerID]
+	entry.PeerID = req.PeerID
+	entry.Nickname = req.Nickname
+	entry.Pinned = req.Pinned
+	if req.WalletAddress != "" && req.WalletAddress != entry.WalletAddress {
+		// a manually entered address is not verified until the peer attests to it
+		entry.WalletAddress = req.WalletAddress
+		entry.Verified = entry.Attestation != nil && entry.Attestation.WalletAddress == req.WalletAddress
+	}
+	entry.UpdatedAt = time.Now().Format(time.RFC3339)
+	entries[entry.PeerID] = entry
+	if err := writeEntries(entries); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(entry)
+}
+
+// DeleteAddressBookEntry forgets a peer
+func DeleteAddressBookEntry(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	peerID := mux.Vars(r)["peerID"]
+	mutex.Lock()
+	defer mutex.Unlock()
+	entries, err := readEntries()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if _, ok := entries[peerID]; !ok {
+		http.Error(w, "Peer not found in address book", http.StatusNotFound)
+		return
+	}
+	delete(entries, peerID)
+	if err := writeEntries(entries); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"message": "Address book entry deleted", "status": "success"})
+}
+
+// ResolveAddress returns the verified wallet address for a peer, querying the peer if needed
+func ResolveAddress(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	peerID, err := peer.Decode(mux.Vars(r)["peerID"])
+	if err != nil {
+		http.Error(w, "Invalid peer ID", http.StatusBadRequest)
+		return
+	}
+	address, err := Resolve(peerID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to resolve wallet address: %v", err), http.StatusBadGateway)
+		return
+	}
+	entry, _ := Lookup(peerID.String())
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"peerID":        peerID.String(),
+		"walletAddress": address,
+		"nickname":      entry.Nickname,
+		"pinned":        entry.Pinned,
+	})
+}
@@ -0,0 +1,590 @@
+package backup
+
+import (
+	"Otternet/backend/api/bitcoin"
+	"Otternet/backend/api/blocklist"
+	"Otternet/backend/api/files"
+	"Otternet/backend/api/reprovider"
+	"Otternet/backend/api/reputation"
+	"Otternet/backend/config"
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// Archive layout: magic | salt | nonce | AES-256-GCM(tar.gz). The GCM tag authenticates the whole archive and the
+// manifest inside it records a SHA-256 for every member
+const (
+	archiveMagic     = "OTTERBAK1"
+	archiveExtension = ".otterbak"
+	saltSize         = 16
+	manifestName     = "manifest.json"
+	walletPrefix     = "wallets/"
+	metadataPrefix   = "metadata/"
+)
+
+// Node state that is backed up alongside the wallets, including the node's identity key so a restored node keeps
+// its peer ID
+var metadataFiles = []string{
+	"./api/identity.key",
+	"./api/files/files.json",
+	"./api/files/providers.txt",
+	"./api/download/downloads.json",
+	"./api/addressbook/addressbook.json",
+	"./api/bitcoin/transactions.json",
+	"./api/bitcoin/psbts.json",
+	"./api/proxy/payments.json",
+	"./api/statistics/statistics.txt",
+	"./api/files/queue.json",
+	"./api/files/shared.json",
+	"./api/files/integrity.json",
+	"./api/reprovider/reprovider.json",
+	"./api/reputation/reputation.json",
+	"./api/blocklist/blocklist.json",
+}
+
+// Directories whose files are all backed up, such as the bundle manifests
+var metadataDirs = []string{
+	"./api/bundle/manifests",
+}
+
+// ErrOutsideBackupDir is returned for archive paths that are not inside cfg.BackupDir
+var ErrOutsideBackupDir = errors.New("backup archives must be inside the backup directory")
+
+var ErrWrongPassphrase = errors.New("wrong passphrase or corrupted archive")
+
+type Manifest struct {
+	Version   int               `json:"version"`
+	CreatedAt string            `json:"createdAt"`
+	Wallets   []string          `json:"wallets"`
+	Files     map[string]string `json:"files"` // archive member -> sha256
+}
+
+type ArchiveInfo struct {
+	Path           string            `json:"path"`
+	Size           int64             `json:"size"`
+	CreatedAt      string            `json:"createdAt"`
+	SkippedWallets map[string]string `json:"skippedWallets,omitempty"` // wallet -> why it is not in the archive
+}
+
+type RestoreResult struct {
+	RestoredWallets  []string          `json:"restoredWallets"`
+	SkippedWallets   map[string]string `json:"skippedWallets,omitempty"` // wallet -> why it was not restored
+	RestoredMetadata []string          `json:"restoredMetadata"`
+	Errors           map[string]string `json:"errors,omitempty"`
+	RestartRequired  bool              `json:"restartRequired"` // restored state only takes effect after a restart
+	RestartReason    string            `json:"restartReason,omitempty"`
+}
+
+// Restored identity key. The running libp2p host keeps the key it started with
+const identityKeyFile = "./api/identity.key"
+
+// CreateBackup writes an encrypted archive of every wallet and the node's metadata to cfg.BackupDir and rotates
+// old archives. Wallets that are not loaded are loaded to be backed up; any that still cannot be backed up are listed
+// in the returned SkippedWallets
+func CreateBackup(cfg *config.Config, passphrase string) (*ArchiveInfo, error) {
+	if passphrase == "" {
+		return nil, fmt.Errorf("a passphrase is required")
+	}
+	if err := os.MkdirAll(cfg.BackupDir, 0700); err != nil {
+		return nil, fmt.Errorf("error creating backup directory: %w", err)
+	}
+	tempDir, err := os.MkdirTemp("", "otternet-backup-*")
+	if err != nil {
+		return nil, fmt.Errorf("error creating temporary directory: %w", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	btcClient := bitcoin.NewBitcoinClient(cfg)
+	walletNames, err := btcClient.ListWallets()
+	if err != nil {
+		return nil, err
+	}
+
+	members := map[string][]byte{}
+	skipped := map[string]string{}
+	manifest := Manifest{Version: 1, CreatedAt: time.Now().Format(time.RFC3339), Files: map[string]string{}}
+	for _, walletName := range walletNames {
+		// bitcoind writes the backup itself, so it needs an absolute path
+		dest, err := filepath.Abs(filepath.Join(tempDir, sanitize(walletName)+".dat"))
+		if err != nil {
+			return nil, err
+		}
+		if err := btcClient.BackupWallet(walletName, dest); err != nil {
+			// listwalletdir also lists wallets that are not loaded, and bitcoind can only back up loaded ones
+			if _, loadErr := btcClient.LoadWallet(walletName); loadErr == nil {
+				err = btcClient.BackupWallet(walletName, dest)
+			}
+			if err != nil {
+				fmt.Printf("Skipping wallet %s in backup: %v\n", walletName, err)
+				skipped[walletName] = err.Error()
+				continue
+			}
+		}
+		data, err := os.ReadFile(dest)
+		if err != nil {
+			return nil, fmt.Errorf("error reading wallet backup %s: %w", walletName, err)
+		}
+		members[walletPrefix+walletName] = data
+		manifest.Wallets = append(manifest.Wallets, walletName)
+	}
+	paths := append([]string(nil), metadataFiles...)
+	for _, dir := range metadataDirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("error reading %s: %w", dir, err)
+		}
+		for _, entry := range entries {
+			if entry.Type().IsRegular() {
+				paths = append(paths, dir+"/"+entry.Name())
+			}
+		}
+	}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %w", path, err)
+		}
+		members[metadataPrefix+strings.TrimPrefix(path, "./")] = data
+	}
+	for name, data := range members {
+		sum := sha256.Sum256(data)
+		manifest.Files[name] = hex.EncodeToString(sum[:])
+	}
+
+	plain, err := writeTarGz(manifest, members)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := encrypt(plain, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	path, err := writeArchive(cfg.BackupDir, sealed)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("Backup written to %s (%d wallets, %d files)\n", path, len(manifest.Wallets), len(members))
+
+	if err := rotate(cfg.BackupDir, cfg.BackupKeep); err != nil {
+		fmt.Printf("Error rotating backups: %v\n", err)
+	}
+	info := &ArchiveInfo{Path: path, Size: int64(len(sealed)), CreatedAt: manifest.CreatedAt}
+	if len(skipped) > 0 {
+		info.SkippedWallets = skipped
+	}
+	return info, nil
+}
+
+// Writes sealed to a new archive in dir, named after the current time. Names have nanosecond resolution and fixed
+// width so they sort chronologically, and an existing archive is never overwritten: a name already taken, e.g. by a
+// manual and a scheduled backup started together, is replaced with a later one
+func writeArchive(dir string, sealed []byte) (string, error) {
+	for {
+		path := filepath.Join(dir, "otternet-"+time.Now().Format("20060102-150405.000000000")+archiveExtension)
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("error writing backup archive: %w", err)
+		}
+		_, err = file.Write(sealed)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(path)
+			return "", fmt.Errorf("error writing backup archive: %w", err)
+		}
+		return path, nil
+	}
+}
+
+// Resolves an archive path given by an API caller, which may also be a bare archive name, and checks that it is
+// inside cfg.BackupDir
+func archivePath(cfg *config.Config, path string) (string, error) {
+	if !strings.ContainsAny(path, `/\`) {
+		path = filepath.Join(cfg.BackupDir, path)
+	}
+	dir, err := filepath.Abs(cfg.BackupDir)
+	if err != nil {
+		return "", err
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	// symlinks inside the backup directory must not lead out of it
+	if resolved, err := filepath.EvalSymlinks(abs); err == nil {
+		abs = resolved
+	}
+	if resolvedDir, err := filepath.EvalSymlinks(dir); err == nil {
+		dir = resolvedDir
+	}
+	rel, err := filepath.Rel(dir, abs)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", ErrOutsideBackupDir
+	}
+	return abs, nil
+}
+
+// VerifyBackup decrypts an archive in cfg.BackupDir and checks every member against the manifest
+func VerifyBackup(cfg *config.Config, path string, passphrase string) (*Manifest, error) {
+	path, err := archivePath(cfg, path)
+	if err != nil {
+		return nil, err
+	}
+	manifest, _, err := openArchive(path, passphrase)
+	return manifest, err
+}
+
+// RestoreBackup restores every wallet in the archive with restorewallet and, if restoreMetadata is set, puts the
+// node's metadata files back. Existing metadata files are kept alongside as .bak, and the packages that keep them in
+// memory reload them. restorewallet cannot overwrite a wallet, so wallets that already exist, loaded or not, are
+// skipped and listed in SkippedWallets
+func RestoreBackup(cfg *config.Config, path string, passphrase string, restoreMetadata bool) (*RestoreResult, error) {
+	path, err := archivePath(cfg, path)
+	if err != nil {
+		return nil, err
+	}
+	manifest, members, err := openArchive(path, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	tempDir, err := os.MkdirTemp("", "otternet-restore-*")
+	if err != nil {
+		return nil, fmt.Errorf("error creating temporary directory: %w", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	result := &RestoreResult{RestoredWallets: []string{}, SkippedWallets: map[string]string{}, RestoredMetadata: []string{}, Errors: map[string]string{}}
+	btcClient := bitcoin.NewBitcoinClient(cfg)
+	existing := map[string]bool{}
+	if walletNames, err := btcClient.ListWallets(); err == nil {
+		for _, walletName := range walletNames {
+			existing[walletName] = true
+		}
+	} else {
+		fmt.Printf("Error listing wallets before restore: %v\n", err)
+	}
+	for _, walletName := range manifest.Wallets {
+		if existing[walletName] {
+			result.SkippedWallets[walletName] = "a wallet with this name already exists; remove it from bitcoind's wallet directory to restore it from the backup"
+			continue
+		}
+		src, err := filepath.Abs(filepath.Join(tempDir, sanitize(walletName)+".dat"))
+		if err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(src, members[walletPrefix+walletName], 0600); err != nil {
+			return nil, fmt.Errorf("error extracting wallet %s: %w", walletName, err)
+		}
+		if err := btcClient.RestoreWallet(walletName, src); err != nil {
+			result.Errors[walletName] = err.Error()
+			continue
+		}
+		result.RestoredWallets = append(result.RestoredWallets, walletName)
+	}
+
+	if restoreMetadata {
+		for name, data := range members {
+			if !strings.HasPrefix(name, metadataPrefix) {
+				continue
+			}
+			dest := "./" + strings.TrimPrefix(name, metadataPrefix)
+			if !isMetadataFile(dest) {
+				result.Errors[name] = "not a known metadata file"
+				continue
+			}
+			// keep private files, such as the identity key, private
+			mode := os.FileMode(0644)
+			if info, err := os.Stat(dest); err == nil {
+				mode = info.Mode().Perm()
+			} else if dest == identityKeyFile {
+				mode = 0600
+			}
+			existing, err := os.ReadFile(dest)
+			if err == nil {
+				os.WriteFile(dest+".bak", existing, mode)
+			}
+			if dest == identityKeyFile && !bytes.Equal(existing, data) {
+				result.RestartRequired = true
+				result.RestartReason = "the node identity key was restored; restart the backend to use the restored peer ID"
+			}
+			if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+				result.Errors[name] = err.Error()
+				continue
+			}
+			if err := os.WriteFile(dest, data, mode); err != nil {
+				result.Errors[name] = err.Error()
+				continue
+			}
+			result.RestoredMetadata = append(result.RestoredMetadata, dest)
+		}
+		reloadMetadata(result)
+	}
+	return result, nil
+}
+
+// Makes the packages that cache restored metadata files in memory read them again, so the running node neither
+// ignores the restored state nor writes its old state back over it
+func reloadMetadata(result *RestoreResult) {
+	for _, dest := range result.RestoredMetadata {
+		switch dest {
+		case "./api/blocklist/blocklist.json":
+			blocklist.Reload()
+		case "./api/reputation/reputation.json":
+			reputation.Reload()
+		case "./api/reprovider/reprovider.json":
+			reprovider.Reload()
+		case "./api/files/queue.json":
+			if err := files.ReloadQueue(); err != nil {
+				result.Errors[dest] = err.Error()
+			}
+		}
+	}
+}
+
+// ListBackups returns the archives in cfg.BackupDir, newest first
+func ListBackups(cfg *config.Config) ([]ArchiveInfo, error) {
+	entries, err := os.ReadDir(cfg.BackupDir)
+	if os.IsNotExist(err) {
+		return []ArchiveInfo{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading backup directory: %w", err)
+	}
+	archives := []ArchiveInfo{}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), archiveExtension) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		archives = append(archives, ArchiveInfo{
+			Path:      filepath.Join(cfg.BackupDir, entry.Name()),
+			Size:      info.Size(),
+			CreatedAt: info.ModTime().Format(time.RFC3339),
+		})
+	}
+	sort.Slice(archives, func(i, j int) bool { return archives[i].Path > archives[j].Path })
+	return archives, nil
+}
+
+// Deletes all but the newest keep archives. Archive names sort chronologically
+func rotate(dir string, keep int) error {
+	if keep <= 0 {
+		return nil
+	}
+	archives, err := ListBackups(&config.Config{BackupDir: dir})
+	if err != nil {
+		return err
+	}
+	for i := keep; i < len(archives); i++ {
+		if err := os.Remove(archives[i].Path); err != nil {
+			return err
+		}
+		fmt.Printf("Rotated out backup %s\n", archives[i].Path)
+	}
+	return nil
+}
+
+func openArchive(path string, passphrase string) (*Manifest, map[string][]byte, error) {
+	sealed, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading backup archive: %w", err)
+	}
+	plain, err := decrypt(sealed, passphrase)
+	if err != nil {
+		return nil, nil, err
+	}
+	members, err := readTarGz(plain)
+	if err != nil {
+		return nil, nil, err
+	}
+	manifestData, ok := members[manifestName]
+	if !ok {
+		return nil, nil, fmt.Errorf("archive has no manifest")
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(manifestData, &manifest); err != nil {
+		return nil, nil, fmt.Errorf("invalid manifest: %w", err)
+	}
+	delete(members, manifestName)
+	for name, expected := range manifest.Files {
+		data, ok := members[name]
+		if !ok {
+			return nil, nil, fmt.Errorf("archive is missing %s", name)
+		}
+		sum := sha256.Sum256(data)
+		if hex.EncodeToString(sum[:]) != expected {
+			return nil, nil, fmt.Errorf("checksum mismatch for %s", name)
+		}
+	}
+	return &manifest, members, nil
+}
+
+func writeTarGz(manifest Manifest, members map[string][]byte) ([]byte, error) {
+	manifestData, err := json.MarshalIndent(manifest, "", " ")
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling manifest: %w", err)
+	}
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	write := func(name string, data []byte) error {
+		header := &tar.Header{Name: name, Mode: 0600, Size: int64(len(data)), ModTime: time.Now()}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+	if err := write(manifestName, manifestData); err != nil {
+		return nil, fmt.Errorf("error writing archive: %w", err)
+	}
+	for name, data := range members {
+		if err := write(name, data); err != nil {
+			return nil, fmt.Errorf("error writing archive: %w", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, fmt.Errorf("error writing archive: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return nil, fmt.Errorf("error writing archive: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func readTarGz(data []byte) (map[string][]byte, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("error reading archive: %w", err)
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	members := map[string][]byte{}
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return members, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading archive: %w", err)
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("error reading archive member %s: %w", header.Name, err)
+		}
+		members[header.Name] = content
+	}
+}
+
+func deriveKey(passphrase string, salt []byte) ([]byte, error) {
+	return scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, 32)
+}
+
+func encrypt(plain []byte, passphrase string) ([]byte, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	key, err := deriveKey(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	header := append([]byte(archiveMagic), salt...)
+	header = append(header, nonce...)
+	// the header is authenticated as additional data so it cannot be swapped
+	return gcm.Seal(header, nonce, plain, header), nil
+}
+
+func decrypt(sealed []byte, passphrase string) ([]byte, error) {
+	if len(sealed) < len(archiveMagic)+saltSize || string(sealed[:len(archiveMagic)]) != archiveMagic {
+		return nil, fmt.Errorf("not an Otternet backup archive")
+	}
+	salt := sealed[len(archiveMagic) : len(archiveMagic)+saltSize]
+	key, err := deriveKey(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	headerSize := len(archiveMagic) + saltSize + gcm.NonceSize()
+	if len(sealed) < headerSize {
+		return nil, ErrWrongPassphrase
+	}
+	header := sealed[:headerSize]
+	nonce := sealed[len(archiveMagic)+saltSize : headerSize]
+	plain, err := gcm.Open(nil, nonce, sealed[headerSize:], header)
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	return plain, nil
+}
+
+func isMetadataFile(path string) bool {
+	for _, known := range metadataFiles {
+		if known == path {
+			return true
+		}
+	}
+	// a file directly inside one of metadataDirs; Clean folds away any ".." in the name
+	clean := filepath.Clean(path)
+	for _, dir := range metadataDirs {
+		if filepath.Dir(clean) == filepath.Clean(dir) && "./"+filepath.ToSlash(clean) == path {
+			return true
+		}
+	}
+	return false
+}
+
+// Wallet names may contain path separators; keep temporary file names flat
+func sanitize(name string) string {
+	if name == "" {
+		return "default"
+	}
+	return strings.NewReplacer("/", "_", "\\", "_", "..", "_").Replace(name)
+}
@@ -0,0 +1,223 @@
+package backup
+
+import (
+	"Otternet/backend/config"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const scheduleFilePath = "./api/backup/schedule.json"
+
+// Scheduled backups. The settings are saved, but the passphrase is only ever held in memory, so after a restart an
+// enabled schedule waits with PassphraseRequired set until the passphrase is posted to /backup/schedule again
+type scheduleState struct {
+	Enabled            bool   `json:"enabled"`
+	IntervalHours      int    `json:"intervalHours"`
+	Keep               int    `json:"keep"`
+	LastBackup         string `json:"lastBackup,omitempty"`
+	LastError          string `json:"lastError,omitempty"`
+	PassphraseRequired bool   `json:"passphraseRequired"` // enabled, but not running until the passphrase is given
+
+	passphrase string
+	cancel     context.CancelFunc
+}
+
+var (
+	scheduleMutex = &sync.Mutex{}
+	schedule      scheduleState
+)
+
+func init() {
+	data, err := os.ReadFile(scheduleFilePath)
+	if err != nil {
+		return
+	}
+	if err := json.Unmarshal(data, &schedule); err != nil {
+		fmt.Printf("Backup: ignoring unreadable schedule file: %v\n", err)
+		schedule = scheduleState{}
+		return
+	}
+	schedule.PassphraseRequired = schedule.Enabled
+	if schedule.Enabled {
+		fmt.Println("Backup: scheduled backups are paused until the passphrase is given again")
+	}
+}
+
+// Caller holds scheduleMutex
+func saveScheduleLocked() {
+	data, err := json.MarshalIndent(schedule, "", " ")
+	if err != nil {
+		fmt.Printf("Backup: error marshalling schedule: %v\n", err)
+		return
+	}
+	if err := os.WriteFile(scheduleFilePath, data, 0644); err != nil {
+		fmt.Printf("Backup: error writing schedule: %v\n", err)
+	}
+}
+
+func runSchedule(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		scheduleMutex.Lock()
+		passphrase := schedule.passphrase
+		keep := schedule.Keep
+		scheduleMutex.Unlock()
+
+		cfg := config.NewConfig()
+		cfg.BackupKeep = keep
+		info, err := CreateBackup(cfg, passphrase)
+
+		scheduleMutex.Lock()
+		if err != nil {
+			fmt.Printf("Scheduled backup failed: %v\n", err)
+			schedule.LastError = err.Error()
+		} else {
+			schedule.LastBackup = info.Path
+			schedule.LastError = ""
+			if len(info.SkippedWallets) > 0 {
+				schedule.LastError = fmt.Sprintf("%d wallet(s) could not be backed up", len(info.SkippedWallets))
+			}
+		}
+		saveScheduleLocked()
+		scheduleMutex.Unlock()
+	}
+}
+
+// CreateBackupHandler writes a new encrypted archive. Body: {"passphrase": "..."}
+func CreateBackupHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var req struct {
+		Passphrase string `json:"passphrase"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Passphrase == "" {
+		http.Error(w, "Invalid JSON body; 'passphrase' is required", http.StatusBadRequest)
+		return
+	}
+	info, err := CreateBackup(config.NewConfig(), req.Passphrase)
+	if err != nil {
+		fmt.Printf("Error creating backup: %v\n", err)
+		http.Error(w, fmt.Sprintf("Failed to create backup: %v", err), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(info)
+}
+
+func ListBackupsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	archives, err := ListBackups(config.NewConfig())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(archives)
+}
+
+// VerifyBackupHandler checks that an archive decrypts and matches its manifest. Body: {"path", "passphrase"}
+func VerifyBackupHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var req struct {
+		Path       string `json:"path"`
+		Passphrase string `json:"passphrase"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Path == "" || req.Passphrase == "" {
+		http.Error(w, "Invalid JSON body; 'path' and 'passphrase' are required", http.StatusBadRequest)
+		return
+	}
+	manifest, err := VerifyBackup(config.NewConfig(), req.Path, req.Passphrase)
+	if errors.Is(err, ErrOutsideBackupDir) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		json.NewEncoder(w).Encode(map[string]interface{}{"valid": false, "error": err.Error()})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"valid": true, "manifest": manifest})
+}
+
+// RestoreBackupHandler restores wallets and optionally metadata. Body: {"path", "passphrase", "restoreMetadata"}
+func RestoreBackupHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var req struct {
+		Path            string `json:"path"`
+		Passphrase      string `json:"passphrase"`
+		RestoreMetadata bool   `json:"restoreMetadata"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Path == "" || req.Passphrase == "" {
+		http.Error(w, "Invalid JSON body; 'path' and 'passphrase' are required", http.StatusBadRequest)
+		return
+	}
+	result, err := RestoreBackup(config.NewConfig(), req.Path, req.Passphrase, req.RestoreMetadata)
+	if err != nil {
+		fmt.Printf("Error restoring backup: %v\n", err)
+		http.Error(w, fmt.Sprintf("Failed to restore backup: %v", err), http.StatusBadRequest)
+		return
+	}
+	json.NewEncoder(w).Encode(result)
+}
+
+// SetScheduleHandler enables or disables scheduled backups. Body: {"enabled", "intervalHours", "keep", "passphrase"}
+func SetScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	cfg := config.NewConfig()
+	var req struct {
+		Enabled       bool   `json:"enabled"`
+		IntervalHours int    `json:"intervalHours"`
+		Keep          int    `json:"keep"`
+		Passphrase    string `json:"passphrase"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	if req.Enabled && req.Passphrase == "" {
+		http.Error(w, "'passphrase' is required to enable scheduled backups", http.StatusBadRequest)
+		return
+	}
+	if req.IntervalHours <= 0 {
+		req.IntervalHours = int(cfg.BackupInterval / time.Hour)
+	}
+	if req.Keep <= 0 {
+		req.Keep = cfg.BackupKeep
+	}
+
+	scheduleMutex.Lock()
+	defer scheduleMutex.Unlock()
+	if schedule.cancel != nil {
+		schedule.cancel()
+		schedule.cancel = nil
+	}
+	schedule.Enabled = req.Enabled
+	schedule.IntervalHours = req.IntervalHours
+	schedule.Keep = req.Keep
+	schedule.passphrase = req.Passphrase
+	schedule.PassphraseRequired = false
+	if req.Enabled {
+		ctx, cancel := context.WithCancel(context.Background())
+		schedule.cancel = cancel
+		go runSchedule(ctx, time.Duration(req.IntervalHours)*time.Hour)
+	} else {
+		schedule.passphrase = ""
+	}
+	saveScheduleLocked()
+	json.NewEncoder(w).Encode(schedule)
+}
+
+func GetScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	scheduleMutex.Lock()
+	defer scheduleMutex.Unlock()
+	json.NewEncoder(w).Encode(schedule)
+}
@@ -0,0 +1,136 @@
+package bandwidth
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// Limiter is a token bucket shared by every transfer it throttles. A rate of 0 means unlimited
+type Limiter struct {
+	mu     sync.Mutex
+	rate   int64 // bytes per second
+	tokens float64
+	last   time.Time
+}
+
+func NewLimiter(bytesPerSecond int64) *Limiter {
+	return &Limiter{rate: bytesPerSecond, last: time.Now()}
+}
+
+func (l *Limiter) SetRate(bytesPerSecond int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rate = bytesPerSecond
+	l.tokens = 0
+	l.last = time.Now()
+}
+
+func (l *Limiter) Rate() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rate
+}
+
+// WaitN blocks until n bytes may be transferred or ctx is done
+func (l *Limiter) WaitN(ctx context.Context, n int) error {
+	for {
+		l.mu.Lock()
+		if l.rate <= 0 {
+			l.mu.Unlock()
+			return nil
+		}
+		now := time.Now()
+		// a burst of one second's worth of bytes at most
+		l.tokens += now.Sub(l.last).Seconds() * float64(l.rate)
+		if l.tokens > float64(l.rate) {
+			l.tokens = float64(l.rate)
+		}
+		l.last = now
+		if l.tokens >= float64(n) || (n > int(l.rate) && l.tokens >= float64(l.rate)) {
+			l.tokens -= float64(n)
+			l.mu.Unlock()
+			return nil
+		}
+		wait := time.Duration((float64(n) - l.tokens) / float64(l.rate) * float64(time.Second))
+		l.mu.Unlock()
+		if wait > time.Second {
+			wait = time.Second
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// Chunk size used by Reader and Writer so a single call never holds more than a small share of the bucket
+const chunkSize = 16 * 1024
+
+// Reader throttles reads through every limiter given, e.g. a global and a per-peer limiter
+type Reader struct {
+	ctx      context.Context
+	r        io.Reader
+	limiters []*Limiter
+}
+
+func NewReader(ctx context.Context, r io.Reader, limiters ...*Limiter) *Reader {
+	return &Reader{ctx: ctx, r: r, limiters: limiters}
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	if len(p) > chunkSize {
+		p = p[:chunkSize]
+	}
+	n, err := r.r.Read(p)
+	for _, l := range r.limiters {
+		if l == nil || n == 0 {
+			continue
+		}
+		if werr := l.WaitN(r.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+// Writer throttles writes through every limiter given
+type Writer struct {
+	ctx      context.Context
+	w        io.Writer
+	limiters []*Limiter
+}
+
+func NewWriter(ctx context.Context, w io.Writer, limiters ...*Limiter) *Writer {
+	return &Writer{ctx: ctx, w: w, limiters: limiters}
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > chunkSize {
+			chunk = chunk[:chunkSize]
+		}
+		for _, l := range w.limiters {
+			if l == nil {
+				continue
+			}
+			if err := l.WaitN(w.ctx, len(chunk)); err != nil {
+				return written, err
+			}
+		}
+		n, err := w.w.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
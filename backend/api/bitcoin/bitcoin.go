@@ -1,9 +1,8 @@
 package bitcoin
 
 import (
-	"Otternet/backend/api/handlers"
+	"Otternet/backend/api/addressbook"
 	"Otternet/backend/config"
-	"Otternet/backend/global"
 	"bytes"
 	"encoding/json"
 	"fmt"
@@ -323,6 +322,18 @@ func (bc *BitcoinClient) BackupWallet(walletName string, destination string) err
 	return nil
 }
 
+// Restores a wallet from a backupwallet file. bitcoind must be able to read backupFile
+func (bc *BitcoinClient) RestoreWallet(walletName string, backupFile string) error {
+	response, err := bc.call("restorewallet", []interface{}{walletName, backupFile}, "")
+	if err != nil {
+		return fmt.Errorf("failed to restore wallet: %w", err)
+	}
+	if err := rpcError(response); err != nil {
+		return fmt.Errorf("failed to restore wallet %s: %w", walletName, err)
+	}
+	return nil
+}
+
 func (bc *BitcoinClient) GetTransactions(walletName string) ([]map[string]interface{}, error) {
 	response, err := bc.call("listtransactions", []interface{}{}, walletName)
 	if err != nil {
@@ -349,11 +360,25 @@ func (bc *BitcoinClient) GetTransactions(walletName string) ([]map[string]interf
 }
 
 func (bc *BitcoinClient) TransferCoins(walletName string, toAddress string, amount float64, label string) (string, error) {
+	return bc.TransferCoinsWithFee(walletName, toAddress, amount, label, FeeStrategy{})
+}
+
+// Sends coins using the given fee strategy. Transactions are always marked replaceable so they can be fee bumped later
+func (bc *BitcoinClient) TransferCoinsWithFee(walletName string, toAddress string, amount float64, label string, fee FeeStrategy) (string, error) {
+	if err := fee.Validate(); err != nil {
+		return "", err
+	}
+	confTarget, estimateMode, feeRate := fee.rpcParams()
+
 	// label for determining whether transaction is file or proxy related
-	response, err := bc.call("sendtoaddress", []interface{}{toAddress, amount, "", label}, walletName)
+	// params: address, amount, comment, comment_to, subtractfeefromamount, replaceable, conf_target, estimate_mode, avoid_reuse, fee_rate
+	response, err := bc.call("sendtoaddress", []interface{}{toAddress, amount, "", label, false, true, confTarget, estimateMode, nil, feeRate}, walletName)
 	if err != nil {
 		return "", fmt.Errorf("failed to send coins: %w", err)
 	}
+	if err := rpcError(response); err != nil {
+		return "", fmt.Errorf("failed to send coins: %w", err)
+	}
 
 	// Extract Transaction ID
 	transactionID, ok := response["result"].(string)
@@ -364,16 +389,179 @@ func (bc *BitcoinClient) TransferCoins(walletName string, toAddress string, amou
 	return transactionID, nil
 }
 
-func GetDestinationAddress(peerInfo peer.AddrInfo) (string, error) {
-	stream, err := global.DHTNode.Host.NewStream(global.DHTNode.Ctx, peerInfo.ID, handlers.WalletAddressReqHandler)
+// Estimates the fee for a transfer without broadcasting it. A funded PSBT is built by the wallet so the
+// fee reflects the wallet's actual coin selection
+func (bc *BitcoinClient) PreviewTransfer(walletName string, toAddress string, amount float64, fee FeeStrategy) (*TransferPreview, error) {
+	if err := fee.Validate(); err != nil {
+		return nil, err
+	}
+	options := fee.fundingOptions()
+	outputs := []interface{}{map[string]interface{}{toAddress: amount}}
+	response, err := bc.call("walletcreatefundedpsbt", []interface{}{[]interface{}{}, outputs, 0, options}, walletName)
 	if err != nil {
-		return "", fmt.Errorf("failed to open stream: %w", err)
+		return nil, fmt.Errorf("failed to preview transfer: %w", err)
 	}
-	defer stream.Close()
-	decoder := json.NewDecoder(stream)
-	var address string
-	if err := decoder.Decode(&address); err != nil {
-		return "", fmt.Errorf("failed to decode address: %w", err)
+	if err := rpcError(response); err != nil {
+		return nil, fmt.Errorf("failed to preview transfer: %w", err)
 	}
-	return address, nil
+	result, ok := response["result"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("unexpected result type: %T", response["result"])
+	}
+	feeAmount, ok := result["fee"].(float64)
+	if !ok {
+		return nil, fmt.Errorf("unexpected fee type: %T", result["fee"])
+	}
+
+	preview := &TransferPreview{
+		Amount:   amount,
+		Fee:      feeAmount,
+		Total:    amount + feeAmount,
+		Strategy: fee,
+	}
+
+	// estimatesmartfee is informational only; regtest and fresh nodes often lack enough data for an estimate
+	if fee.FeeRate == 0 {
+		confTarget := fee.ConfTarget
+		if confTarget == 0 {
+			confTarget = DefaultConfTarget
+		}
+		estimate, err := bc.EstimateSmartFee(confTarget, fee.EstimateMode)
+		if err != nil {
+			fmt.Printf("Fee estimate unavailable: %v\n", err)
+		} else {
+			preview.EstimatedFeeRate = estimate
+		}
+	} else {
+		preview.EstimatedFeeRate = fee.FeeRate
+	}
+
+	return preview, nil
+}
+
+// Returns the estimated fee rate in sat/vB for confirmation within confTarget blocks
+func (bc *BitcoinClient) EstimateSmartFee(confTarget int, estimateMode string) (float64, error) {
+	if estimateMode == "" {
+		estimateMode = "conservative"
+	}
+	response, err := bc.call("estimatesmartfee", []interface{}{confTarget, estimateMode}, "")
+	if err != nil {
+		return 0, fmt.Errorf("failed to estimate fee: %w", err)
+	}
+	if err := rpcError(response); err != nil {
+		return 0, fmt.Errorf("failed to estimate fee: %w", err)
+	}
+	result, ok := response["result"].(map[string]interface{})
+	if !ok {
+		return 0, fmt.Errorf("unexpected result type: %T", response["result"])
+	}
+	// feerate is reported in BTC/kvB
+	feeRate, ok := result["feerate"].(float64)
+	if !ok {
+		return 0, fmt.Errorf("insufficient data to estimate fee")
+	}
+	return feeRate * 1e8 / 1000, nil
+}
+
+// Replaces a stuck wallet transaction with a higher fee version of itself (BIP 125)
+func (bc *BitcoinClient) BumpFee(walletName string, txid string, fee FeeStrategy) (*BumpFeeResult, error) {
+	if err := fee.Validate(); err != nil {
+		return nil, err
+	}
+	response, err := bc.call("bumpfee", []interface{}{txid, fee.fundingOptions()}, walletName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to bump fee: %w", err)
+	}
+	if err := rpcError(response); err != nil {
+		return nil, fmt.Errorf("failed to bump fee: %w", err)
+	}
+	result, ok := response["result"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("unexpected result type: %T", response["result"])
+	}
+	bumped := &BumpFeeResult{OriginalTxID: txid}
+	bumped.TxID, _ = result["txid"].(string)
+	bumped.OriginalFee, _ = result["origfee"].(float64)
+	bumped.Fee, _ = result["fee"].(float64)
+	if bumped.TxID == "" {
+		return nil, fmt.Errorf("unexpected response format; no replacement transaction ID found")
+	}
+	return bumped, nil
+}
+
+func (bc *BitcoinClient) GetTransaction(walletName string, txid string) (map[string]interface{}, error) {
+	response, err := bc.call("gettransaction", []interface{}{txid}, walletName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get transaction: %w", err)
+	}
+	if err := rpcError(response); err != nil {
+		return nil, fmt.Errorf("failed to get transaction: %w", err)
+	}
+	result, ok := response["result"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("unexpected result type: %T", response["result"])
+	}
+	return result, nil
+}
+
+// Returns wallet transactions since blockHash (all transactions if empty) and the hash of the current tip
+func (bc *BitcoinClient) ListSinceBlock(walletName string, blockHash string) ([]map[string]interface{}, string, error) {
+	var since interface{}
+	if blockHash != "" {
+		since = blockHash
+	}
+	response, err := bc.call("listsinceblock", []interface{}{since}, walletName)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to list transactions since block: %w", err)
+	}
+	if err := rpcError(response); err != nil {
+		return nil, "", fmt.Errorf("failed to list transactions since block: %w", err)
+	}
+	result, ok := response["result"].(map[string]interface{})
+	if !ok {
+		return nil, "", fmt.Errorf("unexpected result type: %T", response["result"])
+	}
+	lastBlock, _ := result["lastblock"].(string)
+	rawTransactions, _ := result["transactions"].([]interface{})
+	transactions := make([]map[string]interface{}, 0, len(rawTransactions))
+	for _, tx := range rawTransactions {
+		if transaction, ok := tx.(map[string]interface{}); ok {
+			transactions = append(transactions, transaction)
+		}
+	}
+	return transactions, lastBlock, nil
+}
+
+// Returns the hash of the current chain tip
+func (bc *BitcoinClient) GetBestBlockHash() (string, error) {
+	response, err := bc.call("getbestblockhash", []interface{}{}, "")
+	if err != nil {
+		return "", fmt.Errorf("failed to get best block hash: %w", err)
+	}
+	if err := rpcError(response); err != nil {
+		return "", fmt.Errorf("failed to get best block hash: %w", err)
+	}
+	hash, ok := response["result"].(string)
+	if !ok {
+		return "", fmt.Errorf("unexpected result type: %T", response["result"])
+	}
+	return hash, nil
+}
+
+// Extracts the error object bitcoind returns alongside a null result
+func rpcError(response map[string]interface{}) error {
+	if response == nil || response["error"] == nil {
+		return nil
+	}
+	if errObj, ok := response["error"].(map[string]interface{}); ok {
+		if message, ok := errObj["message"].(string); ok {
+			return fmt.Errorf("%s", message)
+		}
+	}
+	return fmt.Errorf("%v", response["error"])
+}
+
+// Returns the verified wallet address of a peer, using the address book cache when possible
+func GetDestinationAddress(peerInfo peer.AddrInfo) (string, error) {
+	return addressbook.Resolve(peerInfo.ID)
 }
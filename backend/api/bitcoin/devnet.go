@@ -0,0 +1,282 @@
+package bitcoin
+
+import (
+	"Otternet/backend/config"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// How often the mempool is checked when mining on demand
+const mempoolCheckInterval = 5 * time.Second
+
+// Blocks needed before a coinbase output can be spent on regtest
+const coinbaseMaturity = 101
+
+type devnetSettings struct {
+	Enabled         bool    `json:"enabled"`
+	IntervalSeconds int     `json:"intervalSeconds"` // 0 disables timed mining
+	OnMempool       bool    `json:"onMempool"`
+	LastMined       string  `json:"lastMined,omitempty"`
+	BlocksMined     int     `json:"blocksMined"`
+	FaucetAmount    float64 `json:"faucetAmount"`
+}
+
+var (
+	devnetMutex     = &sync.Mutex{}
+	devnet          devnetSettings
+	faucetLastSends = make(map[string]time.Time) // address -> last faucet payout
+)
+
+// Returns the chain bitcoind is running on: "main", "test", "testnet4", "signet" or "regtest"
+func (bc *BitcoinClient) GetChain() (string, error) {
+	response, err := bc.call("getblockchaininfo", []interface{}{}, "")
+	if err != nil {
+		return "", fmt.Errorf("failed to get blockchain info: %w", err)
+	}
+	if err := rpcError(response); err != nil {
+		return "", fmt.Errorf("failed to get blockchain info: %w", err)
+	}
+	result, ok := response["result"].(map[string]interface{})
+	if !ok {
+		return "", fmt.Errorf("unexpected result type: %T", response["result"])
+	}
+	chain, ok := result["chain"].(string)
+	if !ok {
+		return "", fmt.Errorf("unexpected chain type: %T", result["chain"])
+	}
+	return chain, nil
+}
+
+func (bc *BitcoinClient) MempoolSize() (int, error) {
+	response, err := bc.call("getmempoolinfo", []interface{}{}, "")
+	if err != nil {
+		return 0, fmt.Errorf("failed to get mempool info: %w", err)
+	}
+	if err := rpcError(response); err != nil {
+		return 0, fmt.Errorf("failed to get mempool info: %w", err)
+	}
+	result, ok := response["result"].(map[string]interface{})
+	if !ok {
+		return 0, fmt.Errorf("unexpected result type: %T", response["result"])
+	}
+	size, _ := result["size"].(float64)
+	return int(size), nil
+}
+
+// Reports an error unless bitcoind is running on regtest. Mining and the faucet are never available elsewhere
+func (bc *BitcoinClient) requireRegtest() error {
+	chain, err := bc.GetChain()
+	if err != nil {
+		return err
+	}
+	if chain != "regtest" {
+		return fmt.Errorf("only available on regtest; node is running on %s", chain)
+	}
+	return nil
+}
+
+// Loads the devnet wallet, creating it on first use, and returns a fresh address in it
+func (bc *BitcoinClient) devnetAddress(cfg *config.Config) (string, error) {
+	if _, err := bc.LoadWallet(cfg.DevnetWallet); err != nil {
+		fmt.Printf("Devnet wallet not loaded: %v\n", err)
+	}
+	address, err := bc.GenerateNewAddress(cfg.DevnetWallet)
+	if err == nil {
+		return address, nil
+	}
+	if _, err := bc.CreateNewWallet(cfg.DevnetWallet); err != nil {
+		return "", fmt.Errorf("failed to create devnet wallet: %w", err)
+	}
+	return bc.GenerateNewAddress(cfg.DevnetWallet)
+}
+
+func (bc *BitcoinClient) mineDevnetBlocks(cfg *config.Config, count int) ([]string, error) {
+	address, err := bc.devnetAddress(cfg)
+	if err != nil {
+		return nil, err
+	}
+	hashes, err := bc.MineCoins(address, count)
+	if err != nil {
+		return nil, err
+	}
+	devnetMutex.Lock()
+	devnet.BlocksMined += len(hashes)
+	devnet.LastMined = time.Now().Format(time.RFC3339)
+	devnetMutex.Unlock()
+	return hashes, nil
+}
+
+// RunDevnetMiner mines blocks on a timer and/or whenever the mempool is non-empty. It exits immediately unless
+// bitcoind is on regtest
+func RunDevnetMiner(ctx context.Context) {
+	cfg := config.NewConfig()
+	btcClient := NewBitcoinClient(cfg)
+	if err := btcClient.requireRegtest(); err != nil {
+		fmt.Printf("Devnet miner disabled: %v\n", err)
+		return
+	}
+
+	devnetMutex.Lock()
+	devnet.Enabled = true
+	devnet.IntervalSeconds = int(cfg.AutoMineInterval / time.Second)
+	devnet.OnMempool = cfg.AutoMineOnMempool
+	devnet.FaucetAmount = cfg.FaucetAmount
+	devnetMutex.Unlock()
+	fmt.Println("Devnet miner started")
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	lastMined := time.Now()
+	lastMempoolCheck := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		devnetMutex.Lock()
+		settings := devnet
+		devnetMutex.Unlock()
+		if !settings.Enabled {
+			continue
+		}
+
+		mine := false
+		if settings.IntervalSeconds > 0 && time.Since(lastMined) >= time.Duration(settings.IntervalSeconds)*time.Second {
+			mine = true
+		} else if settings.OnMempool && time.Since(lastMempoolCheck) >= mempoolCheckInterval {
+			lastMempoolCheck = time.Now()
+			size, err := btcClient.MempoolSize()
+			if err != nil {
+				fmt.Printf("Devnet miner: %v\n", err)
+				continue
+			}
+			mine = size > 0
+		}
+		if !mine {
+			continue
+		}
+		if _, err := btcClient.mineDevnetBlocks(cfg, 1); err != nil {
+			fmt.Printf("Devnet miner: %v\n", err)
+			continue
+		}
+		lastMined = time.Now()
+	}
+}
+
+// GetDevnetStatusHandler reports the chain and the auto-miner settings
+func GetDevnetStatusHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	cfg := config.NewConfig()
+	btcClient := NewBitcoinClient(cfg)
+	chain, err := btcClient.GetChain()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	devnetMutex.Lock()
+	settings := devnet
+	devnetMutex.Unlock()
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"chain":    chain,
+		"regtest":  chain == "regtest",
+		"autoMine": settings,
+	})
+}
+
+// SetAutoMineHandler updates the auto-miner settings on regtest
+func SetAutoMineHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	cfg := config.NewConfig()
+	btcClient := NewBitcoinClient(cfg)
+	if err := btcClient.requireRegtest(); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	var req struct {
+		Enabled         bool `json:"enabled"`
+		IntervalSeconds int  `json:"intervalSeconds"`
+		OnMempool       bool `json:"onMempool"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.IntervalSeconds < 0 {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	devnetMutex.Lock()
+	devnet.Enabled = req.Enabled
+	devnet.IntervalSeconds = req.IntervalSeconds
+	devnet.OnMempool = req.OnMempool
+	settings := devnet
+	devnetMutex.Unlock()
+	json.NewEncoder(w).Encode(settings)
+}
+
+// FaucetHandler sends test coins from the devnet wallet, at most once per FaucetCooldown per address
+func FaucetHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	address := mux.Vars(r)["address"]
+	if address == "" {
+		http.Error(w, "Invalid address", http.StatusBadRequest)
+		return
+	}
+	cfg := config.NewConfig()
+	btcClient := NewBitcoinClient(cfg)
+	if err := btcClient.requireRegtest(); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if valid, err := btcClient.ValidateBitcoinAddress(address); err != nil || !valid {
+		http.Error(w, "Invalid address", http.StatusBadRequest)
+		return
+	}
+
+	devnetMutex.Lock()
+	for other, last := range faucetLastSends {
+		if time.Since(last) >= cfg.FaucetCooldown {
+			delete(faucetLastSends, other)
+		}
+	}
+	if last, ok := faucetLastSends[address]; ok {
+		retryAfter := cfg.FaucetCooldown - time.Since(last)
+		devnetMutex.Unlock()
+		w.Header().Set("Retry-After", fmt.Sprintf("%d", int(retryAfter.Seconds())))
+		http.Error(w, fmt.Sprintf("Faucet already used by this address; try again in %s", retryAfter.Round(time.Second)), http.StatusTooManyRequests)
+		return
+	}
+	faucetLastSends[address] = time.Now()
+	amount := devnet.FaucetAmount
+	devnetMutex.Unlock()
+	if amount <= 0 {
+		amount = cfg.FaucetAmount
+	}
+
+	// make sure the faucet has mature coins to give out
+	if _, err := btcClient.devnetAddress(cfg); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	balance, err := btcClient.GetBalance(cfg.DevnetWallet)
+	if err == nil && balance < amount {
+		if _, err := btcClient.mineDevnetBlocks(cfg, coinbaseMaturity); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to fund faucet: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	txid, err := btcClient.TransferCoins(cfg.DevnetWallet, address, amount, "Faucet")
+	if err != nil {
+		devnetMutex.Lock()
+		delete(faucetLastSends, address)
+		devnetMutex.Unlock()
+		http.Error(w, fmt.Sprintf("Faucet payment failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"transactionID": txid, "amount": amount})
+}
@@ -0,0 +1,98 @@
+package bitcoin
+
+import (
+	"Otternet/backend/api/addressbook"
+	"fmt"
+	"strings"
+)
+
+// Confirmation target used when a transfer does not specify a fee strategy
+const DefaultConfTarget = 6
+
+// Describes how the wallet should pick a fee for a transfer. At most one of ConfTarget or FeeRate should be set;
+// EstimateMode only applies when the fee is estimated from ConfTarget
+type FeeStrategy struct {
+	ConfTarget   int     `json:"confTarget"`   // target number of blocks for confirmation; 0 uses the wallet default
+	FeeRate      float64 `json:"feeRate"`      // explicit fee rate in sat/vB
+	EstimateMode string  `json:"estimateMode"` // "economical", "conservative" or "unset"
+}
+
+// Body accepted by TransferCoinsHandler. FileHash and PeerID link the payment to the download or proxy it pays for
+type TransferRequest struct {
+	FeeStrategy
+	FileHash string `json:"fileHash"`
+	PeerID   string `json:"peerID"`
+}
+
+// Checks toAddress against the payee's pinned or attested address; payments not linked to a peer are not checked
+func (req TransferRequest) checkPayee(toAddress string) error {
+	if req.PeerID == "" {
+		return nil
+	}
+	return addressbook.CheckClaim(req.PeerID, toAddress)
+}
+
+type TransferPreview struct {
+	Amount           float64     `json:"amount"`
+	Fee              float64     `json:"fee"`
+	Total            float64     `json:"total"`
+	EstimatedFeeRate float64     `json:"estimatedFeeRate"` // sat/vB, 0 if no estimate was available
+	Strategy         FeeStrategy `json:"strategy"`
+}
+
+type BumpFeeResult struct {
+	OriginalTxID string  `json:"originalTxID"`
+	TxID         string  `json:"txID"`
+	OriginalFee  float64 `json:"originalFee"`
+	Fee          float64 `json:"fee"`
+}
+
+func (f *FeeStrategy) Validate() error {
+	f.EstimateMode = strings.ToLower(strings.TrimSpace(f.EstimateMode))
+	switch f.EstimateMode {
+	case "", "unset", "economical", "conservative":
+	default:
+		return fmt.Errorf("invalid estimate mode %q; use economical, conservative or unset", f.EstimateMode)
+	}
+	if f.ConfTarget < 0 || f.ConfTarget > 1008 {
+		return fmt.Errorf("confirmation target must be between 1 and 1008 blocks, or 0 for the wallet default")
+	}
+	if f.FeeRate < 0 {
+		return fmt.Errorf("fee rate must be positive")
+	}
+	if f.FeeRate > 0 && (f.ConfTarget > 0 || f.EstimateMode != "") {
+		return fmt.Errorf("an explicit fee rate cannot be combined with a confirmation target or estimate mode")
+	}
+	return nil
+}
+
+// Positional conf_target, estimate_mode and fee_rate arguments for sendtoaddress. Unused arguments are sent as null
+func (f FeeStrategy) rpcParams() (interface{}, interface{}, interface{}) {
+	var confTarget, estimateMode, feeRate interface{}
+	if f.FeeRate > 0 {
+		return nil, nil, f.FeeRate
+	}
+	if f.ConfTarget > 0 {
+		confTarget = f.ConfTarget
+	}
+	if f.EstimateMode != "" {
+		estimateMode = f.EstimateMode
+	}
+	return confTarget, estimateMode, feeRate
+}
+
+// Options object for walletcreatefundedpsbt
+func (f FeeStrategy) fundingOptions() map[string]interface{} {
+	options := map[string]interface{}{"replaceable": true}
+	if f.FeeRate > 0 {
+		options["fee_rate"] = f.FeeRate
+		return options
+	}
+	if f.ConfTarget > 0 {
+		options["conf_target"] = f.ConfTarget
+	}
+	if f.EstimateMode != "" {
+		options["estimate_mode"] = f.EstimateMode
+	}
+	return options
+}
@@ -4,6 +4,7 @@ import (
 	"Otternet/backend/config"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -353,22 +354,160 @@ func TransferCoinsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Optional fee strategy and payment link in the request body; bitcoind's fallback fee is used when omitted
+	var transferReq TransferRequest
+	if err := decodeJSONBody(r, &transferReq); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	fee := transferReq.FeeStrategy
+	if err := fee.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Refuse to pay an address that contradicts the payee's pinned or attested address
+	if err := transferReq.checkPayee(toAddress); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
 	// Initialize config and Bitcoin client
 	cfg := config.NewConfig()
 	btcClient := NewBitcoinClient(cfg)
 
+	// Watch-only wallets cannot sign; hand back a PSBT for the external signer instead
+	watchOnly, err := btcClient.IsWatchOnly(walletName)
+	if err != nil {
+		fmt.Printf("Error checking wallet mode: %v\n", err)
+	}
+	if watchOnly {
+		pending, err := btcClient.CreatePaymentPSBT(walletName, toAddress, amount, label, transferReq)
+		if err != nil {
+			fmt.Printf("Error creating PSBT: %v\n", err)
+			http.Error(w, fmt.Sprintf("Failed to create PSBT: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]string{"psbtID": pending.ID, "psbt": pending.PSBT, "status": pending.Status})
+		return
+	}
+
 	// Perform coin transfer using Bitcoin RPC
-	transactionID, err := btcClient.TransferCoins(walletName, toAddress, amount, label)
+	transactionID, err := btcClient.TransferCoinsWithFee(walletName, toAddress, amount, label, fee)
 	if err != nil {
 		fmt.Printf("Error with coin transaction: %v\n", err)
 		http.Error(w, fmt.Sprintf("Failed to transfer coins: %v\n", err), http.StatusInternalServerError)
 		return
 	}
 
+	// Follow the payment until it confirms, updating the download or proxy record it pays for
+	err = TrackTransaction(TrackedTransaction{
+		TxID:       transactionID,
+		WalletName: walletName,
+		Direction:  "send",
+		Address:    toAddress,
+		Amount:     amount,
+		Label:      label,
+		FileHash:   transferReq.FileHash,
+		PeerID:     transferReq.PeerID,
+	})
+	if err != nil {
+		fmt.Printf("Error tracking transaction %s: %v\n", transactionID, err)
+	}
+
 	// Encode JSON response with transaction ID
 	json.NewEncoder(w).Encode(map[string]string{"transactionID": transactionID})
 }
 
+// Returns the estimated fee and total cost of a transfer without sending it
+func PreviewTransferHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	vars := mux.Vars(r)
+	walletName := vars["walletName"]
+	toAddress := vars["toAddress"]
+	amountStr := vars["amount"]
+	if walletName == "" || toAddress == "" || amountStr == "" {
+		http.Error(w, "'walletName', 'toAddress', or 'amount' parameter(s) are missing", http.StatusBadRequest)
+		return
+	}
+	amount, err := strconv.ParseFloat(amountStr, 64)
+	if err != nil || amount <= 0 {
+		http.Error(w, "'amount' must be a valid positive number", http.StatusBadRequest)
+		return
+	}
+	fee, err := decodeFeeStrategy(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	cfg := config.NewConfig()
+	btcClient := NewBitcoinClient(cfg)
+
+	preview, err := btcClient.PreviewTransfer(walletName, toAddress, amount, fee)
+	if err != nil {
+		fmt.Printf("Error previewing transfer: %v\n", err)
+		http.Error(w, fmt.Sprintf("Failed to preview transfer: %v", err), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(preview)
+}
+
+// Replaces a stuck transaction with one paying a higher fee
+func BumpFeeHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	vars := mux.Vars(r)
+	walletName := vars["walletName"]
+	txid := vars["txid"]
+	if walletName == "" || txid == "" {
+		http.Error(w, "'walletName' or 'txid' parameter(s) are missing", http.StatusBadRequest)
+		return
+	}
+	fee, err := decodeFeeStrategy(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	cfg := config.NewConfig()
+	btcClient := NewBitcoinClient(cfg)
+
+	result, err := btcClient.BumpFee(walletName, txid, fee)
+	if err != nil {
+		fmt.Printf("Error bumping fee: %v\n", err)
+		http.Error(w, fmt.Sprintf("Failed to bump fee: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if err := ReplaceTrackedTransaction(result.OriginalTxID, result.TxID); err != nil {
+		fmt.Printf("Replacement of %s not tracked: %v\n", txid, err)
+	}
+	json.NewEncoder(w).Encode(result)
+}
+
+// Reads an optional FeeStrategy from the request body. An empty body yields the zero strategy
+func decodeFeeStrategy(r *http.Request) (FeeStrategy, error) {
+	var fee FeeStrategy
+	if err := decodeJSONBody(r, &fee); err != nil {
+		return fee, err
+	}
+	if err := fee.Validate(); err != nil {
+		return fee, err
+	}
+	return fee, nil
+}
+
+// Decodes an optional JSON request body into v, leaving v untouched if the body is empty
+func decodeJSONBody(r *http.Request, v interface{}) error {
+	if r.Body == nil {
+		return nil
+	}
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil && err != io.EOF {
+		return fmt.Errorf("invalid request body: %v", err)
+	}
+	return nil
+}
+
 func MineCoinsHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	vars := mux.Vars(r)
@@ -392,6 +531,12 @@ func MineCoinsHandler(w http.ResponseWriter, r *http.Request) {
 	cfg := config.NewConfig()
 	btcClient := NewBitcoinClient(cfg)
 
+	// Mining is a development tool and is disabled on mainnet and testnets
+	if err := btcClient.requireRegtest(); err != nil {
+		http.Error(w, "Mining disabled: "+err.Error(), http.StatusForbidden)
+		return
+	}
+
 	// get all wallets
 	blockHashes, err := btcClient.MineCoins(address, amount)
 	if err != nil {
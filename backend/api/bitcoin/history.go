@@ -0,0 +1,341 @@
+package bitcoin
+
+import (
+	"Otternet/backend/api/download"
+	"Otternet/backend/api/proxy"
+	"Otternet/backend/config"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	historyBatchSize       = 500
+	defaultHistoryPageSize = 50
+	maxHistoryPageSize     = 1000
+)
+
+// HistoryEntry is a wallet transaction joined with the download or proxy record it paid for, where known
+type HistoryEntry struct {
+	TxID          string  `json:"txID"`
+	Time          string  `json:"time"`
+	Category      string  `json:"category"`  // label passed to TransferCoins, e.g. "File" or "Proxy"
+	Direction     string  `json:"direction"` // "send", "receive", "generate" or "immature"
+	Address       string  `json:"address"`
+	Amount        float64 `json:"amount"`
+	Fee           float64 `json:"fee"`
+	Confirmations int     `json:"confirmations"`
+	PeerID        string  `json:"peerID,omitempty"`
+	FileHash      string  `json:"fileHash,omitempty"`
+	FileName      string  `json:"fileName,omitempty"`
+	PaymentStatus string  `json:"paymentStatus,omitempty"`
+
+	unixTime int64
+}
+
+// HistoryFilter narrows the transaction history. Zero values match everything
+type HistoryFilter struct {
+	Category  string
+	Direction string
+	PeerID    string
+	From      time.Time
+	To        time.Time
+}
+
+type HistoryPage struct {
+	Page         int            `json:"page"`
+	PageSize     int            `json:"pageSize"`
+	Total        int            `json:"total"`
+	Transactions []HistoryEntry `json:"transactions"`
+}
+
+// Returns every transaction of the wallet, paging through listtransactions in batches
+func (bc *BitcoinClient) ListAllTransactions(walletName string) ([]map[string]interface{}, error) {
+	transactions := []map[string]interface{}{}
+	for skip := 0; ; skip += historyBatchSize {
+		response, err := bc.call("listtransactions", []interface{}{"*", historyBatchSize, skip}, walletName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get transactions: %w", err)
+		}
+		if err := rpcError(response); err != nil {
+			return nil, fmt.Errorf("failed to get transactions: %w", err)
+		}
+		result, ok := response["result"].([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("unexpected result type: expected []interface{}, got %T", response["result"])
+		}
+		for _, tx := range result {
+			if transaction, ok := tx.(map[string]interface{}); ok {
+				transactions = append(transactions, transaction)
+			}
+		}
+		if len(result) < historyBatchSize {
+			return transactions, nil
+		}
+	}
+}
+
+// Builds the wallet's history, newest first, joined with tracked payments, downloads and proxy payments
+func buildHistory(btcClient *BitcoinClient, walletName string) ([]HistoryEntry, error) {
+	transactions, err := btcClient.ListAllTransactions(walletName)
+	if err != nil {
+		return nil, err
+	}
+
+	trackedMutex.Lock()
+	state, err := readTracked()
+	trackedMutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	// keyed by txid and by txid/address, since a transaction paying several peers is tracked once per payment
+	tracked := make(map[string]TrackedTransaction, 2*len(state.Transactions))
+	for _, tx := range state.Transactions {
+		tracked[tx.TxID] = tx
+		tracked[tx.TxID+"/"+tx.Address] = tx
+	}
+
+	downloads, err := download.LoadDownloads()
+	if err != nil {
+		fmt.Printf("Transaction history: %v\n", err)
+	}
+	downloadsByTx := make(map[string]download.FormData, len(downloads))
+	for _, d := range downloads {
+		if d.PaymentTxID != "" {
+			downloadsByTx[d.PaymentTxID] = d
+		}
+	}
+
+	proxyPayments, err := proxy.LoadPayments()
+	if err != nil {
+		fmt.Printf("Transaction history: %v\n", err)
+	}
+	proxyByTx := make(map[string]proxy.ProxyPayment, len(proxyPayments))
+	for _, p := range proxyPayments {
+		proxyByTx[p.TxID] = p
+	}
+
+	entries := make([]HistoryEntry, 0, len(transactions))
+	for _, tx := range transactions {
+		entry := HistoryEntry{}
+		entry.TxID, _ = tx["txid"].(string)
+		// TransferCoinsWithFee passes the payment's label as sendtoaddress's comment_to, reported back as "to"
+		entry.Category, _ = tx["to"].(string)
+		entry.Direction, _ = tx["category"].(string)
+		entry.Address, _ = tx["address"].(string)
+		entry.Amount, _ = tx["amount"].(float64)
+		entry.Fee, _ = tx["fee"].(float64)
+		if confirmations, ok := tx["confirmations"].(float64); ok {
+			entry.Confirmations = int(confirmations)
+		}
+		if unixTime, ok := tx["time"].(float64); ok {
+			entry.unixTime = int64(unixTime)
+			entry.Time = time.Unix(entry.unixTime, 0).UTC().Format(time.RFC3339)
+		}
+
+		t, ok := tracked[entry.TxID+"/"+entry.Address]
+		if !ok {
+			t, ok = tracked[entry.TxID]
+		}
+		if ok {
+			entry.PeerID = t.PeerID
+			entry.FileHash = t.FileHash
+			entry.PaymentStatus = t.Status
+			if entry.Category == "" {
+				entry.Category = t.Label
+			}
+		}
+		if d, ok := downloadsByTx[entry.TxID]; ok {
+			entry.PeerID = d.SrcID
+			entry.FileHash = d.FileHash
+			entry.FileName = d.FileName
+			entry.PaymentStatus = d.PaymentStatus
+		}
+		if p, ok := proxyByTx[entry.TxID]; ok {
+			entry.PeerID = p.ProxyID
+			entry.PaymentStatus = p.Status
+		}
+		entries = append(entries, entry)
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].unixTime > entries[j].unixTime
+	})
+	return entries, nil
+}
+
+func (f HistoryFilter) matches(entry HistoryEntry) bool {
+	if f.Category != "" && !strings.EqualFold(entry.Category, f.Category) {
+		return false
+	}
+	if f.Direction != "" && !strings.EqualFold(entry.Direction, f.Direction) {
+		return false
+	}
+	if f.PeerID != "" && entry.PeerID != f.PeerID {
+		return false
+	}
+	if !f.From.IsZero() && entry.unixTime < f.From.Unix() {
+		return false
+	}
+	if !f.To.IsZero() && entry.unixTime > f.To.Unix() {
+		return false
+	}
+	return true
+}
+
+// Reads category, direction, peer, from and to query parameters. Dates are RFC3339 or YYYY-MM-DD
+func parseHistoryFilter(r *http.Request) (HistoryFilter, error) {
+	query := r.URL.Query()
+	filter := HistoryFilter{
+		Category:  query.Get("category"),
+		Direction: query.Get("direction"),
+		PeerID:    query.Get("peer"),
+	}
+	var err error
+	if from := query.Get("from"); from != "" {
+		if filter.From, err = parseHistoryDate(from, false); err != nil {
+			return filter, fmt.Errorf("invalid 'from' date: %v", err)
+		}
+	}
+	if to := query.Get("to"); to != "" {
+		if filter.To, err = parseHistoryDate(to, true); err != nil {
+			return filter, fmt.Errorf("invalid 'to' date: %v", err)
+		}
+	}
+	return filter, nil
+}
+
+// A plain date as an upper bound covers the whole day
+func parseHistoryDate(value string, endOfDay bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return t, err
+	}
+	if endOfDay {
+		t = t.Add(24*time.Hour - time.Second)
+	}
+	return t, nil
+}
+
+func filteredHistory(w http.ResponseWriter, r *http.Request) ([]HistoryEntry, bool) {
+	walletName := mux.Vars(r)["walletName"]
+	if walletName == "" {
+		http.Error(w, "Invalid wallet name", http.StatusBadRequest)
+		return nil, false
+	}
+	filter, err := parseHistoryFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+
+	cfg := config.NewConfig()
+	btcClient := NewBitcoinClient(cfg)
+	entries, err := buildHistory(btcClient, walletName)
+	if err != nil {
+		fmt.Printf("Error building transaction history: %v\n", err)
+		http.Error(w, fmt.Sprintf("Failed to fetch transaction history: %v", err), http.StatusInternalServerError)
+		return nil, false
+	}
+
+	filtered := []HistoryEntry{}
+	for _, entry := range entries {
+		if filter.matches(entry) {
+			filtered = append(filtered, entry)
+		}
+	}
+	return filtered, true
+}
+
+// GetTransactionHistoryHandler returns one page of the filtered history. Query: page (from 1), pageSize and the filters
+func GetTransactionHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	pageSize, err := strconv.Atoi(r.URL.Query().Get("pageSize"))
+	if err != nil || pageSize < 1 {
+		pageSize = defaultHistoryPageSize
+	}
+	if pageSize > maxHistoryPageSize {
+		pageSize = maxHistoryPageSize
+	}
+
+	entries, ok := filteredHistory(w, r)
+	if !ok {
+		return
+	}
+	start := (page - 1) * pageSize
+	if start > len(entries) {
+		start = len(entries)
+	}
+	end := start + pageSize
+	if end > len(entries) {
+		end = len(entries)
+	}
+	json.NewEncoder(w).Encode(HistoryPage{
+		Page:         page,
+		PageSize:     pageSize,
+		Total:        len(entries),
+		Transactions: entries[start:end],
+	})
+}
+
+// ExportTransactionsHandler downloads the whole filtered history as ?format=csv (default) or json
+func ExportTransactionsHandler(w http.ResponseWriter, r *http.Request) {
+	format := strings.ToLower(r.URL.Query().Get("format"))
+	if format == "" {
+		format = "csv"
+	}
+	if format != "csv" && format != "json" {
+		http.Error(w, "Invalid format; use csv or json", http.StatusBadRequest)
+		return
+	}
+	entries, ok := filteredHistory(w, r)
+	if !ok {
+		return
+	}
+
+	filename := fmt.Sprintf("%s-transactions-%s.%s", mux.Vars(r)["walletName"], time.Now().Format("20060102"), format)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	if format == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(entries)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"txid", "time", "category", "direction", "address", "amount", "fee", "confirmations", "peerID", "fileHash", "fileName", "paymentStatus"})
+	for _, e := range entries {
+		writer.Write([]string{
+			e.TxID,
+			e.Time,
+			e.Category,
+			e.Direction,
+			e.Address,
+			strconv.FormatFloat(e.Amount, 'f', 8, 64),
+			strconv.FormatFloat(e.Fee, 'f', 8, 64),
+			strconv.Itoa(e.Confirmations),
+			e.PeerID,
+			e.FileHash,
+			e.FileName,
+			e.PaymentStatus,
+		})
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		fmt.Printf("Error writing transaction export: %v\n", err)
+	}
+}
@@ -0,0 +1,533 @@
+package bitcoin
+
+import (
+	"Otternet/backend/config"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	PSBTAwaitingSignature = "awaiting_signature"
+	PSBTBroadcast         = "broadcast"
+	PSBTCancelled         = "cancelled"
+)
+
+const psbtFilePath = "./api/bitcoin/psbts.json"
+
+// PendingPSBT is a payment from a watch-only wallet waiting to be signed by an external signer
+type PendingPSBT struct {
+	ID         string  `json:"id"`
+	WalletName string  `json:"walletName"`
+	ToAddress  string  `json:"toAddress"`
+	Amount     float64 `json:"amount"`
+	Fee        float64 `json:"fee"`
+	Label      string  `json:"label"`
+	FileHash   string  `json:"fileHash,omitempty"`
+	PeerID     string  `json:"peerID,omitempty"`
+	PSBT       string  `json:"psbt"` // base64, unsigned
+	Status     string  `json:"status"`
+	TxID       string  `json:"txID,omitempty"`
+	CreatedAt  string  `json:"createdAt"`
+	UpdatedAt  string  `json:"updatedAt"`
+}
+
+var psbtMutex = &sync.Mutex{}
+
+func readPSBTs() ([]PendingPSBT, error) {
+	psbts := []PendingPSBT{}
+	data, err := os.ReadFile(psbtFilePath)
+	if os.IsNotExist(err) {
+		return psbts, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading PSBTs: %w", err)
+	}
+	if err := json.Unmarshal(data, &psbts); err != nil {
+		return nil, fmt.Errorf("error unmarshalling PSBTs: %w", err)
+	}
+	return psbts, nil
+}
+
+func writePSBTs(psbts []PendingPSBT) error {
+	data, err := json.MarshalIndent(psbts, "", " ")
+	if err != nil {
+		return fmt.Errorf("error marshalling PSBTs: %w", err)
+	}
+	return os.WriteFile(psbtFilePath, data, 0644)
+}
+
+func newPSBTID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Reports whether the wallet has private keys disabled, i.e. payments must be signed externally
+func (bc *BitcoinClient) IsWatchOnly(walletName string) (bool, error) {
+	response, err := bc.call("getwalletinfo", []interface{}{}, walletName)
+	if err != nil {
+		return false, fmt.Errorf("failed to get wallet info: %w", err)
+	}
+	if err := rpcError(response); err != nil {
+		return false, fmt.Errorf("failed to get wallet info: %w", err)
+	}
+	result, ok := response["result"].(map[string]interface{})
+	if !ok {
+		return false, fmt.Errorf("unexpected result type: %T", response["result"])
+	}
+	privateKeysEnabled, ok := result["private_keys_enabled"].(bool)
+	if !ok {
+		return false, fmt.Errorf("wallet info has no private_keys_enabled field")
+	}
+	return !privateKeysEnabled, nil
+}
+
+// Creates a descriptor wallet without private keys and imports the external signer's receive and change descriptors
+func (bc *BitcoinClient) CreateWatchOnlyWallet(walletName string, receiveDescriptor string, changeDescriptor string) error {
+	// params: wallet_name, disable_private_keys, blank, passphrase, avoid_reuse, descriptors
+	response, err := bc.call("createwallet", []interface{}{walletName, true, true, "", false, true}, "")
+	if err != nil {
+		return fmt.Errorf("failed to create watch-only wallet: %w", err)
+	}
+	if err := rpcError(response); err != nil {
+		return fmt.Errorf("failed to create watch-only wallet: %w", err)
+	}
+
+	requests := []interface{}{}
+	for i, desc := range []string{receiveDescriptor, changeDescriptor} {
+		if desc == "" {
+			continue
+		}
+		withChecksum, err := bc.descriptorWithChecksum(desc)
+		if err != nil {
+			return err
+		}
+		requests = append(requests, map[string]interface{}{
+			"desc":      withChecksum,
+			"timestamp": "now",
+			"active":    true,
+			"internal":  i == 1,
+		})
+	}
+	response, err = bc.call("importdescriptors", []interface{}{requests}, walletName)
+	if err != nil {
+		return fmt.Errorf("failed to import descriptors: %w", err)
+	}
+	if err := rpcError(response); err != nil {
+		return fmt.Errorf("failed to import descriptors: %w", err)
+	}
+	results, _ := response["result"].([]interface{})
+	for _, r := range results {
+		if result, ok := r.(map[string]interface{}); ok {
+			if success, _ := result["success"].(bool); !success {
+				return fmt.Errorf("descriptor import failed: %v", result["error"])
+			}
+		}
+	}
+	return nil
+}
+
+func (bc *BitcoinClient) descriptorWithChecksum(desc string) (string, error) {
+	response, err := bc.call("getdescriptorinfo", []interface{}{desc}, "")
+	if err != nil {
+		return "", fmt.Errorf("failed to validate descriptor: %w", err)
+	}
+	if err := rpcError(response); err != nil {
+		return "", fmt.Errorf("invalid descriptor: %w", err)
+	}
+	result, ok := response["result"].(map[string]interface{})
+	if !ok {
+		return "", fmt.Errorf("unexpected result type: %T", response["result"])
+	}
+	checksum, _ := result["checksum"].(string)
+	if i := strings.Index(desc, "#"); i >= 0 {
+		desc = desc[:i]
+	}
+	return desc + "#" + checksum, nil
+}
+
+// Builds an unsigned, funded PSBT for a payment and stores it until the signed version is uploaded
+func (bc *BitcoinClient) CreatePaymentPSBT(walletName string, toAddress string, amount float64, label string, req TransferRequest) (*PendingPSBT, error) {
+	if err := req.FeeStrategy.Validate(); err != nil {
+		return nil, err
+	}
+	if err := req.checkPayee(toAddress); err != nil {
+		return nil, err
+	}
+	// the inputs stay locked until the PSBT is broadcast or cancelled, so pending PSBTs never share coins. The locks
+	// are written to the wallet so they survive a bitcoind restart; walletcreatefundedpsbt's lockUnspents only keeps
+	// them in memory, so the inputs are locked separately, with psbtMutex held so no other PSBT picks them meanwhile
+	psbtMutex.Lock()
+	defer psbtMutex.Unlock()
+	options := req.FeeStrategy.fundingOptions()
+	outputs := []interface{}{map[string]interface{}{toAddress: amount}}
+	response, err := bc.call("walletcreatefundedpsbt", []interface{}{[]interface{}{}, outputs, 0, options, true}, walletName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create PSBT: %w", err)
+	}
+	if err := rpcError(response); err != nil {
+		return nil, fmt.Errorf("failed to create PSBT: %w", err)
+	}
+	result, ok := response["result"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("unexpected result type: %T", response["result"])
+	}
+
+	now := time.Now().Format(time.RFC3339)
+	pending := PendingPSBT{
+		ID:         newPSBTID(),
+		WalletName: walletName,
+		ToAddress:  toAddress,
+		Amount:     amount,
+		Label:      label,
+		FileHash:   req.FileHash,
+		PeerID:     req.PeerID,
+		Status:     PSBTAwaitingSignature,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+	pending.PSBT, _ = result["psbt"].(string)
+	pending.Fee, _ = result["fee"].(float64)
+	if pending.PSBT == "" {
+		return nil, fmt.Errorf("unexpected response format; no PSBT found")
+	}
+
+	if err := bc.lockPSBTInputs(walletName, pending.PSBT, false); err != nil {
+		return nil, err
+	}
+	psbts, err := readPSBTs()
+	if err == nil {
+		psbts = append(psbts, pending)
+		err = writePSBTs(psbts)
+	}
+	if err != nil {
+		bc.unlockPSBTInputs(walletName, pending.PSBT)
+		return nil, err
+	}
+	return &pending, nil
+}
+
+// Releases the coins a PSBT spends so other payments can use them again
+func (bc *BitcoinClient) unlockPSBTInputs(walletName string, psbt string) error {
+	return bc.lockPSBTInputs(walletName, psbt, true)
+}
+
+// Locks the coins a PSBT spends in the wallet database, or releases them when unlock is set
+func (bc *BitcoinClient) lockPSBTInputs(walletName string, psbt string, unlock bool) error {
+	action := "lock"
+	if unlock {
+		action = "unlock"
+	}
+	response, err := bc.call("decodepsbt", []interface{}{psbt}, "")
+	if err != nil {
+		return fmt.Errorf("failed to decode PSBT: %w", err)
+	}
+	if err := rpcError(response); err != nil {
+		return fmt.Errorf("failed to decode PSBT: %w", err)
+	}
+	result, _ := response["result"].(map[string]interface{})
+	tx, _ := result["tx"].(map[string]interface{})
+	vin, _ := tx["vin"].([]interface{})
+	outpoints := []interface{}{}
+	for _, in := range vin {
+		input, ok := in.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		outpoints = append(outpoints, map[string]interface{}{"txid": input["txid"], "vout": input["vout"]})
+	}
+	if len(outpoints) == 0 {
+		return nil
+	}
+	response, err = bc.call("lockunspent", []interface{}{unlock, outpoints, true}, walletName)
+	if err != nil {
+		return fmt.Errorf("failed to %s inputs: %w", action, err)
+	}
+	if err := rpcError(response); err != nil {
+		return fmt.Errorf("failed to %s inputs: %w", action, err)
+	}
+	return nil
+}
+
+// Combines the signed PSBT with the stored one, finalizes it and broadcasts the transaction
+func (bc *BitcoinClient) FinalizePaymentPSBT(id string, signedPSBT string) (*PendingPSBT, error) {
+	psbtMutex.Lock()
+	defer psbtMutex.Unlock()
+	psbts, err := readPSBTs()
+	if err != nil {
+		return nil, err
+	}
+	index := -1
+	for i, p := range psbts {
+		if p.ID == id {
+			index = i
+			break
+		}
+	}
+	if index == -1 {
+		return nil, fmt.Errorf("PSBT %s not found", id)
+	}
+	pending := psbts[index]
+	if pending.Status != PSBTAwaitingSignature {
+		return nil, fmt.Errorf("PSBT %s is %s", id, pending.Status)
+	}
+
+	// combining guards against a signer returning a PSBT for a different transaction
+	response, err := bc.call("combinepsbt", []interface{}{[]interface{}{pending.PSBT, signedPSBT}}, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to combine PSBT: %w", err)
+	}
+	if err := rpcError(response); err != nil {
+		return nil, fmt.Errorf("signed PSBT does not match payment: %w", err)
+	}
+	combined, _ := response["result"].(string)
+
+	response, err = bc.call("finalizepsbt", []interface{}{combined}, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to finalize PSBT: %w", err)
+	}
+	if err := rpcError(response); err != nil {
+		return nil, fmt.Errorf("failed to finalize PSBT: %w", err)
+	}
+	result, ok := response["result"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("unexpected result type: %T", response["result"])
+	}
+	if complete, _ := result["complete"].(bool); !complete {
+		return nil, fmt.Errorf("PSBT is not fully signed")
+	}
+	rawTx, _ := result["hex"].(string)
+
+	response, err = bc.call("sendrawtransaction", []interface{}{rawTx}, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to broadcast transaction: %w", err)
+	}
+	if err := rpcError(response); err != nil {
+		return nil, fmt.Errorf("failed to broadcast transaction: %w", err)
+	}
+	txid, _ := response["result"].(string)
+
+	pending.Status = PSBTBroadcast
+	pending.TxID = txid
+	pending.UpdatedAt = time.Now().Format(time.RFC3339)
+	psbts[index] = pending
+	if err := writePSBTs(psbts); err != nil {
+		return nil, err
+	}
+
+	err = TrackTransaction(TrackedTransaction{
+		TxID:       txid,
+		WalletName: pending.WalletName,
+		Direction:  "send",
+		Address:    pending.ToAddress,
+		Amount:     pending.Amount,
+		Label:      pending.Label,
+		FileHash:   pending.FileHash,
+		PeerID:     pending.PeerID,
+	})
+	if err != nil {
+		fmt.Printf("Error tracking transaction %s: %v\n", txid, err)
+	}
+	return &pending, nil
+}
+
+// CreateWatchOnlyWalletHandler creates a wallet for an external signer from its output descriptors
+func CreateWatchOnlyWalletHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var req struct {
+		WalletName        string `json:"walletName"`
+		ReceiveDescriptor string `json:"receiveDescriptor"`
+		ChangeDescriptor  string `json:"changeDescriptor"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	if req.WalletName == "" || req.ReceiveDescriptor == "" {
+		http.Error(w, "'walletName' and 'receiveDescriptor' are required", http.StatusBadRequest)
+		return
+	}
+
+	cfg := config.NewConfig()
+	btcClient := NewBitcoinClient(cfg)
+	if err := btcClient.CreateWatchOnlyWallet(req.WalletName, req.ReceiveDescriptor, req.ChangeDescriptor); err != nil {
+		fmt.Printf("Error creating watch-only wallet: %v\n", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	address, err := btcClient.GenerateNewAddress(req.WalletName)
+	if err != nil {
+		fmt.Printf("Error generating address: %v\n", err)
+	}
+	json.NewEncoder(w).Encode(map[string]string{"walletName": req.WalletName, "address": address, "mode": "watch-only"})
+}
+
+// CreatePSBTHandler builds a payment PSBT for external signing. Accepts the same body as TransferCoinsHandler
+func CreatePSBTHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	vars := mux.Vars(r)
+	walletName := vars["walletName"]
+	toAddress := vars["toAddress"]
+	label := vars["label"]
+	amount, err := strconv.ParseFloat(vars["amount"], 64)
+	if walletName == "" || toAddress == "" || label == "" || err != nil || amount <= 0 {
+		http.Error(w, "'walletName', 'toAddress', 'label' and a positive 'amount' are required", http.StatusBadRequest)
+		return
+	}
+	var transferReq TransferRequest
+	if err := decodeJSONBody(r, &transferReq); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Refuse to pay an address that contradicts the payee's pinned or attested address
+	if err := transferReq.checkPayee(toAddress); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	cfg := config.NewConfig()
+	btcClient := NewBitcoinClient(cfg)
+	pending, err := btcClient.CreatePaymentPSBT(walletName, toAddress, amount, label, transferReq)
+	if err != nil {
+		fmt.Printf("Error creating PSBT: %v\n", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(pending)
+}
+
+// GetPSBTHandler exports a stored PSBT. ?format=binary downloads the raw PSBT file for hardware signers
+func GetPSBTHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	psbtMutex.Lock()
+	psbts, err := readPSBTs()
+	psbtMutex.Unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, p := range psbts {
+		if p.ID != id {
+			continue
+		}
+		if r.URL.Query().Get("format") == "binary" {
+			raw, err := base64.StdEncoding.DecodeString(p.PSBT)
+			if err != nil {
+				http.Error(w, "Stored PSBT is corrupt", http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", p.ID+".psbt"))
+			w.Write(raw)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(p)
+		return
+	}
+	http.Error(w, "PSBT not found", http.StatusNotFound)
+}
+
+// ListPSBTsHandler lists stored PSBTs, optionally filtered by ?status=
+func ListPSBTsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	status := r.URL.Query().Get("status")
+	psbtMutex.Lock()
+	psbts, err := readPSBTs()
+	psbtMutex.Unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	filtered := []PendingPSBT{}
+	for _, p := range psbts {
+		if status == "" || p.Status == status {
+			filtered = append(filtered, p)
+		}
+	}
+	json.NewEncoder(w).Encode(filtered)
+}
+
+// FinalizePSBTHandler accepts the signed PSBT (JSON {"psbt": base64} or a raw binary upload) and broadcasts it
+func FinalizePSBTHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	id := mux.Vars(r)["id"]
+
+	var signed string
+	if r.Header.Get("Content-Type") == "application/octet-stream" {
+		raw, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+		if err != nil {
+			http.Error(w, "Error reading request body", http.StatusBadRequest)
+			return
+		}
+		signed = base64.StdEncoding.EncodeToString(raw)
+	} else {
+		var req struct {
+			PSBT string `json:"psbt"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.PSBT == "" {
+			http.Error(w, "Invalid JSON body; expected signed 'psbt'", http.StatusBadRequest)
+			return
+		}
+		signed = strings.TrimSpace(req.PSBT)
+	}
+
+	cfg := config.NewConfig()
+	btcClient := NewBitcoinClient(cfg)
+	pending, err := btcClient.FinalizePaymentPSBT(id, signed)
+	if err != nil {
+		fmt.Printf("Error finalizing PSBT %s: %v\n", id, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"transactionID": pending.TxID, "status": pending.Status})
+}
+
+// CancelPSBTHandler discards a PSBT that will not be signed
+func CancelPSBTHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	id := mux.Vars(r)["id"]
+	psbtMutex.Lock()
+	defer psbtMutex.Unlock()
+	psbts, err := readPSBTs()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for i, p := range psbts {
+		if p.ID != id {
+			continue
+		}
+		if p.Status != PSBTAwaitingSignature {
+			http.Error(w, fmt.Sprintf("PSBT is %s", p.Status), http.StatusConflict)
+			return
+		}
+		psbts[i].Status = PSBTCancelled
+		psbts[i].UpdatedAt = time.Now().Format(time.RFC3339)
+		if err := writePSBTs(psbts); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		btcClient := NewBitcoinClient(config.NewConfig())
+		if err := btcClient.unlockPSBTInputs(p.WalletName, p.PSBT); err != nil {
+			fmt.Printf("Error releasing inputs of PSBT %s: %v\n", id, err)
+		}
+		json.NewEncoder(w).Encode(map[string]string{"status": PSBTCancelled})
+		return
+	}
+	http.Error(w, "PSBT not found", http.StatusNotFound)
+}
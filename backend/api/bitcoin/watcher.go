@@ -0,0 +1,465 @@
+package bitcoin
+
+import (
+	"Otternet/backend/api/download"
+	"Otternet/backend/api/events"
+	"Otternet/backend/api/proxy"
+	"Otternet/backend/config"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	PaymentPending   = "pending"
+	PaymentConfirmed = "confirmed"
+	PaymentFailed    = "failed"
+)
+
+const trackedTxFilePath = "./api/bitcoin/transactions.json"
+
+// TrackedTransaction follows a wallet transaction until it is buried deep enough to be safe from reorgs
+type TrackedTransaction struct {
+	TxID          string  `json:"txID"`
+	WalletName    string  `json:"walletName"`
+	Direction     string  `json:"direction"` // "send" or "receive"
+	Address       string  `json:"address"`
+	Amount        float64 `json:"amount"`
+	Fee           float64 `json:"fee"`
+	Label         string  `json:"label"`
+	FileHash      string  `json:"fileHash,omitempty"` // download this payment is for
+	PeerID        string  `json:"peerID,omitempty"`   // provider or proxy that was paid
+	Confirmations int     `json:"confirmations"`
+	BlockHash     string  `json:"blockHash,omitempty"`
+	Status        string  `json:"status"`
+	Reorged       bool    `json:"reorged"`
+	ReplacedBy    string  `json:"replacedBy,omitempty"`
+	Done          bool    `json:"done"` // no longer polled
+	FirstSeen     string  `json:"firstSeen"`
+	UpdatedAt     string  `json:"updatedAt"`
+}
+
+type trackedState struct {
+	Transactions []TrackedTransaction `json:"transactions"`
+	LastBlocks   map[string]string    `json:"lastBlocks"` // wallet name -> last block scanned for incoming payments
+}
+
+var trackedMutex = &sync.Mutex{}
+
+func readTracked() (*trackedState, error) {
+	state := &trackedState{Transactions: []TrackedTransaction{}, LastBlocks: map[string]string{}}
+	data, err := os.ReadFile(trackedTxFilePath)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading tracked transactions: %w", err)
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("error unmarshalling tracked transactions: %w", err)
+	}
+	if state.LastBlocks == nil {
+		state.LastBlocks = map[string]string{}
+	}
+	return state, nil
+}
+
+func writeTracked(state *trackedState) error {
+	data, err := json.MarshalIndent(state, "", " ")
+	if err != nil {
+		return fmt.Errorf("error marshalling tracked transactions: %w", err)
+	}
+	return os.WriteFile(trackedTxFilePath, data, 0644)
+}
+
+// Reports whether a and b track the same payment. One transaction can pay several peers or files, so each of those
+// payments is tracked as its own record
+func samePayment(a TrackedTransaction, b TrackedTransaction) bool {
+	return a.TxID == b.TxID && a.WalletName == b.WalletName && a.Direction == b.Direction &&
+		a.PeerID == b.PeerID && a.FileHash == b.FileHash
+}
+
+// TrackTransaction starts watching an outgoing payment created by TransferCoins
+func TrackTransaction(tx TrackedTransaction) error {
+	trackedMutex.Lock()
+	defer trackedMutex.Unlock()
+
+	state, err := readTracked()
+	if err != nil {
+		return err
+	}
+	for _, existing := range state.Transactions {
+		if samePayment(existing, tx) {
+			return nil
+		}
+	}
+	now := time.Now().Format(time.RFC3339)
+	tx.Status = PaymentPending
+	tx.FirstSeen = now
+	tx.UpdatedAt = now
+	state.Transactions = append(state.Transactions, tx)
+	if err := writeTracked(state); err != nil {
+		return err
+	}
+	updateLinkedRecords(tx, "")
+	events.Publish(events.TopicPayment, "sent", tx)
+	return nil
+}
+
+// ReplaceTrackedTransaction moves tracking (and any linked download/proxy record) from a transaction to its fee bumped replacement
+func ReplaceTrackedTransaction(originalTxID string, replacementTxID string) error {
+	trackedMutex.Lock()
+	defer trackedMutex.Unlock()
+
+	state, err := readTracked()
+	if err != nil {
+		return err
+	}
+	// every payment made by the original transaction moves to the replacement
+	var replacements []TrackedTransaction
+	for i, tx := range state.Transactions {
+		if tx.TxID != originalTxID {
+			continue
+		}
+		replacements = append(replacements, replaceLocked(state, i, replacementTxID))
+	}
+	if len(replacements) == 0 {
+		return fmt.Errorf("transaction %s is not tracked", originalTxID)
+	}
+	if err := writeTracked(state); err != nil {
+		return err
+	}
+	for _, replacement := range replacements {
+		updateLinkedRecords(replacement, originalTxID)
+	}
+	return nil
+}
+
+// Retires state.Transactions[i] in favour of replacementTxID and returns the replacement, which is tracked from now on
+// unless it already is. Caller holds trackedMutex
+func replaceLocked(state *trackedState, i int, replacementTxID string) TrackedTransaction {
+	now := time.Now().Format(time.RFC3339)
+	original := state.Transactions[i]
+	state.Transactions[i].ReplacedBy = replacementTxID
+	state.Transactions[i].Status = PaymentFailed
+	state.Transactions[i].Done = true
+	state.Transactions[i].UpdatedAt = now
+
+	replacement := original
+	replacement.TxID = replacementTxID
+	for _, existing := range state.Transactions {
+		if samePayment(existing, replacement) {
+			return existing
+		}
+	}
+	replacement.Status = PaymentPending
+	replacement.Confirmations = 0
+	replacement.BlockHash = ""
+	replacement.ReplacedBy = ""
+	replacement.Reorged = false
+	replacement.Done = false
+	replacement.FirstSeen = now
+	replacement.UpdatedAt = now
+	state.Transactions = append(state.Transactions, replacement)
+	return replacement
+}
+
+// Propagates a payment's status to the download or proxy record it pays for
+func updateLinkedRecords(tx TrackedTransaction, replacedTxID string) {
+	if tx.Direction != "send" || (tx.PeerID == "" && tx.FileHash == "") {
+		return
+	}
+	var err error
+	if tx.FileHash != "" {
+		err = download.UpdatePaymentStatus(tx.FileHash, tx.PeerID, tx.TxID, replacedTxID, tx.Status)
+	} else if strings.EqualFold(tx.Label, "proxy") {
+		err = proxy.UpdatePaymentStatus(tx.PeerID, tx.TxID, replacedTxID, tx.Amount, tx.Status)
+	}
+	if err != nil {
+		fmt.Printf("Error updating record linked to %s: %v\n", tx.TxID, err)
+	}
+}
+
+// WatchTransactions polls bitcoind for confirmations of tracked transactions and for incoming payments until ctx is done
+func WatchTransactions(ctx context.Context) {
+	cfg := config.NewConfig()
+	btcClient := NewBitcoinClient(cfg)
+	ticker := time.NewTicker(cfg.TxWatchInterval)
+	defer ticker.Stop()
+
+	for {
+		pollIncoming(btcClient)
+		pollTracked(btcClient, cfg)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Adds payments received by any wallet since the last scan to the tracked set. A wallet seen for the first time starts
+// at the current tip, so its earlier history is not reported as new payments. bitcoind is queried without holding
+// trackedMutex so payment handlers are not held up by a slow node
+func pollIncoming(btcClient *BitcoinClient) {
+	walletNames, err := btcClient.ListWallets()
+	if err != nil {
+		fmt.Printf("Transaction watcher: error listing wallets: %v\n", err)
+		return
+	}
+
+	trackedMutex.Lock()
+	state, err := readTracked()
+	trackedMutex.Unlock()
+	if err != nil {
+		fmt.Printf("Transaction watcher: %v\n", err)
+		return
+	}
+
+	lastBlocks := make(map[string]string, len(walletNames))
+	var received []TrackedTransaction
+	now := time.Now().Format(time.RFC3339)
+	for _, walletName := range walletNames {
+		since, scanned := state.LastBlocks[walletName]
+		if !scanned || since == "" {
+			tip, err := btcClient.GetBestBlockHash()
+			if err != nil {
+				fmt.Printf("Transaction watcher: %v\n", err)
+				return
+			}
+			lastBlocks[walletName] = tip
+			continue
+		}
+		transactions, lastBlock, err := btcClient.ListSinceBlock(walletName, since)
+		if err != nil {
+			fmt.Printf("Transaction watcher: error scanning wallet %s: %v\n", walletName, err)
+			continue
+		}
+		for _, tx := range transactions {
+			if category, _ := tx["category"].(string); category != "receive" {
+				continue
+			}
+			txid, _ := tx["txid"].(string)
+			if txid == "" {
+				continue
+			}
+			incoming := TrackedTransaction{
+				TxID:       txid,
+				WalletName: walletName,
+				Direction:  "receive",
+				Status:     PaymentPending,
+				FirstSeen:  now,
+				UpdatedAt:  now,
+			}
+			incoming.Address, _ = tx["address"].(string)
+			incoming.Amount, _ = tx["amount"].(float64)
+			incoming.Label, _ = tx["label"].(string)
+			received = append(received, incoming)
+		}
+		lastBlocks[walletName] = lastBlock
+	}
+
+	// merge into the current state, which payment handlers may have changed meanwhile
+	trackedMutex.Lock()
+	state, err = readTracked()
+	if err != nil {
+		trackedMutex.Unlock()
+		fmt.Printf("Transaction watcher: %v\n", err)
+		return
+	}
+	known := make(map[string]struct{}, len(state.Transactions))
+	for _, tx := range state.Transactions {
+		known[tx.WalletName+"/"+tx.TxID] = struct{}{}
+	}
+	var added []TrackedTransaction
+	for _, tx := range received {
+		if _, exists := known[tx.WalletName+"/"+tx.TxID]; exists {
+			continue
+		}
+		known[tx.WalletName+"/"+tx.TxID] = struct{}{}
+		state.Transactions = append(state.Transactions, tx)
+		added = append(added, tx)
+	}
+	for walletName, lastBlock := range lastBlocks {
+		state.LastBlocks[walletName] = lastBlock
+	}
+	err = writeTracked(state)
+	trackedMutex.Unlock()
+	if err != nil {
+		fmt.Printf("Transaction watcher: %v\n", err)
+		return
+	}
+	for _, tx := range added {
+		fmt.Printf("Transaction watcher: incoming payment %s to wallet %s\n", tx.TxID, tx.WalletName)
+		events.Publish(events.TopicPayment, "received", tx)
+	}
+}
+
+// Refreshes confirmation counts and detects reorgs, conflicts and replacements for every transaction still being
+// watched. Like pollIncoming, it queries bitcoind without holding trackedMutex and merges the results afterwards
+func pollTracked(btcClient *BitcoinClient, cfg *config.Config) {
+	trackedMutex.Lock()
+	state, err := readTracked()
+	trackedMutex.Unlock()
+	if err != nil {
+		fmt.Printf("Transaction watcher: %v\n", err)
+		return
+	}
+
+	details := make(map[string]map[string]interface{})
+	for _, tx := range state.Transactions {
+		if tx.Done {
+			continue
+		}
+		result, err := btcClient.GetTransaction(tx.WalletName, tx.TxID)
+		if err != nil {
+			fmt.Printf("Transaction watcher: error fetching %s: %v\n", tx.TxID, err)
+			continue
+		}
+		details[tx.WalletName+"/"+tx.TxID] = result
+	}
+
+	type replaced struct {
+		originalTxID string
+		replacement  TrackedTransaction
+	}
+	trackedMutex.Lock()
+	state, err = readTracked()
+	if err != nil {
+		trackedMutex.Unlock()
+		fmt.Printf("Transaction watcher: %v\n", err)
+		return
+	}
+	changed := []TrackedTransaction{}
+	replacements := []replaced{}
+	for i := range state.Transactions {
+		tx := state.Transactions[i]
+		result, ok := details[tx.WalletName+"/"+tx.TxID]
+		if tx.Done || !ok {
+			continue
+		}
+		updated := applyTransactionDetails(tx, result, cfg)
+		if updated.ReplacedBy != "" {
+			// replaced by a fee bump, possibly from another client; the replacement takes over the payment
+			replacement := replaceLocked(state, i, updated.ReplacedBy)
+			replacements = append(replacements, replaced{originalTxID: tx.TxID, replacement: replacement})
+			continue
+		}
+		if updated.Status != tx.Status || updated.Confirmations != tx.Confirmations || updated.Done != tx.Done {
+			updated.UpdatedAt = time.Now().Format(time.RFC3339)
+			state.Transactions[i] = updated
+			if updated.Status != tx.Status {
+				changed = append(changed, updated)
+			}
+		}
+	}
+	err = writeTracked(state)
+	trackedMutex.Unlock()
+	if err != nil {
+		fmt.Printf("Transaction watcher: %v\n", err)
+		return
+	}
+	for _, r := range replacements {
+		fmt.Printf("Transaction watcher: %s was replaced by %s\n", r.originalTxID, r.replacement.TxID)
+		updateLinkedRecords(r.replacement, r.originalTxID)
+		events.Publish(events.TopicPayment, "replaced", map[string]string{"txID": r.originalTxID, "replacedBy": r.replacement.TxID})
+	}
+	for _, tx := range changed {
+		fmt.Printf("Transaction watcher: %s is now %s (%d confirmations)\n", tx.TxID, tx.Status, tx.Confirmations)
+		updateLinkedRecords(tx, "")
+		events.Publish(events.TopicPayment, tx.Status, tx)
+	}
+}
+
+// Computes the new tracking state of tx from a gettransaction result. A transaction replaced by another one comes
+// back with ReplacedBy set and is left for the caller to hand over to the replacement
+func applyTransactionDetails(tx TrackedTransaction, details map[string]interface{}, cfg *config.Config) TrackedTransaction {
+	confirmations := 0
+	if c, ok := details["confirmations"].(float64); ok {
+		confirmations = int(c)
+	}
+	blockHash, _ := details["blockhash"].(string)
+	if fee, ok := details["fee"].(float64); ok {
+		tx.Fee = fee
+	}
+	if replacedBy, ok := details["replaced_by_txid"].(string); ok && confirmations <= 0 {
+		tx.ReplacedBy = replacedBy
+	}
+	abandoned, _ := details["abandoned"].(bool)
+
+	// a previously mined transaction that left its block, or moved to a different one, was reorged
+	if tx.Confirmations > 0 && (confirmations <= 0 || blockHash != tx.BlockHash) {
+		fmt.Printf("Transaction watcher: reorg detected for %s\n", tx.TxID)
+		tx.Reorged = true
+	}
+
+	tx.Confirmations = confirmations
+	tx.BlockHash = blockHash
+	switch {
+	case tx.ReplacedBy != "":
+		tx.Status = PaymentPending
+	case confirmations < 0 || abandoned:
+		// negative confirmations mean a conflicting transaction was mined instead
+		tx.Status = PaymentFailed
+		tx.Done = true
+	case confirmations >= cfg.RequiredConfirmations:
+		tx.Status = PaymentConfirmed
+		tx.Done = confirmations >= cfg.ReorgSafetyDepth
+	default:
+		tx.Status = PaymentPending
+	}
+	return tx
+}
+
+// GetPaymentStatusHandler returns the tracked state of a single transaction
+func GetPaymentStatusHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	txid := mux.Vars(r)["txid"]
+	if txid == "" {
+		http.Error(w, "Invalid transaction ID", http.StatusBadRequest)
+		return
+	}
+	trackedMutex.Lock()
+	state, err := readTracked()
+	trackedMutex.Unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, tx := range state.Transactions {
+		if tx.TxID == txid {
+			json.NewEncoder(w).Encode(tx)
+			return
+		}
+	}
+	http.Error(w, "Transaction is not tracked", http.StatusNotFound)
+}
+
+// GetTrackedTransactionsHandler lists tracked transactions, optionally filtered by ?status= and ?walletName=
+func GetTrackedTransactionsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	status := r.URL.Query().Get("status")
+	walletName := r.URL.Query().Get("walletName")
+
+	trackedMutex.Lock()
+	state, err := readTracked()
+	trackedMutex.Unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	filtered := []TrackedTransaction{}
+	for _, tx := range state.Transactions {
+		if (status == "" || tx.Status == status) && (walletName == "" || tx.WalletName == walletName) {
+			filtered = append(filtered, tx)
+		}
+	}
+	json.NewEncoder(w).Encode(filtered)
+}
@@ -0,0 +1,512 @@
+package blocklist
+
+import (
+	"Otternet/backend/api/events"
+	"Otternet/backend/config"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/libp2p/go-libp2p/core/control"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+const blocklistFilePath = "./api/blocklist/blocklist.json"
+
+// Windows over which inbound streams and protocol violations are counted
+const (
+	streamWindow    = time.Minute
+	violationWindow = time.Hour
+)
+
+// BlockedPeer is a peer that may not connect to us or be dialled
+type BlockedPeer struct {
+	PeerID    string `json:"peerID"`
+	Reason    string `json:"reason"`
+	Automatic bool   `json:"automatic"` // banned for exceeding a limit rather than by hand
+	BlockedAt string `json:"blockedAt"`
+	ExpiresAt string `json:"expiresAt,omitempty"` // empty for a permanent block
+}
+
+// BlockedRange is an IP range no connection may come from or go to
+type BlockedRange struct {
+	CIDR      string `json:"cidr"`
+	Reason    string `json:"reason"`
+	BlockedAt string `json:"blockedAt"`
+}
+
+type blocklist struct {
+	Peers  map[string]*BlockedPeer `json:"peers"`
+	Ranges []BlockedRange          `json:"ranges"`
+}
+
+// Recent behaviour of a peer, counted in fixed windows
+type peerActivity struct {
+	streamWindowStart    time.Time
+	streams              int
+	violationWindowStart time.Time
+	violations           int
+}
+
+var (
+	mutex     = &sync.Mutex{}
+	current   = blocklist{Peers: make(map[string]*BlockedPeer)}
+	networks  []*net.IPNet // parsed current.Ranges, same order
+	activity  = make(map[peer.ID]*peerActivity)
+	protected = make(map[peer.ID]bool)
+	swarm     network.Network // set once the host is up, to drop connections of newly blocked peers
+	pruned    time.Time       // when activity was last cleared of peers whose windows have run out
+)
+
+func init() {
+	loadLocked()
+}
+
+// Replaces the blocklist with the one in the blocklist file. Caller holds mutex, except during init
+func loadLocked() {
+	current = blocklist{Peers: make(map[string]*BlockedPeer)}
+	networks = nil
+	data, err := os.ReadFile(blocklistFilePath)
+	if err != nil {
+		return
+	}
+	var saved blocklist
+	if err := json.Unmarshal(data, &saved); err != nil {
+		fmt.Printf("Blocklist: ignoring unreadable file: %v\n", err)
+		return
+	}
+	if saved.Peers != nil {
+		current.Peers = saved.Peers
+	}
+	for _, blocked := range saved.Ranges {
+		_, ipNet, err := net.ParseCIDR(blocked.CIDR)
+		if err != nil {
+			fmt.Printf("Blocklist: ignoring range %q: %v\n", blocked.CIDR, err)
+			continue
+		}
+		current.Ranges = append(current.Ranges, blocked)
+		networks = append(networks, ipNet)
+	}
+}
+
+// Reload rereads the blocklist file, e.g. after a backup restored it. Connections to peers it newly blocks are kept
+// until they close; new ones are refused
+func Reload() {
+	mutex.Lock()
+	defer mutex.Unlock()
+	loadLocked()
+}
+
+// Caller holds mutex
+func saveLocked() {
+	data, err := json.MarshalIndent(current, "", " ")
+	if err != nil {
+		fmt.Printf("Blocklist: error marshalling: %v\n", err)
+		return
+	}
+	if err := os.WriteFile(blocklistFilePath, data, 0644); err != nil {
+		fmt.Printf("Blocklist: error writing: %v\n", err)
+	}
+}
+
+// Attach lets the blocklist close connections to peers as they are blocked
+func Attach(n network.Network) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	swarm = n
+}
+
+// Protect exempts peers, such as the relay and bootstrap nodes, from automatic bans. They can still be blocked by hand
+func Protect(ids ...peer.ID) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	for _, id := range ids {
+		protected[id] = true
+	}
+}
+
+// Reports whether the peer is blocked, dropping its entry once a temporary ban has run out. Caller holds mutex
+func peerBlockedLocked(id peer.ID) bool {
+	blocked, ok := current.Peers[id.String()]
+	if !ok {
+		return false
+	}
+	if blocked.ExpiresAt != "" {
+		expires, err := time.Parse(time.RFC3339, blocked.ExpiresAt)
+		if err == nil && time.Now().After(expires) {
+			delete(current.Peers, id.String())
+			saveLocked()
+			return false
+		}
+	}
+	return true
+}
+
+// Caller holds mutex
+func ipBlockedLocked(ip net.IP) bool {
+	for _, ipNet := range networks {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsPeerBlocked reports whether the peer is blocked
+func IsPeerBlocked(id peer.ID) bool {
+	mutex.Lock()
+	defer mutex.Unlock()
+	return peerBlockedLocked(id)
+}
+
+// IsAddrBlocked reports whether the address is in a blocked IP range. Relayed circuits are never blocked by range:
+// the only IP in a circuit address is the relay's, and blocking it would block every peer using that relay. Blocking
+// the relay's range still refuses direct connections to the relay itself
+func IsAddrBlocked(addr multiaddr.Multiaddr) bool {
+	if _, err := addr.ValueForProtocol(multiaddr.P_CIRCUIT); err == nil {
+		return false
+	}
+	ip, err := manet.ToIP(addr)
+	if err != nil {
+		return false
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	return ipBlockedLocked(ip)
+}
+
+// BlockPeer blocks a peer for duration, or permanently when duration is 0, and drops its connections
+func BlockPeer(id peer.ID, reason string, duration time.Duration) BlockedPeer {
+	return block(id, reason, duration, false)
+}
+
+func block(id peer.ID, reason string, duration time.Duration, automatic bool) BlockedPeer {
+	now := time.Now()
+	blocked := BlockedPeer{PeerID: id.String(), Reason: reason, Automatic: automatic, BlockedAt: now.Format(time.RFC3339)}
+	if duration > 0 {
+		blocked.ExpiresAt = now.Add(duration).Format(time.RFC3339)
+	}
+	mutex.Lock()
+	current.Peers[id.String()] = &blocked
+	delete(activity, id)
+	saveLocked()
+	n := swarm
+	mutex.Unlock()
+
+	fmt.Printf("Blocklist: blocked %s: %s\n", id, reason)
+	events.Publish(events.TopicPeer, "blocked", blocked)
+	if n != nil {
+		n.ClosePeer(id)
+	}
+	return blocked
+}
+
+// UnblockPeer lifts a block; it reports false when the peer was not blocked
+func UnblockPeer(id peer.ID) bool {
+	mutex.Lock()
+	if _, ok := current.Peers[id.String()]; !ok {
+		mutex.Unlock()
+		return false
+	}
+	delete(current.Peers, id.String())
+	saveLocked()
+	mutex.Unlock()
+
+	events.Publish(events.TopicPeer, "unblocked", map[string]string{"peerID": id.String()})
+	return true
+}
+
+// Accepts a CIDR or a single IP address, which is blocked on its own
+func parseRange(cidr string) (*net.IPNet, error) {
+	cidr = strings.TrimSpace(cidr)
+	if ip := net.ParseIP(cidr); ip != nil {
+		// an IPv4-mapped IPv6 address is the IPv4 address; appending /32 to it would block a whole IPv6 range
+		if ip4 := ip.To4(); ip4 != nil {
+			return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+	}
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid IP range %q", cidr)
+	}
+	return ipNet, nil
+}
+
+// BlockRange blocks an IP range and drops connections from it
+func BlockRange(cidr string, reason string) (BlockedRange, error) {
+	ipNet, err := parseRange(cidr)
+	if err != nil {
+		return BlockedRange{}, err
+	}
+	blocked := BlockedRange{CIDR: ipNet.String(), Reason: reason, BlockedAt: time.Now().Format(time.RFC3339)}
+	mutex.Lock()
+	for _, existing := range current.Ranges {
+		if existing.CIDR == blocked.CIDR {
+			mutex.Unlock()
+			return existing, nil
+		}
+	}
+	current.Ranges = append(current.Ranges, blocked)
+	networks = append(networks, ipNet)
+	saveLocked()
+	n := swarm
+	mutex.Unlock()
+
+	fmt.Printf("Blocklist: blocked range %s: %s\n", blocked.CIDR, reason)
+	events.Publish(events.TopicPeer, "blocked", blocked)
+	if n != nil {
+		for _, conn := range n.Conns() {
+			if ip, err := manet.ToIP(conn.RemoteMultiaddr()); err == nil && ipNet.Contains(ip) {
+				conn.Close()
+			}
+		}
+	}
+	return blocked, nil
+}
+
+// UnblockRange lifts a range block; it reports false when the range was not blocked
+func UnblockRange(cidr string) bool {
+	ipNet, err := parseRange(cidr)
+	if err != nil {
+		return false
+	}
+	mutex.Lock()
+	for i, blocked := range current.Ranges {
+		if blocked.CIDR == ipNet.String() {
+			current.Ranges = append(current.Ranges[:i], current.Ranges[i+1:]...)
+			networks = append(networks[:i], networks[i+1:]...)
+			saveLocked()
+			mutex.Unlock()
+
+			events.Publish(events.TopicPeer, "unblocked", map[string]string{"cidr": blocked.CIDR})
+			return true
+		}
+	}
+	mutex.Unlock()
+	return false
+}
+
+// Returns the peer's activity, creating it. Once a minute peers whose counting windows have both run out are
+// dropped, so the map only holds recently active peers. Caller holds mutex
+func activityLocked(id peer.ID) *peerActivity {
+	now := time.Now()
+	if now.Sub(pruned) > streamWindow {
+		pruned = now
+		for other, a := range activity {
+			if now.Sub(a.streamWindowStart) > streamWindow && now.Sub(a.violationWindowStart) > violationWindow {
+				delete(activity, other)
+			}
+		}
+	}
+	a, ok := activity[id]
+	if !ok {
+		a = &peerActivity{}
+		activity[id] = a
+	}
+	return a
+}
+
+// Counts an inbound stream and reports whether the peer has gone over StreamRateLimit; protected peers never do
+func overStreamLimit(id peer.ID) bool {
+	limit := config.NewConfig().StreamRateLimit
+	mutex.Lock()
+	defer mutex.Unlock()
+	if limit <= 0 || protected[id] {
+		return false
+	}
+	a := activityLocked(id)
+	now := time.Now()
+	if now.Sub(a.streamWindowStart) > streamWindow {
+		a.streamWindowStart = now
+		a.streams = 0
+	}
+	a.streams++
+	return a.streams > limit
+}
+
+// Guard wraps a stream handler so streams from blocked peers are reset and peers opening streams faster than
+// StreamRateLimit are banned for AutoBanDuration
+func Guard(handler network.StreamHandler) network.StreamHandler {
+	return func(s network.Stream) {
+		remote := s.Conn().RemotePeer()
+		if IsPeerBlocked(remote) {
+			s.Reset()
+			return
+		}
+		if overStreamLimit(remote) {
+			s.Reset()
+			block(remote, fmt.Sprintf("more than %d streams a minute", config.NewConfig().StreamRateLimit), config.NewConfig().AutoBanDuration, true)
+			return
+		}
+		handler(s)
+	}
+}
+
+// ReportViolation records that a peer broke a protocol, e.g. with a malformed message or a bad signature. A peer
+// reaching ProtocolViolationLimit within an hour is banned for AutoBanDuration
+func ReportViolation(id peer.ID, reason string) {
+	cfg := config.NewConfig()
+	fmt.Printf("Blocklist: protocol violation by %s: %s\n", id, reason)
+	mutex.Lock()
+	if cfg.ProtocolViolationLimit <= 0 || protected[id] {
+		mutex.Unlock()
+		return
+	}
+	a := activityLocked(id)
+	now := time.Now()
+	if now.Sub(a.violationWindowStart) > violationWindow {
+		a.violationWindowStart = now
+		a.violations = 0
+	}
+	a.violations++
+	banned := a.violations >= cfg.ProtocolViolationLimit
+	mutex.Unlock()
+	if banned {
+		block(id, fmt.Sprintf("%d protocol violations, last: %s", cfg.ProtocolViolationLimit, reason), cfg.AutoBanDuration, true)
+	}
+}
+
+// IsMalformed reports whether a JSON decoding error was caused by what the peer sent rather than by the stream
+// failing, e.g. a timeout or reset
+func IsMalformed(err error) bool {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	return errors.As(err, &syntaxErr) || errors.As(err, &typeErr)
+}
+
+// Gater is the libp2p connection gater backed by the blocklist
+type Gater struct{}
+
+// NewGater returns a connection gater that refuses blocked peers and IP ranges
+func NewGater() *Gater {
+	return &Gater{}
+}
+
+func (g *Gater) InterceptPeerDial(p peer.ID) bool {
+	return !IsPeerBlocked(p)
+}
+
+func (g *Gater) InterceptAddrDial(p peer.ID, addr multiaddr.Multiaddr) bool {
+	return !IsAddrBlocked(addr)
+}
+
+func (g *Gater) InterceptAccept(addrs network.ConnMultiaddrs) bool {
+	return !IsAddrBlocked(addrs.RemoteMultiaddr())
+}
+
+func (g *Gater) InterceptSecured(dir network.Direction, p peer.ID, addrs network.ConnMultiaddrs) bool {
+	return !IsPeerBlocked(p) && !IsAddrBlocked(addrs.RemoteMultiaddr())
+}
+
+func (g *Gater) InterceptUpgraded(conn network.Conn) (bool, control.DisconnectReason) {
+	return true, 0
+}
+
+// GetBlocklist lists blocked peers, including temporary bans still in force, and blocked IP ranges
+func GetBlocklist(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	mutex.Lock()
+	peers := make([]BlockedPeer, 0, len(current.Peers))
+	for peerID := range current.Peers {
+		id, err := peer.Decode(peerID)
+		if err != nil || !peerBlockedLocked(id) {
+			continue
+		}
+		peers = append(peers, *current.Peers[peerID])
+	}
+	ranges := append([]BlockedRange{}, current.Ranges...)
+	mutex.Unlock()
+	sort.Slice(peers, func(i, j int) bool { return peers[i].BlockedAt > peers[j].BlockedAt })
+	json.NewEncoder(w).Encode(map[string]interface{}{"peers": peers, "ranges": ranges})
+}
+
+// PostBlockedPeer blocks a peer. durationMinutes of 0 or less blocks it permanently
+func PostBlockedPeer(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var req struct {
+		PeerID          string `json:"peerID"`
+		Reason          string `json:"reason"`
+		DurationMinutes int    `json:"durationMinutes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	id, err := peer.Decode(strings.TrimSpace(req.PeerID))
+	if err != nil {
+		http.Error(w, "Invalid peer ID", http.StatusBadRequest)
+		return
+	}
+	if req.Reason == "" {
+		req.Reason = "blocked by hand"
+	}
+	var duration time.Duration
+	if req.DurationMinutes > 0 {
+		duration = time.Duration(req.DurationMinutes) * time.Minute
+	}
+	json.NewEncoder(w).Encode(BlockPeer(id, req.Reason, duration))
+}
+
+// DeleteBlockedPeer unblocks a peer
+func DeleteBlockedPeer(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	id, err := peer.Decode(mux.Vars(r)["peerID"])
+	if err != nil {
+		http.Error(w, "Invalid peer ID", http.StatusBadRequest)
+		return
+	}
+	if !UnblockPeer(id) {
+		http.Error(w, "Peer is not blocked", http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"message": "Peer unblocked", "status": "success"})
+}
+
+// PostBlockedRange blocks an IP range given as a CIDR or a single address
+func PostBlockedRange(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var req struct {
+		CIDR   string `json:"cidr"`
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Reason == "" {
+		req.Reason = "blocked by hand"
+	}
+	blocked, err := BlockRange(req.CIDR, req.Reason)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	json.NewEncoder(w).Encode(blocked)
+}
+
+// DeleteBlockedRange unblocks the range given in the cidr query parameter
+func DeleteBlockedRange(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	cidr := r.URL.Query().Get("cidr")
+	if cidr == "" {
+		http.Error(w, "'cidr' is required", http.StatusBadRequest)
+		return
+	}
+	if !UnblockRange(cidr) {
+		http.Error(w, "Range is not blocked", http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"message": "Range unblocked", "status": "success"})
+}
@@ -0,0 +1,51 @@
+package blocklist
+
+import (
+	"net"
+	"testing"
+)
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string // "" when the input is rejected
+	}{
+		{"10.0.0.0/8", "10.0.0.0/8"},
+		{"10.1.2.3/8", "10.0.0.0/8"},
+		{" 192.168.1.0/24 ", "192.168.1.0/24"},
+		{"192.168.1.7", "192.168.1.7/32"},
+		{"2001:db8::/32", "2001:db8::/32"},
+		{"2001:db8::1", "2001:db8::1/128"},
+		{"::ffff:1.2.3.4", "1.2.3.4/32"},
+		{"", ""},
+		{"example.com", ""},
+		{"10.0.0.0/33", ""},
+		{"300.0.0.1", ""},
+	}
+	for _, test := range tests {
+		ipNet, err := parseRange(test.input)
+		if test.want == "" {
+			if err == nil {
+				t.Errorf("parseRange(%q) = %v, want an error", test.input, ipNet)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseRange(%q): unexpected error %v", test.input, err)
+			continue
+		}
+		if ipNet.String() != test.want {
+			t.Errorf("parseRange(%q) = %v, want %s", test.input, ipNet, test.want)
+		}
+	}
+}
+
+func TestParseRangeSingleAddressBlocksOnlyItself(t *testing.T) {
+	ipNet, err := parseRange("192.168.1.7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ipNet.Contains(net.ParseIP("192.168.1.7")) || ipNet.Contains(net.ParseIP("192.168.1.8")) {
+		t.Fatalf("%v should contain exactly 192.168.1.7", ipNet)
+	}
+}
@@ -0,0 +1,271 @@
+package bundle
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const manifestDir = "./api/bundle/manifests"
+
+// Member is one file of a bundle. Path is relative to the bundle root and always uses forward slashes
+type Member struct {
+	Path string `json:"path"`
+	Hash string `json:"hash"` // sha256 of the contents, hex encoded
+	Size int64  `json:"size"`
+
+	LocalPath string `json:"localPath,omitempty"` // where the provider reads it from; never sent to peers
+}
+
+// Manifest lists the members of a bundle in the order they are streamed
+type Manifest struct {
+	Name    string   `json:"name"`
+	Members []Member `json:"members"`
+}
+
+var mutex = &sync.Mutex{}
+
+// HashFile returns the hex sha256 and size of a file, the same hash the frontend computes for single uploads
+func HashFile(filePath string) (string, int64, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", 0, err
+	}
+	defer file.Close()
+	h := sha256.New()
+	size, err := io.Copy(h, file)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), size, nil
+}
+
+// Build hashes every regular file under the given paths. A single directory becomes the bundle root; otherwise each
+// directory contributes its tree beneath a folder named after it and each file is placed at the bundle root
+func Build(name string, paths []string) (*Manifest, error) {
+	manifest := &Manifest{Name: name}
+	seen := make(map[string]bool)
+	add := func(rel string, localPath string) error {
+		rel = path.Clean(filepath.ToSlash(rel))
+		if seen[rel] {
+			return fmt.Errorf("duplicate bundle path %s", rel)
+		}
+		seen[rel] = true
+		hash, size, err := HashFile(localPath)
+		if err != nil {
+			return fmt.Errorf("error hashing %s: %w", localPath, err)
+		}
+		manifest.Members = append(manifest.Members, Member{Path: rel, Hash: hash, Size: size, LocalPath: localPath})
+		return nil
+	}
+
+	for _, p := range paths {
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			return nil, err
+		}
+		info, err := os.Stat(abs)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			if err := add(filepath.Base(abs), abs); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		parent := filepath.Dir(abs)
+		if len(paths) == 1 {
+			parent = abs
+		}
+		err = filepath.WalkDir(abs, func(walked string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.Type().IsRegular() {
+				return nil
+			}
+			rel, err := filepath.Rel(parent, walked)
+			if err != nil {
+				return err
+			}
+			return add(rel, walked)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(manifest.Members) == 0 {
+		return nil, errors.New("bundle has no files")
+	}
+	sort.Slice(manifest.Members, func(i, j int) bool { return manifest.Members[i].Path < manifest.Members[j].Path })
+	return manifest, nil
+}
+
+// Public returns the manifest as sent to peers, without local paths
+func (m *Manifest) Public() *Manifest {
+	public := &Manifest{Name: m.Name, Members: make([]Member, len(m.Members))}
+	for i, member := range m.Members {
+		member.LocalPath = ""
+		public.Members[i] = member
+	}
+	return public
+}
+
+// Hash identifies the bundle: the sha256 of its public manifest, so any change to a member changes the bundle hash
+func (m *Manifest) Hash() string {
+	data, _ := json.Marshal(m.Public())
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func (m *Manifest) TotalSize() int64 {
+	var total int64
+	for _, member := range m.Members {
+		total += member.Size
+	}
+	return total
+}
+
+// Member looks a member up by its path. Paths are unique within a bundle, unlike hashes of identical contents
+func (m *Manifest) Member(memberPath string) (Member, bool) {
+	for _, member := range m.Members {
+		if member.Path == memberPath {
+			return member, true
+		}
+	}
+	return Member{}, false
+}
+
+// Validate rejects manifests from peers whose paths would escape the download directory
+func (m *Manifest) Validate() error {
+	if m.Name == "" || strings.ContainsAny(m.Name, `/\`) || m.Name == "." || m.Name == ".." {
+		return fmt.Errorf("invalid bundle name %q", m.Name)
+	}
+	for _, member := range m.Members {
+		clean := path.Clean(member.Path)
+		if member.Path == "" || clean != member.Path || path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") || strings.Contains(member.Path, `\`) {
+			return fmt.Errorf("invalid member path %q", member.Path)
+		}
+		if member.Size < 0 {
+			return fmt.Errorf("invalid size for %q", member.Path)
+		}
+	}
+	return nil
+}
+
+// MemberPrice is a member's share of the bundle price, proportional to its size
+func (m *Manifest) MemberPrice(bundlePrice float64, member Member) float64 {
+	total := m.TotalSize()
+	if total == 0 {
+		return bundlePrice / float64(len(m.Members))
+	}
+	return bundlePrice * float64(member.Size) / float64(total)
+}
+
+func manifestPath(hash string) string {
+	return filepath.Join(manifestDir, hash+".json")
+}
+
+// Save stores the manifest, local paths included, under its bundle hash
+func Save(m *Manifest) (string, error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if err := os.MkdirAll(manifestDir, 0755); err != nil {
+		return "", err
+	}
+	hash := m.Hash()
+	data, err := json.MarshalIndent(m, "", " ")
+	if err != nil {
+		return "", err
+	}
+	return hash, os.WriteFile(manifestPath(hash), data, 0644)
+}
+
+// Load returns the manifest of a bundle published by this node
+func Load(hash string) (*Manifest, error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if strings.ContainsAny(hash, `/\.`) {
+		return nil, errors.New("invalid bundle hash")
+	}
+	data, err := os.ReadFile(manifestPath(hash))
+	if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+func Delete(hash string) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	err := os.Remove(manifestPath(hash))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// memberReader streams member contents back to back, opening each file only when it is reached
+type memberReader struct {
+	members []Member
+	current *os.File
+	left    int64
+}
+
+// NewReader streams the given members in order. Each must still have exactly its manifest size
+func NewReader(members []Member) io.ReadCloser {
+	return &memberReader{members: members}
+}
+
+func (r *memberReader) Read(p []byte) (int, error) {
+	for r.current == nil || r.left == 0 {
+		if r.current != nil {
+			r.current.Close()
+			r.current = nil
+		}
+		if len(r.members) == 0 {
+			return 0, io.EOF
+		}
+		member := r.members[0]
+		r.members = r.members[1:]
+		file, err := os.Open(member.LocalPath)
+		if err != nil {
+			return 0, err
+		}
+		r.current, r.left = file, member.Size
+	}
+	if int64(len(p)) > r.left {
+		p = p[:r.left]
+	}
+	n, err := r.current.Read(p)
+	r.left -= int64(n)
+	if err == io.EOF && r.left > 0 {
+		return n, fmt.Errorf("%s is shorter than its manifest size", r.current.Name())
+	}
+	if err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
+
+func (r *memberReader) Close() error {
+	if r.current != nil {
+		return r.current.Close()
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package bundle
+
+import "testing"
+
+func TestManifestValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest Manifest
+		valid    bool
+	}{
+		{"plain member", Manifest{Name: "photos", Members: []Member{{Path: "a.jpg"}}}, true},
+		{"nested member", Manifest{Name: "photos", Members: []Member{{Path: "2024/june/a.jpg"}}}, true},
+		{"member name with dots", Manifest{Name: "photos", Members: []Member{{Path: "..a/b..jpg"}}}, true},
+		{"parent directory", Manifest{Name: "photos", Members: []Member{{Path: "../x"}}}, false},
+		{"bare parent directory", Manifest{Name: "photos", Members: []Member{{Path: ".."}}}, false},
+		{"parent directory in the middle", Manifest{Name: "photos", Members: []Member{{Path: "a/../b"}}}, false},
+		{"current directory", Manifest{Name: "photos", Members: []Member{{Path: "./a"}}}, false},
+		{"empty path", Manifest{Name: "photos", Members: []Member{{Path: ""}}}, false},
+		{"absolute path", Manifest{Name: "photos", Members: []Member{{Path: "/etc/passwd"}}}, false},
+		{"windows backslash", Manifest{Name: "photos", Members: []Member{{Path: `..\x`}}}, false},
+		{"windows drive", Manifest{Name: "photos", Members: []Member{{Path: `C:\x`}}}, false},
+		{"trailing slash", Manifest{Name: "photos", Members: []Member{{Path: "a/"}}}, false},
+		{"negative size", Manifest{Name: "photos", Members: []Member{{Path: "a", Size: -1}}}, false},
+		{"empty name", Manifest{Name: "", Members: []Member{{Path: "a"}}}, false},
+		{"name with slash", Manifest{Name: "../photos", Members: []Member{{Path: "a"}}}, false},
+		{"name with backslash", Manifest{Name: `..\photos`, Members: []Member{{Path: "a"}}}, false},
+		{"dot name", Manifest{Name: ".", Members: []Member{{Path: "a"}}}, false},
+		{"dot dot name", Manifest{Name: "..", Members: []Member{{Path: "a"}}}, false},
+	}
+	for _, test := range tests {
+		err := test.manifest.Validate()
+		if test.valid && err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("%s: manifest was accepted", test.name)
+		}
+	}
+}
+
+func TestManifestMemberByPath(t *testing.T) {
+	manifest := Manifest{Name: "copies", Members: []Member{
+		{Path: "a.txt", Hash: "same"},
+		{Path: "b/a.txt", Hash: "same"},
+	}}
+	member, ok := manifest.Member("b/a.txt")
+	if !ok || member.Path != "b/a.txt" {
+		t.Fatalf("Member(b/a.txt) = %+v, %v", member, ok)
+	}
+	if _, ok := manifest.Member("same"); ok {
+		t.Fatal("Member matched a hash instead of a path")
+	}
+}
@@ -3,6 +3,7 @@ package dht_handlers
 import (
 	"Otternet/backend/api/dhtnode"
 	"Otternet/backend/api/handlers"
+	"Otternet/backend/api/identify"
 	"Otternet/backend/global"
 	"Otternet/backend/global_wallet"
 	"Otternet/backend/api/proxy"
@@ -32,16 +33,18 @@ func StartDHTHandler(w http.ResponseWriter, r *http.Request) {
 	global_wallet.WalletAddr = walletAddr
 	global.DHTNode, initErr = dhtnode.CreateLibp2pHost()
 	if initErr != nil {
-		log.Fatalf("Failed to instantiate the DHT node: %v", initErr)
+		log.Printf("Failed to instantiate the DHT node: %v", initErr)
+		global.DHTNode = nil
+		global_wallet.WalletAddr = ""
 		http.Error(w, "Failed to start DHT node", http.StatusInternalServerError)
 		return
 	}
-	global.DHTNode.ConnectToPeer(dhtnode.RelayNodeAddr)
-	global.DHTNode.MakeReservation()
+	// relays are checked in the background; until one holds a reservation the node is only reachable directly
+	global.DHTNode.StartRelays()
 	global.DHTNode.ConnectToPeer(dhtnode.BootstrapNodeAddr)
 	global.DHTNode.HandlePeerExchange()
 	handlers.HandleCatalogRequests(global.DHTNode.Host)
-	handlers.HandleOtternetPeersRequests(global.DHTNode.Host)
+	identify.HandleIdentifyRequests(global.DHTNode.Host)
 	handlers.HandleFileRequests(global.DHTNode.Host)
 	handlers.HandlePriceRequests(global.DHTNode.Host)
 	handlers.HandleWalletAddressRequests(global.DHTNode.Host)
@@ -72,3 +75,33 @@ func CloseDHTHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"message": "DHT node closed successfully"})
 }
+
+// GetNetworkStatus reports the node's reachability, NAT types and whether the DHT is running as client or server
+func GetNetworkStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if global.DHTNode == nil {
+		http.Error(w, "DHT node is not running", http.StatusServiceUnavailable)
+		return
+	}
+	json.NewEncoder(w).Encode(global.DHTNode.NetworkStatus())
+}
+
+// GetRelays reports the health and reservation of every configured relay, and whether the node holds any reservation yet
+func GetRelays(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if global.DHTNode == nil {
+		http.Error(w, "DHT node is not running", http.StatusServiceUnavailable)
+		return
+	}
+	json.NewEncoder(w).Encode(global.DHTNode.RelayReport())
+}
+
+// GetExchangedPeers lists the peers learned through peer exchange and whether they turned out to be Otternet nodes
+func GetExchangedPeers(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if global.DHTNode == nil {
+		http.Error(w, "DHT node is not running", http.StatusServiceUnavailable)
+		return
+	}
+	json.NewEncoder(w).Encode(global.DHTNode.ExchangedPeers())
+}
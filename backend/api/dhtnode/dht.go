@@ -1,15 +1,18 @@
 package dhtnode
 
 import (
+	"Otternet/backend/api/blocklist"
+	"Otternet/backend/api/events"
+	"Otternet/backend/api/streams"
+	"Otternet/backend/config"
 	"Otternet/backend/global_wallet"
-	"bufio"
 	"bytes"
 	"context"
+	"crypto/rand"
 	"crypto/sha256"
-	"encoding/json"
 	"fmt"
-	"io"
 	"log"
+	"os"
 	"strings"
 
 	"github.com/ipfs/go-cid"
@@ -21,7 +24,6 @@ import (
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/core/peerstore"
-	"github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/client"
 	"github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/relay"
 	"github.com/multiformats/go-multiaddr"
 	"github.com/multiformats/go-multihash"
@@ -37,11 +39,33 @@ type DHTNode struct {
 	Host host.Host
 	DHT  *dht.IpfsDHT
 	Ctx  context.Context
+
+	configuredMode string
+	reachability   *reachabilityTracker
+	lan            *lanDiscovery // nil unless mDNS discovery is enabled
+	pex            *peerExchange
+	relays         *relaySet
+	cancel         context.CancelFunc
 }
 
 // NewDHTNode initializes and configures a libp2p host with DHT support
 func CreateLibp2pHost() (*DHTNode, error) {
 	ctx := context.Background()
+	cfg := config.NewConfig()
+	mode, err := dhtModeOption(cfg.DHTMode)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.EnableMDNS && mode == dht.ModeAuto {
+		// AutoNAT ignores LAN addresses, so on an offline LAN auto mode would never leave client mode and LAN peers
+		// would have no DHT to join
+		fmt.Println("Warning: mDNS is enabled, so the DHT runs in server mode; set DHTMode to \"client\" to prevent this")
+		mode = dht.ModeServer
+	}
+	hostOptions, dhtOptions, err := networkOptions(cfg)
+	if err != nil {
+		return nil, err
+	}
 
 	// configure to listen on any port
 	customAddr, err := multiaddr.NewMultiaddr("/ip4/0.0.0.0/tcp/0")
@@ -50,36 +74,47 @@ func CreateLibp2pHost() (*DHTNode, error) {
 	}
 
 	// generate node identity
-	privKey, err := GeneratePrivateKeyFromSeed()
+	privKey, err := LoadIdentity()
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
-	// parse relay address to multiaddress
-	relayAddr, err := multiaddr.NewMultiaddr(RelayNodeAddr)
-	if err != nil {
-		log.Fatalf("Failed to create relay multiaddr: %v", err)
+	// relays with an invalid address are skipped; without any the node is only reachable directly
+	relays := newRelaySet(cfg)
+	relayInfos := relays.infos()
+	if len(relayInfos) > 0 {
+		hostOptions = append(hostOptions, libp2p.EnableAutoRelayWithStaticRelays(relayInfos))
+	} else {
+		fmt.Println("No valid relay configured")
 	}
 
-	// convert the relay multiaddress to peer info
-	relayInfo, err := peer.AddrInfoFromP2pAddr(relayAddr)
+	// limit the streams and memory of every protocol registered with the streams package
+	resourceManager, err := streams.ResourceManager()
 	if err != nil {
-		log.Fatalf("Failed to create AddrInfo from relay multiaddr: %v", err)
+		return nil, fmt.Errorf("failed to create resource manager: %w", err)
 	}
 
 	// create libp2p node with configured features
-	node, err := libp2p.New(
+	node, err := libp2p.New(append([]libp2p.Option{
 		libp2p.ListenAddrs(customAddr),
 		libp2p.Identity(privKey),
 		libp2p.NATPortMap(),
 		libp2p.EnableNATService(),
-		libp2p.EnableAutoRelayWithStaticRelays([]peer.AddrInfo{*relayInfo}),
 		libp2p.EnableRelayService(),
 		libp2p.EnableHolePunching(),
-	)
+		libp2p.ConnectionGater(blocklist.NewGater()),
+		libp2p.ResourceManager(resourceManager),
+	}, hostOptions...)...)
 	if err != nil {
 		return nil, err
 	}
+	blocklist.Attach(node.Network())
+	for _, relayInfo := range relayInfos {
+		blocklist.Protect(relayInfo.ID)
+	}
+	if bootstrapInfo, err := peer.AddrInfoFromString(BootstrapNodeAddr); err == nil {
+		blocklist.Protect(bootstrapInfo.ID)
+	}
 
 	// start relay service
 	_, err = relay.New(node)
@@ -87,8 +122,8 @@ func CreateLibp2pHost() (*DHTNode, error) {
 		log.Printf("Failed to instantiate the relay: %v", err)
 	}
 
-	// start DHT client mode
-	kadDHT, err := dht.New(ctx, node, dht.Mode(dht.ModeClient))
+	// start DHT; in auto mode it serves queries only while AutoNAT reports the node as publicly reachable
+	kadDHT, err := dht.New(ctx, node, append(dhtOptions, dht.Mode(mode))...)
 	if err != nil {
 		return nil, err
 	}
@@ -110,34 +145,128 @@ func CreateLibp2pHost() (*DHTNode, error) {
 	node.Network().Notify(&network.NotifyBundle{
 		ConnectedF: func(n network.Network, conn network.Conn) {
 			fmt.Printf("Notification: New peer connected %s\n", conn.RemotePeer().String())
+			events.Publish(events.TopicPeer, "connected", map[string]string{
+				"peerID": conn.RemotePeer().String(),
+				"addr":   conn.RemoteMultiaddr().String(),
+			})
+		},
+		DisconnectedF: func(n network.Network, conn network.Conn) {
+			// a peer can have several connections; only report it once the last one closes
+			if n.Connectedness(conn.RemotePeer()) != network.Connected {
+				events.Publish(events.TopicPeer, "disconnected", map[string]string{"peerID": conn.RemotePeer().String()})
+			}
 		},
 	})
 
 	node.Network().Peers()
 
+	// cancelled when the node closes, stopping its background loops
+	nodeCtx, cancel := context.WithCancel(ctx)
 	dhtNode := &DHTNode{
-		Host: node,
-		DHT:  kadDHT,
-		Ctx:  ctx,
+		Host:           node,
+		DHT:            kadDHT,
+		Ctx:            nodeCtx,
+		configuredMode: strings.ToLower(cfg.DHTMode),
+		reachability:   &reachabilityTracker{},
+		pex:            newPeerExchange(),
+		relays:         relays,
+		cancel:         cancel,
+	}
+	if err := dhtNode.watchReachability(); err != nil {
+		return nil, err
+	}
+	if cfg.EnableMDNS {
+		// the node still works through the bootstrap node if the LAN does not allow multicast
+		if err := dhtNode.startMDNS(cfg); err != nil {
+			fmt.Printf("Error starting mDNS discovery: %v\n", err)
+		}
 	}
 
 	return dhtNode, nil
 }
 
-// creates a deterministic private key to maintain identity of node
-func GeneratePrivateKeyFromSeed() (crypto.PrivKey, error) {
-	seed := []byte("/orcanet/" + global_wallet.WalletAddr)
-	fmt.Printf("Seed: %s\n", seed)
-	hash := sha256.Sum256(seed)
-	privKey, _, err := crypto.GenerateEd25519Key(
-		bytes.NewReader(hash[:]),
-	)
+// File holding the node's identity key. It is generated randomly on first start and must stay private: the key signs
+// wallet attestations and identify descriptions, so anyone holding it can speak for the node
+const identityKeyFilePath = "./api/identity.key"
+
+// File remembering the peer ID of the last run, so a changed identity can be reported instead of noticed by peers
+const lastPeerIDFilePath = "./api/last_peer_id.txt"
+
+// Peer ID of the last run when it differs from the current one, set by LoadIdentity and reported by NetworkStatus
+var previousPeerID string
+
+// LoadIdentity returns the node's private key, creating and saving a random one on first start
+func LoadIdentity() (crypto.PrivKey, error) {
+	data, err := os.ReadFile(identityKeyFilePath)
+	if err == nil {
+		privKey, err := crypto.UnmarshalPrivateKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read identity key %s: %w", identityKeyFilePath, err)
+		}
+		notePeerIDChange(privKey, "")
+		return privKey, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read identity key %s: %w", identityKeyFilePath, err)
+	}
+
+	privKey, _, err := crypto.GenerateEd25519Key(rand.Reader)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate private key: %w", err)
 	}
+	data, err = crypto.MarshalPrivateKey(privKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal private key: %w", err)
+	}
+	if err := os.WriteFile(identityKeyFilePath, data, 0600); err != nil {
+		return nil, fmt.Errorf("failed to save identity key: %w", err)
+	}
+	fmt.Printf("Generated a new node identity in %s\n", identityKeyFilePath)
+	notePeerIDChange(privKey, legacyPeerID())
 	return privKey, nil
 }
 
+// Compares the peer ID against the one saved on the last run and remembers the current one. Without a saved ID the
+// fallback, if any, is taken as the last run's ID. A change is logged and kept for NetworkStatus, since peers that
+// pinned the old ID in their address books have to re-add this node
+func notePeerIDChange(privKey crypto.PrivKey, fallback string) {
+	id, err := peer.IDFromPrivateKey(privKey)
+	if err != nil {
+		return
+	}
+	last := fallback
+	if data, err := os.ReadFile(lastPeerIDFilePath); err == nil {
+		last = strings.TrimSpace(string(data))
+	}
+	if last != "" && last != id.String() {
+		previousPeerID = last
+		log.Printf("WARNING: this node's peer ID changed from %s to %s since the last run. Peers that pinned the old ID "+
+			"in their address book must re-add this node, and files are re-announced under the new ID", last, id)
+	}
+	if err := os.WriteFile(lastPeerIDFilePath, []byte(id.String()+"\n"), 0644); err != nil {
+		fmt.Printf("Failed to save peer ID: %v\n", err)
+	}
+}
+
+// Earlier versions derived the identity key from the wallet address, which anyone knowing the address could do too, so
+// that key is deliberately not carried over. Returns the peer ID such a version used for the current wallet, or ""
+// without a wallet
+func legacyPeerID() string {
+	if global_wallet.WalletAddr == "" {
+		return ""
+	}
+	hash := sha256.Sum256([]byte("/orcanet/" + global_wallet.WalletAddr))
+	legacyKey, _, err := crypto.GenerateEd25519Key(bytes.NewReader(hash[:]))
+	if err != nil {
+		return ""
+	}
+	legacyID, err := peer.IDFromPrivateKey(legacyKey)
+	if err != nil {
+		return ""
+	}
+	return legacyID.String()
+}
+
 type CustomValidator struct{}
 
 func (v *CustomValidator) Validate(key string, value []byte) error {
@@ -178,76 +307,27 @@ func (dhtNode *DHTNode) ConnectToPeer(peerAddr string) {
 }
 
 func (dhtNode *DHTNode) ConnectToPeerUsingRelay(targetPeerID string) {
-	ctx := dhtNode.Ctx
 	targetPeerID = strings.TrimSpace(targetPeerID)
-	relayAddr, err := multiaddr.NewMultiaddr(RelayNodeAddr)
-	if err != nil {
-		log.Printf("Failed to create relay multiaddr: %v", err)
-		return
-	}
-	peerMultiaddr := relayAddr.Encapsulate(multiaddr.StringCast("/p2p-circuit/p2p/" + targetPeerID))
-	relayedAddrInfo, err := peer.AddrInfoFromP2pAddr(peerMultiaddr)
+	peerID, err := peer.Decode(targetPeerID)
 	if err != nil {
-		log.Println("Failed to get relayed AddrInfo: %w", err)
+		log.Printf("Invalid peer ID %s: %v", targetPeerID, err)
 		return
 	}
 	// Connect to the peer through the relay
-	err = dhtNode.Host.Connect(ctx, *relayedAddrInfo)
-	if err != nil {
-		log.Println("Failed to connect to peer through relay: %w", err)
+	if err := dhtNode.connectViaRelay(dhtNode.Ctx, peerID); err != nil {
+		log.Printf("Failed to connect to peer through relay: %v", err)
 		return
 	}
 	fmt.Printf("Connected to peer via relay: %s\n", targetPeerID)
 }
 
-func (dhtNode *DHTNode) HandlePeerExchange() {
-	relayInfo, _ := peer.AddrInfoFromString(RelayNodeAddr)
-	dhtNode.Host.SetStreamHandler("/orcanet/p2p", func(s network.Stream) {
-		defer s.Close()
-		buf := bufio.NewReader(s)
-		peerAddr, err := buf.ReadString('\n')
-		if err != nil {
-			if err != io.EOF {
-				fmt.Printf("error reading from stream: %v", err)
-			}
-		}
-		peerAddr = strings.TrimSpace(peerAddr)
-		var data map[string]interface{}
-		err = json.Unmarshal([]byte(peerAddr), &data)
-		if err != nil {
-			fmt.Printf("error unmarshalling data: %v", err)
-		}
-		if knownPeers, ok := data["known_peers"].([]interface{}); ok {
-			for _, peerItem := range knownPeers {
-				fmt.Println("Peer:")
-				if peerMap, ok := peerItem.(map[string]interface{}); ok {
-					if peerID, ok := peerMap["peer_id"].(string); ok {
-						if peerID != relayInfo.ID.String() {
-							dhtNode.ConnectToPeerUsingRelay(peerID)
-						}
-					}
-				}
-			}
-		}
-	})
-}
-
-// makeReservation makes a reservation on the relay node
-func (dhtNode *DHTNode) MakeReservation() {
-	ctx := dhtNode.Ctx
-	relayInfo, err := peer.AddrInfoFromString(RelayNodeAddr)
-	if err != nil {
-		log.Fatalf("Failed to create addrInfo from string representation of relay multiaddr: %v", err)
-	}
-	_, err = client.Reserve(ctx, dhtNode.Host, *relayInfo)
-	if err != nil {
-		log.Fatalf("Failed to make reservation on relay: %v", err)
-	}
-	fmt.Printf("Reservation successful \n")
-}
-
 // announces that this node can provide a specific key
 func (dhtNode *DHTNode) ProvideKey(key string) error {
+	return dhtNode.ProvideKeyContext(dhtNode.Ctx, key)
+}
+
+// ProvideKeyContext is ProvideKey bounded by ctx, so callers can time out slow announcements
+func (dhtNode *DHTNode) ProvideKeyContext(ctx context.Context, key string) error {
 	data := []byte(key)
 	hash := sha256.Sum256(data)
 	mh, err := multihash.EncodeName(hash[:], "sha2-256")
@@ -257,7 +337,7 @@ func (dhtNode *DHTNode) ProvideKey(key string) error {
 	c := cid.NewCidV1(cid.Raw, mh)
 
 	// Start providing the key
-	err = dhtNode.DHT.Provide(dhtNode.Ctx, c, true)
+	err = dhtNode.DHT.Provide(ctx, c, true)
 	if err != nil {
 		return fmt.Errorf("failed to start providing key: %v", err)
 	}
@@ -316,5 +396,9 @@ func (dhtNode *DHTNode) GetClosestPeers(key string) ([]peer.ID, error) {
 
 // Shut down DHT Node
 func (dhtNode *DHTNode) Close() error {
+	dhtNode.cancel()
+	if dhtNode.lan != nil {
+		dhtNode.lan.service.Close()
+	}
 	return dhtNode.Host.Close()
 }
@@ -0,0 +1,135 @@
+package dhtnode
+
+import (
+	"Otternet/backend/api/events"
+	"Otternet/backend/config"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/p2p/discovery/mdns"
+)
+
+// Limit for connecting to a peer found on the local network
+const lanConnectTimeout = 10 * time.Second
+
+// LANPeer is a peer found through mDNS on the local network
+type LANPeer struct {
+	ID             string   `json:"id"`
+	Addrs          []string `json:"addrs"`
+	FoundAt        string   `json:"foundAt"`
+	Connected      bool     `json:"connected"`
+	InRoutingTable bool     `json:"inRoutingTable"`
+	LastError      string   `json:"lastError,omitempty"`
+}
+
+type lanDiscovery struct {
+	mutex   sync.Mutex
+	service mdns.Service
+	peers   map[peer.ID]*LANPeer
+}
+
+// mDNS service name. Nodes on a network with an ID advertise under their own name so they never find other networks
+func mdnsServiceName(cfg *config.Config) string {
+	if cfg.NetworkID != "" {
+		return "_otternet-" + cfg.NetworkID + "._udp"
+	}
+	return "_otternet._udp"
+}
+
+// Starts advertising this node and looking for others on the local network
+func (dhtNode *DHTNode) startMDNS(cfg *config.Config) error {
+	discovery := &lanDiscovery{peers: make(map[peer.ID]*LANPeer)}
+	dhtNode.lan = discovery
+	discovery.service = mdns.NewMdnsService(dhtNode.Host, mdnsServiceName(cfg), dhtNode)
+	if err := discovery.service.Start(); err != nil {
+		dhtNode.lan = nil
+		return fmt.Errorf("failed to start mDNS discovery: %w", err)
+	}
+	fmt.Printf("mDNS discovery started as %s\n", mdnsServiceName(cfg))
+	return nil
+}
+
+// HandlePeerFound is called by the mDNS service for every peer it sees on the local network
+func (dhtNode *DHTNode) HandlePeerFound(info peer.AddrInfo) {
+	if info.ID == dhtNode.Host.ID() || dhtNode.lan == nil {
+		return
+	}
+	discovery := dhtNode.lan
+	addrs := make([]string, 0, len(info.Addrs))
+	for _, addr := range info.Addrs {
+		addrs = append(addrs, addr.String())
+	}
+	discovery.mutex.Lock()
+	lanPeer, known := discovery.peers[info.ID]
+	if !known {
+		lanPeer = &LANPeer{ID: info.ID.String(), FoundAt: time.Now().Format(time.RFC3339)}
+		discovery.peers[info.ID] = lanPeer
+	}
+	lanPeer.Addrs = addrs
+	discovery.mutex.Unlock()
+	if !known {
+		fmt.Printf("mDNS: found peer %s\n", info.ID)
+		events.Publish(events.TopicPeer, "discovered", map[string]interface{}{"peerID": info.ID.String(), "source": "mdns", "addrs": addrs})
+	}
+	go dhtNode.connectLANPeer(info)
+}
+
+// Connects to a LAN peer and, if it answers DHT queries, adds it to the routing table so lookups work without the
+// bootstrap node
+func (dhtNode *DHTNode) connectLANPeer(info peer.AddrInfo) {
+	ctx, cancel := context.WithTimeout(dhtNode.Ctx, lanConnectTimeout)
+	defer cancel()
+	dhtNode.Host.Peerstore().AddAddrs(info.ID, info.Addrs, peerstore.TempAddrTTL)
+	err := dhtNode.Host.Connect(ctx, info)
+
+	inRoutingTable := false
+	if err == nil {
+		kadProtocol := DHTProtocolPrefix(config.NewConfig()) + kadProtocolSuffix
+		if supported, _ := dhtNode.Host.Peerstore().SupportsProtocols(info.ID, kadProtocol); len(supported) > 0 {
+			inRoutingTable, err = dhtNode.DHT.RoutingTable().TryAddPeer(info.ID, true, false)
+			inRoutingTable = inRoutingTable || dhtNode.DHT.RoutingTable().Find(info.ID) != ""
+		} else {
+			err = errors.New("peer does not serve the DHT; its DHTMode is probably \"client\"")
+		}
+	}
+
+	discovery := dhtNode.lan
+	discovery.mutex.Lock()
+	defer discovery.mutex.Unlock()
+	lanPeer, ok := discovery.peers[info.ID]
+	if !ok {
+		return
+	}
+	lanPeer.Connected = dhtNode.Host.Network().Connectedness(info.ID) == network.Connected
+	lanPeer.InRoutingTable = inRoutingTable
+	lanPeer.LastError = ""
+	if err != nil {
+		lanPeer.LastError = err.Error()
+		fmt.Printf("mDNS: error adding peer %s: %v\n", info.ID, err)
+	}
+}
+
+// LANPeers lists the peers found on the local network; it is empty when mDNS is off
+func (dhtNode *DHTNode) LANPeers() []LANPeer {
+	if dhtNode.lan == nil {
+		return make([]LANPeer, 0)
+	}
+	discovery := dhtNode.lan
+	discovery.mutex.Lock()
+	defer discovery.mutex.Unlock()
+	peers := make([]LANPeer, 0, len(discovery.peers))
+	for id, lanPeer := range discovery.peers {
+		current := *lanPeer
+		current.Connected = dhtNode.Host.Network().Connectedness(id) == network.Connected
+		peers = append(peers, current)
+	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i].ID < peers[j].ID })
+	return peers
+}
@@ -0,0 +1,64 @@
+package dhtnode
+
+import (
+	"Otternet/backend/config"
+	"fmt"
+	"os"
+
+	"github.com/libp2p/go-libp2p"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p/core/pnet"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/libp2p/go-libp2p/p2p/transport/tcp"
+)
+
+func init() {
+	cfg := config.NewConfig()
+	if cfg.RelayNodeAddr != "" {
+		RelayNodeAddr = cfg.RelayNodeAddr
+	}
+	if cfg.BootstrapNodeAddr != "" {
+		BootstrapNodeAddr = cfg.BootstrapNodeAddr
+	}
+}
+
+// Reads a pre-shared key in the go-ipfs swarm.key format ("/key/swarm/psk/1.0.0/", "/base16/", key)
+func loadPSK(path string) (pnet.PSK, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open private network key: %w", err)
+	}
+	defer file.Close()
+	psk, err := pnet.DecodeV1PSK(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode private network key %s: %w", path, err)
+	}
+	return psk, nil
+}
+
+// DHTProtocolPrefix is the prefix of the DHT protocols. Nodes only exchange DHT records with peers using the same prefix,
+// so a network with its own ID gets its own prefix unless one is configured
+func DHTProtocolPrefix(cfg *config.Config) protocol.ID {
+	if cfg.DHTProtocolPrefix != "" {
+		return protocol.ID(cfg.DHTProtocolPrefix)
+	}
+	if cfg.NetworkID != "" {
+		return protocol.ID("/otternet/" + cfg.NetworkID)
+	}
+	return dht.DefaultPrefix
+}
+
+// Host and DHT options that keep this node inside its configured network
+func networkOptions(cfg *config.Config) ([]libp2p.Option, []dht.Option, error) {
+	var hostOptions []libp2p.Option
+	if cfg.PrivateNetworkKey != "" {
+		psk, err := loadPSK(cfg.PrivateNetworkKey)
+		if err != nil {
+			return nil, nil, err
+		}
+		// QUIC, WebTransport and WebRTC cannot run behind a pre-shared key, so private networks use TCP only
+		hostOptions = append(hostOptions, libp2p.PrivateNetwork(psk), libp2p.Transport(tcp.NewTCPTransport))
+	}
+	dhtOptions := []dht.Option{dht.ProtocolPrefix(DHTProtocolPrefix(cfg))}
+	return hostOptions, dhtOptions, nil
+}
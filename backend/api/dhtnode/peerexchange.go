@@ -0,0 +1,473 @@
+package dhtnode
+
+import (
+	"Otternet/backend/api/blocklist"
+	"Otternet/backend/api/events"
+	"Otternet/backend/api/streams"
+	"Otternet/backend/config"
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/core/record"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// PeerExchangeProtocol carries PeerExchangeMessage in both directions: the dialer sends its message, the listener
+// answers with its own
+var PeerExchangeProtocol = streams.Protocol("/otternet/pex/1.0.0", pexLimits)
+
+// Protocol the relay node pushes its "known_peers" list on
+var legacyPeerExchangeProtocol = streams.Protocol("/orcanet/p2p", pexLimits)
+
+// PeerExchangeVersion is the schema version of PeerExchangeMessage. Messages with another version are ignored
+const PeerExchangeVersion = 1
+
+const (
+	pexMaxMessageSize  = 256 << 10 // bytes read from one stream
+	pexStreamTimeout   = 30 * time.Second
+	pexDialTimeout     = 20 * time.Second
+	pexDialConcurrency = 4
+	pexSenderWindow    = time.Hour // PeerExchangeSenderCap applies per sender within this window
+	pexMaxKnownPeers   = 512
+	pexInitialDelay    = 30 * time.Second
+)
+
+var pexLimits = streams.Limits{
+	Timeout:           pexStreamTimeout,
+	MaxMessageSize:    pexMaxMessageSize,
+	MaxStreams:        32,
+	MaxStreamsPerPeer: 2,
+}
+
+// PeerExchangeMessage lists known good Otternet peers
+type PeerExchangeMessage struct {
+	Version   int             `json:"version"`
+	NetworkID string          `json:"networkID"`
+	Peers     []ExchangedPeer `json:"peers"`
+}
+
+// ExchangedPeer is one peer in a PeerExchangeMessage. SignedRecord is the peer's own signed peer record envelope
+// when the sender has one; its addresses are preferred over Addrs because the sender cannot forge them
+type ExchangedPeer struct {
+	PeerID       string   `json:"peerID"`
+	Addrs        []string `json:"addrs,omitempty"`
+	SignedRecord []byte   `json:"signedRecord,omitempty"`
+}
+
+// KnownPeer is a peer learned through peer exchange
+type KnownPeer struct {
+	ID        string   `json:"id"`
+	Addrs     []string `json:"addrs"`
+	Verified  bool     `json:"verified"` // addresses came from a signed peer record
+	Source    string   `json:"source"`   // peer that told us about it
+	FirstSeen string   `json:"firstSeen"`
+	LastSeen  string   `json:"lastSeen"`
+	Good      bool     `json:"good"` // connected and speaks the peer exchange protocol
+	LastError string   `json:"lastError,omitempty"`
+}
+
+type pexSender struct {
+	lastMessage time.Time
+	windowStart time.Time
+	injected    int
+	dropped     int
+}
+
+type peerExchange struct {
+	mutex   sync.Mutex
+	known   map[peer.ID]*KnownPeer
+	senders map[peer.ID]*pexSender
+	dialing chan struct{} // semaphore bounding concurrent dials
+}
+
+func newPeerExchange() *peerExchange {
+	return &peerExchange{
+		known:   make(map[peer.ID]*KnownPeer),
+		senders: make(map[peer.ID]*pexSender),
+		dialing: make(chan struct{}, pexDialConcurrency),
+	}
+}
+
+// HandlePeerExchange serves the peer exchange protocol and gossips with connected Otternet peers until the node closes
+func (dhtNode *DHTNode) HandlePeerExchange() {
+	streams.Handle(dhtNode.Host, PeerExchangeProtocol, dhtNode.handlePeerExchangeStream)
+	streams.Handle(dhtNode.Host, legacyPeerExchangeProtocol, dhtNode.handleLegacyPeerExchange)
+	go dhtNode.gossipPeers()
+}
+
+func (dhtNode *DHTNode) handlePeerExchangeStream(s network.Stream) {
+	defer s.Close()
+	sender := s.Conn().RemotePeer()
+	var msg PeerExchangeMessage
+	if err := json.NewDecoder(s).Decode(&msg); err != nil {
+		fmt.Printf("Peer exchange: bad message from %s: %v\n", sender, err)
+		if blocklist.IsMalformed(err) {
+			blocklist.ReportViolation(sender, "malformed peer exchange message")
+		}
+		s.Reset()
+		return
+	}
+	dhtNode.receivePeers(sender, msg)
+	if err := json.NewEncoder(s).Encode(dhtNode.peerExchangeMessage(sender)); err != nil {
+		fmt.Printf("Peer exchange: error replying to %s: %v\n", sender, err)
+	}
+}
+
+// The relay node pushes {"known_peers": [{"peer_id"}]} without addresses. Only the configured relay is listened to,
+// and what it sends is subject to the same limits as regular peer exchange
+func (dhtNode *DHTNode) handleLegacyPeerExchange(s network.Stream) {
+	defer s.Close()
+	sender := s.Conn().RemotePeer()
+	if !dhtNode.IsRelay(sender) {
+		blocklist.ReportViolation(sender, "legacy peer exchange from a peer other than the relay")
+		s.Reset()
+		return
+	}
+	line, err := bufio.NewReader(s).ReadString('\n')
+	if err != nil && err != io.EOF {
+		fmt.Printf("Peer exchange: error reading from relay: %v\n", err)
+		return
+	}
+	var data struct {
+		KnownPeers []struct {
+			PeerID string `json:"peer_id"`
+		} `json:"known_peers"`
+	}
+	if err := json.Unmarshal([]byte(strings.TrimSpace(line)), &data); err != nil {
+		fmt.Printf("Peer exchange: error unmarshalling relay data: %v\n", err)
+		return
+	}
+	msg := PeerExchangeMessage{Version: PeerExchangeVersion, NetworkID: config.NewConfig().NetworkID}
+	for _, known := range data.KnownPeers {
+		msg.Peers = append(msg.Peers, ExchangedPeer{PeerID: known.PeerID})
+	}
+	dhtNode.receivePeers(sender, msg)
+}
+
+// Builds the message sent to recipient: connected peers that speak the protocol and peers whose exchange succeeded
+func (dhtNode *DHTNode) peerExchangeMessage(recipient peer.ID) PeerExchangeMessage {
+	cfg := config.NewConfig()
+	msg := PeerExchangeMessage{Version: PeerExchangeVersion, NetworkID: cfg.NetworkID, Peers: make([]ExchangedPeer, 0)}
+	candidates := make(map[peer.ID]bool)
+	for _, id := range dhtNode.Host.Network().Peers() {
+		if dhtNode.speaksPeerExchange(id) {
+			candidates[id] = true
+		}
+	}
+	pex := dhtNode.pex
+	pex.mutex.Lock()
+	for id, known := range pex.known {
+		if known.Good {
+			candidates[id] = true
+		}
+	}
+	pex.mutex.Unlock()
+	delete(candidates, recipient)
+	delete(candidates, dhtNode.Host.ID())
+
+	certified, _ := peerstore.GetCertifiedAddrBook(dhtNode.Host.Peerstore())
+	// our own signed record comes first; the recipient keeps it and passes it on, so anyone learning about us later
+	// gets addresses we signed ourselves
+	if certified != nil {
+		if envelope := certified.GetPeerRecord(dhtNode.Host.ID()); envelope != nil {
+			self := ExchangedPeer{PeerID: dhtNode.Host.ID().String()}
+			self.SignedRecord, _ = envelope.Marshal()
+			msg.Peers = append(msg.Peers, self)
+		}
+	}
+	for id := range candidates {
+		if len(msg.Peers) >= cfg.PeerExchangeMaxPeers {
+			break
+		}
+		entry := ExchangedPeer{PeerID: id.String()}
+		for _, addr := range dhtNode.Host.Peerstore().Addrs(id) {
+			entry.Addrs = append(entry.Addrs, addr.String())
+		}
+		if certified != nil {
+			if envelope := certified.GetPeerRecord(id); envelope != nil {
+				entry.SignedRecord, _ = envelope.Marshal()
+			}
+		}
+		msg.Peers = append(msg.Peers, entry)
+	}
+	return msg
+}
+
+func (dhtNode *DHTNode) speaksPeerExchange(id peer.ID) bool {
+	supported, err := dhtNode.Host.Peerstore().SupportsProtocols(id, PeerExchangeProtocol)
+	return err == nil && len(supported) > 0
+}
+
+// Reads the addresses of an exchanged peer, preferring its signed record. A record signed by anyone other than the
+// peer it claims to describe is rejected
+func verifyExchangedPeer(entry ExchangedPeer) (peer.ID, []multiaddr.Multiaddr, *record.Envelope, error) {
+	id, err := peer.Decode(entry.PeerID)
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("invalid peer ID: %w", err)
+	}
+	if len(entry.SignedRecord) > 0 {
+		var rec peer.PeerRecord
+		envelope, err := record.ConsumeTypedEnvelope(entry.SignedRecord, &rec)
+		if err != nil {
+			return "", nil, nil, fmt.Errorf("invalid signed record: %w", err)
+		}
+		signer, err := peer.IDFromPublicKey(envelope.PublicKey)
+		if err != nil || signer != id || rec.PeerID != id {
+			return "", nil, nil, fmt.Errorf("signed record for %s is not signed by that peer", id)
+		}
+		return id, rec.Addrs, envelope, nil
+	}
+	var addrs []multiaddr.Multiaddr
+	for _, s := range entry.Addrs {
+		if addr, err := multiaddr.NewMultiaddr(s); err == nil {
+			addrs = append(addrs, addr)
+		}
+	}
+	return id, addrs, nil, nil
+}
+
+// Applies a received message: rate limits the sender, caps how many new peers it may add, skips peers that are
+// already connected or known, and dials the rest
+func (dhtNode *DHTNode) receivePeers(sender peer.ID, msg PeerExchangeMessage) {
+	cfg := config.NewConfig()
+	if msg.Version != PeerExchangeVersion {
+		fmt.Printf("Peer exchange: ignoring version %d message from %s\n", msg.Version, sender)
+		return
+	}
+	if msg.NetworkID != cfg.NetworkID {
+		fmt.Printf("Peer exchange: ignoring %s from network %q\n", sender, msg.NetworkID)
+		return
+	}
+
+	pex := dhtNode.pex
+	pex.mutex.Lock()
+	now := time.Now()
+	state, ok := pex.senders[sender]
+	if !ok {
+		// senders whose rate limit and window have both run out would start over anyway, so drop them
+		for id, other := range pex.senders {
+			if now.Sub(other.windowStart) > pexSenderWindow && now.Sub(other.lastMessage) >= cfg.PeerExchangeMinInterval {
+				delete(pex.senders, id)
+			}
+		}
+		state = &pexSender{windowStart: now}
+		pex.senders[sender] = state
+	}
+	if !state.lastMessage.IsZero() && now.Sub(state.lastMessage) < cfg.PeerExchangeMinInterval {
+		state.dropped++
+		pex.mutex.Unlock()
+		return
+	}
+	state.lastMessage = now
+	if now.Sub(state.windowStart) > pexSenderWindow {
+		state.windowStart = now
+		state.injected = 0
+	}
+	if senderEntry, ok := pex.known[sender]; ok {
+		senderEntry.Good = true
+		senderEntry.LastSeen = now.Format(time.RFC3339)
+	}
+
+	entries := msg.Peers
+	if len(entries) > cfg.PeerExchangeMaxPeers {
+		entries = entries[:cfg.PeerExchangeMaxPeers]
+	}
+	var toDial []peer.AddrInfo
+	var invalidEntry error
+	for _, entry := range entries {
+		id, addrs, envelope, err := verifyExchangedPeer(entry)
+		if err != nil {
+			fmt.Printf("Peer exchange: dropping entry from %s: %v\n", sender, err)
+			invalidEntry = err
+			continue
+		}
+		if id == sender && envelope != nil {
+			if certified, ok := peerstore.GetCertifiedAddrBook(dhtNode.Host.Peerstore()); ok {
+				certified.ConsumePeerRecord(envelope, peerstore.RecentlyConnectedAddrTTL)
+			}
+			continue
+		}
+		if id == dhtNode.Host.ID() || id == sender || dhtNode.Host.Network().Connectedness(id) == network.Connected {
+			continue
+		}
+		if known, ok := pex.known[id]; ok {
+			known.LastSeen = now.Format(time.RFC3339)
+			continue
+		}
+		if state.injected >= cfg.PeerExchangeSenderCap {
+			state.dropped++
+			continue
+		}
+		if !pex.makeRoomLocked() {
+			break
+		}
+		state.injected++
+
+		if envelope != nil {
+			if certified, ok := peerstore.GetCertifiedAddrBook(dhtNode.Host.Peerstore()); ok {
+				certified.ConsumePeerRecord(envelope, peerstore.TempAddrTTL)
+			}
+		} else {
+			dhtNode.Host.Peerstore().AddAddrs(id, addrs, peerstore.TempAddrTTL)
+		}
+		addrStrings := make([]string, 0, len(addrs))
+		for _, addr := range addrs {
+			addrStrings = append(addrStrings, addr.String())
+		}
+		pex.known[id] = &KnownPeer{
+			ID:        id.String(),
+			Addrs:     addrStrings,
+			Verified:  envelope != nil,
+			Source:    sender.String(),
+			FirstSeen: now.Format(time.RFC3339),
+			LastSeen:  now.Format(time.RFC3339),
+		}
+		toDial = append(toDial, peer.AddrInfo{ID: id, Addrs: addrs})
+	}
+	pex.mutex.Unlock()
+
+	// one bad message is one violation, however many of its entries are bad
+	if invalidEntry != nil {
+		blocklist.ReportViolation(sender, "invalid peer exchange entry: "+invalidEntry.Error())
+	}
+	for _, info := range toDial {
+		go dhtNode.dialExchangedPeer(info)
+	}
+}
+
+// Evicts the oldest entry that is not a good peer if the table is full. Caller holds pex.mutex
+func (pex *peerExchange) makeRoomLocked() bool {
+	if len(pex.known) < pexMaxKnownPeers {
+		return true
+	}
+	var oldest peer.ID
+	oldestSeen := ""
+	for id, known := range pex.known {
+		if !known.Good && (oldestSeen == "" || known.LastSeen < oldestSeen) {
+			oldest, oldestSeen = id, known.LastSeen
+		}
+	}
+	if oldestSeen == "" {
+		return false
+	}
+	delete(pex.known, oldest)
+	return true
+}
+
+// Dials a peer learned through exchange, directly when addresses are known and through the relay otherwise
+func (dhtNode *DHTNode) dialExchangedPeer(info peer.AddrInfo) {
+	pex := dhtNode.pex
+	select {
+	case pex.dialing <- struct{}{}:
+	case <-dhtNode.Ctx.Done():
+		return
+	}
+	defer func() { <-pex.dialing }()
+
+	ctx, cancel := context.WithTimeout(dhtNode.Ctx, pexDialTimeout)
+	defer cancel()
+	var err error
+	if len(info.Addrs) > 0 {
+		err = dhtNode.Host.Connect(ctx, info)
+	}
+	if len(info.Addrs) == 0 || err != nil {
+		err = dhtNode.connectViaRelay(ctx, info.ID)
+	}
+
+	pex.mutex.Lock()
+	defer pex.mutex.Unlock()
+	known, ok := pex.known[info.ID]
+	if !ok {
+		return
+	}
+	known.LastError = ""
+	if err != nil {
+		known.LastError = err.Error()
+		return
+	}
+	known.Good = dhtNode.speaksPeerExchange(info.ID)
+	if known.Good {
+		events.Publish(events.TopicPeer, "discovered", map[string]interface{}{"peerID": known.ID, "source": "pex", "via": known.Source})
+	}
+}
+
+// Exchanges peer lists with a few random connected Otternet peers every PeerExchangeInterval
+func (dhtNode *DHTNode) gossipPeers() {
+	cfg := config.NewConfig()
+	timer := time.NewTimer(pexInitialDelay)
+	defer timer.Stop()
+	for {
+		select {
+		case <-dhtNode.Ctx.Done():
+			return
+		case <-timer.C:
+		}
+		var targets []peer.ID
+		for _, id := range dhtNode.Host.Network().Peers() {
+			if dhtNode.speaksPeerExchange(id) {
+				targets = append(targets, id)
+			}
+		}
+		rand.Shuffle(len(targets), func(i, j int) { targets[i], targets[j] = targets[j], targets[i] })
+		if len(targets) > cfg.PeerExchangeFanout {
+			targets = targets[:cfg.PeerExchangeFanout]
+		}
+		for _, id := range targets {
+			if err := dhtNode.exchangePeers(id); err != nil {
+				fmt.Printf("Peer exchange with %s failed: %v\n", id, err)
+			}
+		}
+		timer.Reset(cfg.PeerExchangeInterval)
+	}
+}
+
+// Sends our peer list to id and applies the list it answers with
+func (dhtNode *DHTNode) exchangePeers(id peer.ID) error {
+	ctx, cancel := context.WithTimeout(dhtNode.Ctx, pexStreamTimeout)
+	defer cancel()
+	stream, err := dhtNode.Host.NewStream(ctx, id, PeerExchangeProtocol)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+	stream.SetDeadline(time.Now().Add(pexStreamTimeout))
+	if err := json.NewEncoder(stream).Encode(dhtNode.peerExchangeMessage(id)); err != nil {
+		stream.Reset()
+		return err
+	}
+	stream.CloseWrite()
+	var reply PeerExchangeMessage
+	if err := json.NewDecoder(io.LimitReader(stream, pexMaxMessageSize)).Decode(&reply); err != nil {
+		if blocklist.IsMalformed(err) {
+			blocklist.ReportViolation(id, "malformed peer exchange reply")
+		}
+		stream.Reset()
+		return err
+	}
+	dhtNode.receivePeers(id, reply)
+	return nil
+}
+
+// ExchangedPeers lists the peers learned through peer exchange
+func (dhtNode *DHTNode) ExchangedPeers() []KnownPeer {
+	pex := dhtNode.pex
+	pex.mutex.Lock()
+	defer pex.mutex.Unlock()
+	peers := make([]KnownPeer, 0, len(pex.known))
+	for _, known := range pex.known {
+		peers = append(peers, *known)
+	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i].ID < peers[j].ID })
+	return peers
+}
@@ -0,0 +1,103 @@
+package dhtnode
+
+import (
+	"crypto/rand"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/record"
+	"github.com/multiformats/go-multiaddr"
+)
+
+func newTestPeer(t *testing.T) (crypto.PrivKey, peer.ID) {
+	t.Helper()
+	privKey, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := peer.IDFromPrivateKey(privKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return privKey, id
+}
+
+// Seals a peer record describing id with signer's key
+func signedRecord(t *testing.T, signer crypto.PrivKey, id peer.ID, addr string) []byte {
+	t.Helper()
+	rec := &peer.PeerRecord{PeerID: id, Addrs: []multiaddr.Multiaddr{multiaddr.StringCast(addr)}, Seq: 1}
+	envelope, err := record.Seal(rec, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := envelope.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestVerifyExchangedPeerSignedRecord(t *testing.T) {
+	key, id := newTestPeer(t)
+	entry := ExchangedPeer{
+		PeerID:       id.String(),
+		Addrs:        []string{"/ip4/10.0.0.1/tcp/4001"},
+		SignedRecord: signedRecord(t, key, id, "/ip4/1.2.3.4/tcp/4001"),
+	}
+	got, addrs, envelope, err := verifyExchangedPeer(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != id || envelope == nil {
+		t.Fatalf("got %s with envelope %v, want %s with an envelope", got, envelope, id)
+	}
+	// the signed addresses win over the ones the sender listed
+	if len(addrs) != 1 || addrs[0].String() != "/ip4/1.2.3.4/tcp/4001" {
+		t.Fatalf("addrs = %v, want the signed address only", addrs)
+	}
+}
+
+func TestVerifyExchangedPeerRejectsForgedRecords(t *testing.T) {
+	key, id := newTestPeer(t)
+	otherKey, otherID := newTestPeer(t)
+	tests := []struct {
+		name  string
+		entry ExchangedPeer
+	}{
+		{"record about the peer signed by someone else", ExchangedPeer{
+			PeerID:       id.String(),
+			SignedRecord: signedRecord(t, otherKey, id, "/ip4/1.2.3.4/tcp/4001"),
+		}},
+		{"another peer's genuine record", ExchangedPeer{
+			PeerID:       id.String(),
+			SignedRecord: signedRecord(t, otherKey, otherID, "/ip4/1.2.3.4/tcp/4001"),
+		}},
+		{"record about another peer signed by the peer", ExchangedPeer{
+			PeerID:       id.String(),
+			SignedRecord: signedRecord(t, key, otherID, "/ip4/1.2.3.4/tcp/4001"),
+		}},
+		{"corrupt record", ExchangedPeer{PeerID: id.String(), SignedRecord: []byte("not an envelope")}},
+		{"invalid peer ID", ExchangedPeer{PeerID: "not-a-peer-id", Addrs: []string{"/ip4/1.2.3.4/tcp/4001"}}},
+	}
+	for _, test := range tests {
+		if _, _, _, err := verifyExchangedPeer(test.entry); err == nil {
+			t.Errorf("%s: entry was accepted", test.name)
+		}
+	}
+}
+
+func TestVerifyExchangedPeerUnsignedAddrs(t *testing.T) {
+	_, id := newTestPeer(t)
+	entry := ExchangedPeer{PeerID: id.String(), Addrs: []string{"/ip4/1.2.3.4/tcp/4001", "not an address"}}
+	got, addrs, envelope, err := verifyExchangedPeer(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != id || envelope != nil {
+		t.Fatalf("got %s with envelope %v, want %s without one", got, envelope, id)
+	}
+	if len(addrs) != 1 || addrs[0].String() != "/ip4/1.2.3.4/tcp/4001" {
+		t.Fatalf("addrs = %v, want the valid address only", addrs)
+	}
+}
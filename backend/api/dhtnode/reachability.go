@@ -0,0 +1,134 @@
+package dhtnode
+
+import (
+	"Otternet/backend/api/events"
+	"Otternet/backend/config"
+	"fmt"
+	"strings"
+	"sync"
+
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/network"
+)
+
+// Config values for DHTMode
+const (
+	DHTModeAuto   = "auto"
+	DHTModeServer = "server"
+	DHTModeClient = "client"
+)
+
+// Suffix of the Kademlia protocol; a host only handles it while the DHT is in server mode
+const kadProtocolSuffix = "/kad/1.0.0"
+
+// NetworkStatus is what AutoNAT and identify have learned about how reachable this node is
+type NetworkStatus struct {
+	Reachability   string   `json:"reachability"`   // Unknown, Public or Private
+	NATTypeTCP     string   `json:"natTypeTCP"`     // Unknown, Cone or Symmetric
+	NATTypeUDP     string   `json:"natTypeUDP"`     // Unknown, Cone or Symmetric
+	DHTMode        string   `json:"dhtMode"`        // mode the DHT is operating in right now
+	ConfiguredMode string   `json:"configuredMode"` // DHTModeAuto, DHTModeServer or DHTModeClient
+	NetworkID      string   `json:"networkID"`      // empty on the public network
+	PrivateNetwork bool     `json:"privateNetwork"` // connections require the pre-shared key
+	DHTPrefix      string   `json:"dhtPrefix"`
+	ListenAddrs    []string `json:"listenAddrs"`
+	PeerID         string   `json:"peerID"`
+	PreviousPeerID string   `json:"previousPeerID,omitempty"` // ID of the last run when it has changed since
+}
+
+type reachabilityTracker struct {
+	mutex        sync.Mutex
+	reachability network.Reachability
+	natTCP       network.NATDeviceType
+	natUDP       network.NATDeviceType
+}
+
+// Maps the DHTMode config value to a DHT option
+func dhtModeOption(mode string) (dht.ModeOpt, error) {
+	switch strings.ToLower(mode) {
+	case "", DHTModeAuto:
+		return dht.ModeAuto, nil
+	case DHTModeServer:
+		return dht.ModeServer, nil
+	case DHTModeClient:
+		return dht.ModeClient, nil
+	}
+	return 0, fmt.Errorf("unknown DHT mode %q; expected %q, %q or %q", mode, DHTModeAuto, DHTModeServer, DHTModeClient)
+}
+
+// Records reachability and NAT type changes until the host shuts down. The DHT subscribes to the same reachability
+// events itself when running in auto mode
+func (dhtNode *DHTNode) watchReachability() error {
+	sub, err := dhtNode.Host.EventBus().Subscribe([]interface{}{
+		new(event.EvtLocalReachabilityChanged),
+		new(event.EvtNATDeviceTypeChanged),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to reachability events: %w", err)
+	}
+	go func() {
+		defer sub.Close()
+		for e := range sub.Out() {
+			tracker := dhtNode.reachability
+			tracker.mutex.Lock()
+			switch evt := e.(type) {
+			case event.EvtLocalReachabilityChanged:
+				tracker.reachability = evt.Reachability
+			case event.EvtNATDeviceTypeChanged:
+				if evt.TransportProtocol == network.NATTransportUDP {
+					tracker.natUDP = evt.NatDeviceType
+				} else {
+					tracker.natTCP = evt.NatDeviceType
+				}
+			}
+			tracker.mutex.Unlock()
+
+			status := dhtNode.NetworkStatus()
+			fmt.Printf("Reachability: %s, NAT TCP: %s, NAT UDP: %s, DHT mode: %s\n",
+				status.Reachability, status.NATTypeTCP, status.NATTypeUDP, status.DHTMode)
+			events.Publish(events.TopicNetwork, "reachability", status)
+		}
+	}()
+	return nil
+}
+
+// Reports whether the host currently answers DHT queries
+func (dhtNode *DHTNode) isDHTServer() bool {
+	for _, id := range dhtNode.Host.Mux().Protocols() {
+		if strings.HasSuffix(string(id), kadProtocolSuffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// NetworkStatus returns the node's current reachability, NAT types, DHT mode and peer ID, along with the previous peer
+// ID if it changed since the last run
+func (dhtNode *DHTNode) NetworkStatus() NetworkStatus {
+	tracker := dhtNode.reachability
+	tracker.mutex.Lock()
+	status := NetworkStatus{
+		Reachability:   tracker.reachability.String(),
+		NATTypeTCP:     tracker.natTCP.String(),
+		NATTypeUDP:     tracker.natUDP.String(),
+		ConfiguredMode: dhtNode.configuredMode,
+	}
+	tracker.mutex.Unlock()
+
+	cfg := config.NewConfig()
+	status.NetworkID = cfg.NetworkID
+	status.PrivateNetwork = cfg.PrivateNetworkKey != ""
+	status.DHTPrefix = string(DHTProtocolPrefix(cfg))
+	status.DHTMode = DHTModeClient
+	if dhtNode.isDHTServer() {
+		status.DHTMode = DHTModeServer
+	}
+	status.PeerID = dhtNode.Host.ID().String()
+	status.PreviousPeerID = previousPeerID
+	status.ListenAddrs = make([]string, 0)
+	for _, addr := range dhtNode.Host.Addrs() {
+		status.ListenAddrs = append(status.ListenAddrs, addr.String())
+	}
+	return status
+}
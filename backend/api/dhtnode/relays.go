@@ -0,0 +1,339 @@
+package dhtnode
+
+import (
+	"Otternet/backend/api/events"
+	"Otternet/backend/config"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/client"
+	"github.com/libp2p/go-libp2p/p2p/protocol/ping"
+	"github.com/multiformats/go-multiaddr"
+)
+
+const (
+	relayCheckTimeout  = 15 * time.Second
+	relayDialTimeout   = 20 * time.Second // limit for reaching a peer through one relay
+	relayRefreshMargin = 5 * time.Minute  // reservations are renewed this long before they expire
+)
+
+// RelayStatus is the last known state of one relay
+type RelayStatus struct {
+	ID                 string  `json:"id"`
+	Addr               string  `json:"addr"`
+	Healthy            bool    `json:"healthy"`
+	Reserved           bool    `json:"reserved"`
+	ReservationExpires string  `json:"reservationExpires,omitempty"`
+	LatencyMs          float64 `json:"latencyMs,omitempty"`
+	LastChecked        string  `json:"lastChecked,omitempty"`
+	LastError          string  `json:"lastError,omitempty"`
+}
+
+type relayState struct {
+	info        peer.AddrInfo
+	status      RelayStatus
+	reservation *client.Reservation
+}
+
+type relaySet struct {
+	mutex   sync.Mutex
+	relays  []*relayState // in configured order, which is also the order of preference
+	checked bool          // the first round of health checks and reservations has finished
+}
+
+// RelayReport is the state of every relay and whether the node can be reached through any of them yet
+type RelayReport struct {
+	Relays   []RelayStatus `json:"relays"`
+	Checking bool          `json:"checking"` // the first round of health checks is still running
+	Reserved bool          `json:"reserved"` // at least one relay holds a reservation for this node
+}
+
+// RelayAddrs lists the configured relays: RelayNodeAddr first, then RelayNodeAddrs, without duplicates
+func RelayAddrs(cfg *config.Config) []string {
+	seen := make(map[string]bool)
+	var addrs []string
+	for _, addr := range append([]string{RelayNodeAddr}, cfg.RelayNodeAddrs...) {
+		if addr != "" && !seen[addr] {
+			seen[addr] = true
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+// Parses the configured relays, skipping any that are not valid /p2p multiaddrs
+func newRelaySet(cfg *config.Config) *relaySet {
+	set := &relaySet{}
+	for _, addr := range RelayAddrs(cfg) {
+		info, err := peer.AddrInfoFromString(addr)
+		if err != nil {
+			fmt.Printf("Relays: ignoring %s: %v\n", addr, err)
+			continue
+		}
+		set.relays = append(set.relays, &relayState{info: *info, status: RelayStatus{ID: info.ID.String(), Addr: addr}})
+	}
+	return set
+}
+
+// Returns the address info of every relay
+func (set *relaySet) infos() []peer.AddrInfo {
+	set.mutex.Lock()
+	defer set.mutex.Unlock()
+	infos := make([]peer.AddrInfo, 0, len(set.relays))
+	for _, relay := range set.relays {
+		infos = append(infos, relay.info)
+	}
+	return infos
+}
+
+// IsRelay reports whether id is one of the configured relays
+func (dhtNode *DHTNode) IsRelay(id peer.ID) bool {
+	for _, info := range dhtNode.relays.infos() {
+		if info.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// StartRelays checks every relay, reserves a slot on the best healthy ones and keeps doing so in the background,
+// renewing reservations before they expire and moving to another relay when one stops answering. It returns at once;
+// until a reservation is made the node is only reachable directly, which RelayReport shows
+func (dhtNode *DHTNode) StartRelays() {
+	go func() {
+		dhtNode.maintainRelays()
+		set := dhtNode.relays
+		set.mutex.Lock()
+		set.checked = true
+		set.mutex.Unlock()
+		if !dhtNode.RelayReport().Reserved {
+			fmt.Println("Relays: no relay accepted a reservation yet; retrying in the background")
+		}
+
+		ticker := time.NewTicker(config.NewConfig().RelayHealthInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-dhtNode.Ctx.Done():
+				return
+			case <-ticker.C:
+				dhtNode.maintainRelays()
+			}
+		}
+	}()
+}
+
+// One round of health checks and reservations
+func (dhtNode *DHTNode) maintainRelays() {
+	set := dhtNode.relays
+	set.mutex.Lock()
+	relays := append([]*relayState(nil), set.relays...)
+	set.mutex.Unlock()
+
+	var wg sync.WaitGroup
+	for _, relay := range relays {
+		wg.Add(1)
+		go func(relay *relayState) {
+			defer wg.Done()
+			dhtNode.checkRelay(relay)
+		}(relay)
+	}
+	wg.Wait()
+
+	// keep reservations on the first RelayReservations healthy relays and let the others lapse
+	wanted := config.NewConfig().RelayReservations
+	for _, relay := range relays {
+		set.mutex.Lock()
+		healthy := relay.status.Healthy
+		reservation := relay.reservation
+		if !healthy && reservation != nil {
+			relay.reservation = nil
+			relay.status.Reserved = false
+			relay.status.ReservationExpires = ""
+			fmt.Printf("Relays: dropped reservation on unhealthy relay %s\n", relay.info.ID)
+		}
+		set.mutex.Unlock()
+		if !healthy {
+			continue
+		}
+		if wanted <= 0 {
+			// a spare relay's reservation is left to run out
+			set.mutex.Lock()
+			if relay.reservation != nil && time.Now().After(relay.reservation.Expiration) {
+				relay.reservation = nil
+				relay.status.Reserved = false
+				relay.status.ReservationExpires = ""
+			}
+			set.mutex.Unlock()
+			continue
+		}
+		if reservation != nil && time.Until(reservation.Expiration) > relayRefreshMargin {
+			wanted--
+			continue
+		}
+		if dhtNode.reserve(relay) {
+			wanted--
+		}
+	}
+}
+
+// Connects to a relay and pings it
+func (dhtNode *DHTNode) checkRelay(relay *relayState) {
+	ctx, cancel := context.WithTimeout(dhtNode.Ctx, relayCheckTimeout)
+	defer cancel()
+	dhtNode.Host.Peerstore().AddAddrs(relay.info.ID, relay.info.Addrs, peerstore.PermanentAddrTTL)
+	err := dhtNode.Host.Connect(ctx, relay.info)
+	var rtt time.Duration
+	if err == nil {
+		// relays that do not run the ping service still count as healthy while connected
+		result := <-ping.Ping(ctx, dhtNode.Host, relay.info.ID)
+		if result.Error == nil {
+			rtt = result.RTT
+		} else if dhtNode.Host.Network().Connectedness(relay.info.ID) != network.Connected {
+			err = result.Error
+		}
+	}
+
+	set := dhtNode.relays
+	set.mutex.Lock()
+	wasChecked := relay.status.LastChecked != ""
+	wasHealthy := relay.status.Healthy
+	relay.status.Healthy = err == nil
+	relay.status.LastChecked = time.Now().Format(time.RFC3339)
+	relay.status.LastError = ""
+	if err != nil {
+		relay.status.LastError = err.Error()
+	}
+	if rtt > 0 {
+		relay.status.LatencyMs = float64(rtt) / float64(time.Millisecond)
+	}
+	status := relay.status
+	set.mutex.Unlock()
+
+	if !wasChecked || wasHealthy != status.Healthy {
+		if err != nil {
+			fmt.Printf("Relays: %s is unreachable: %v\n", relay.info.ID, err)
+		} else {
+			fmt.Printf("Relays: %s is reachable\n", relay.info.ID)
+		}
+		events.Publish(events.TopicNetwork, "relay", status)
+	}
+}
+
+// Makes or renews a reservation on a relay and reports whether the relay holds one afterwards
+func (dhtNode *DHTNode) reserve(relay *relayState) bool {
+	ctx, cancel := context.WithTimeout(dhtNode.Ctx, relayCheckTimeout)
+	defer cancel()
+	reservation, err := client.Reserve(ctx, dhtNode.Host, relay.info)
+
+	set := dhtNode.relays
+	set.mutex.Lock()
+	if err != nil {
+		relay.status.LastError = fmt.Sprintf("reservation failed: %v", err)
+		stillValid := relay.reservation != nil && time.Now().Before(relay.reservation.Expiration)
+		if !stillValid {
+			relay.reservation = nil
+			relay.status.Reserved = false
+			relay.status.ReservationExpires = ""
+		}
+		status := relay.status
+		set.mutex.Unlock()
+		fmt.Printf("Relays: reservation on %s failed: %v\n", relay.info.ID, err)
+		events.Publish(events.TopicNetwork, "relay", status)
+		return stillValid
+	}
+	renewed := relay.reservation != nil
+	relay.reservation = reservation
+	relay.status.Reserved = true
+	relay.status.ReservationExpires = reservation.Expiration.Format(time.RFC3339)
+	status := relay.status
+	set.mutex.Unlock()
+
+	if renewed {
+		fmt.Printf("Relays: renewed reservation on %s until %s\n", relay.info.ID, status.ReservationExpires)
+	} else {
+		fmt.Printf("Relays: reservation on %s until %s\n", relay.info.ID, status.ReservationExpires)
+	}
+	events.Publish(events.TopicNetwork, "relay", status)
+	return true
+}
+
+// Relays reports the state of every configured relay
+func (dhtNode *DHTNode) Relays() []RelayStatus {
+	set := dhtNode.relays
+	set.mutex.Lock()
+	defer set.mutex.Unlock()
+	statuses := make([]RelayStatus, 0, len(set.relays))
+	for _, relay := range set.relays {
+		statuses = append(statuses, relay.status)
+	}
+	return statuses
+}
+
+// RelayReport reports the relays along with whether the first check has finished and any reservation is held
+func (dhtNode *DHTNode) RelayReport() RelayReport {
+	report := RelayReport{Relays: dhtNode.Relays()}
+	set := dhtNode.relays
+	set.mutex.Lock()
+	report.Checking = !set.checked
+	set.mutex.Unlock()
+	for _, status := range report.Relays {
+		report.Reserved = report.Reserved || status.Reserved
+	}
+	return report
+}
+
+// Relays to dial through, best first: healthy ones before the rest, faster before slower, then in configured order
+func (set *relaySet) dialOrder() []*relayState {
+	set.mutex.Lock()
+	defer set.mutex.Unlock()
+	relays := append([]*relayState(nil), set.relays...)
+	sort.SliceStable(relays, func(i, j int) bool {
+		a, b := relays[i].status, relays[j].status
+		if a.Healthy != b.Healthy {
+			return a.Healthy
+		}
+		return a.LatencyMs > 0 && (b.LatencyMs == 0 || a.LatencyMs < b.LatencyMs)
+	})
+	return relays
+}
+
+// dials a peer through a circuit on each relay in turn until one connects
+func (dhtNode *DHTNode) connectViaRelay(ctx context.Context, peerID peer.ID) error {
+	relays := dhtNode.relays.dialOrder()
+	if len(relays) == 0 {
+		return errors.New("no relays configured")
+	}
+	var errs []error
+	for _, relay := range relays {
+		relayAddr, err := multiaddr.NewMultiaddr(relay.status.Addr)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		circuit := relayAddr.Encapsulate(multiaddr.StringCast("/p2p-circuit/p2p/" + peerID.String()))
+		relayedAddrInfo, err := peer.AddrInfoFromP2pAddr(circuit)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		dialCtx, cancel := context.WithTimeout(ctx, relayDialTimeout)
+		err = dhtNode.Host.Connect(dialCtx, *relayedAddrInfo)
+		cancel()
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, fmt.Errorf("via %s: %w", relay.info.ID, err))
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return errors.Join(errs...)
+}
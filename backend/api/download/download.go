@@ -22,6 +22,9 @@ type FormData struct {
 	Timestamp  string  `json:"timestamp"`
 	FileHash   string  `json:"fileHash"`
 	BundleMode bool    `json:"bundleMode"`
+
+	PaymentTxID   string `json:"paymentTxID,omitempty"`
+	PaymentStatus string `json:"paymentStatus,omitempty"` // "pending", "confirmed" or "failed"
 }
 
 var mutex = &sync.Mutex{}
@@ -197,3 +200,63 @@ func StoreFile(postData FormData) int {
 	}
 	return 0
 }
+
+// Links a payment transaction to the most recent download of fileHash from srcID and records its status.
+// A record already linked to a different transaction is only updated if replacedTxID matches it (fee bumps)
+func UpdatePaymentStatus(fileHash string, srcID string, txid string, replacedTxID string, status string) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	existingData, err := os.ReadFile(jsonFilePath)
+	if err != nil {
+		return fmt.Errorf("error reading downloads file: %w", err)
+	}
+	var postDatas []FormData
+	err = json.Unmarshal(existingData, &postDatas)
+	if err != nil {
+		return fmt.Errorf("error unmarshalling downloads file: %w", err)
+	}
+
+	found := false
+	for i := len(postDatas) - 1; i >= 0; i-- {
+		data := postDatas[i]
+		if data.FileHash != fileHash || (srcID != "" && data.SrcID != srcID) {
+			continue
+		}
+		if data.PaymentTxID != "" && data.PaymentTxID != txid && (replacedTxID == "" || data.PaymentTxID != replacedTxID) {
+			continue
+		}
+		postDatas[i].PaymentTxID = txid
+		postDatas[i].PaymentStatus = status
+		found = true
+		break
+	}
+	if !found {
+		return fmt.Errorf("no download of %s found for payment %s", fileHash, txid)
+	}
+
+	newData, err := json.MarshalIndent(postDatas, "", " ")
+	if err != nil {
+		return fmt.Errorf("error marshalling downloads: %w", err)
+	}
+	return os.WriteFile(jsonFilePath, newData, 0644)
+}
+
+// Returns every recorded download, or an empty list if nothing has been downloaded yet
+func LoadDownloads() ([]FormData, error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	postDatas := []FormData{}
+	existingData, err := os.ReadFile(jsonFilePath)
+	if os.IsNotExist(err) {
+		return postDatas, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading downloads file: %w", err)
+	}
+	if err := json.Unmarshal(existingData, &postDatas); err != nil {
+		return nil, fmt.Errorf("error unmarshalling downloads file: %w", err)
+	}
+	return postDatas, nil
+}
@@ -0,0 +1,169 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Topics events are published under. Subscribers filter on these
+const (
+	TopicDownload = "download"
+	TopicUpload   = "upload"
+	TopicPeer     = "peer"
+	TopicProxy    = "proxy"
+	TopicPayment  = "payment"
+	TopicNetwork  = "network"
+)
+
+const (
+	subscriberBuffer = 64
+	historySize      = 256 // recent events kept so reconnecting clients can catch up with Last-Event-ID
+	keepAliveEvery   = 20 * time.Second
+)
+
+// Event is a typed notification, e.g. Topic "download" with Type "progress"
+type Event struct {
+	ID    uint64      `json:"id"`
+	Topic string      `json:"topic"`
+	Type  string      `json:"type"`
+	Time  string      `json:"time"`
+	Data  interface{} `json:"data"`
+}
+
+type subscriber struct {
+	ch     chan Event
+	topics map[string]bool // empty means every topic
+}
+
+var (
+	mu          sync.Mutex
+	nextID      uint64
+	history     []Event
+	subscribers = make(map[*subscriber]struct{})
+)
+
+func (s *subscriber) wants(topic string) bool {
+	return len(s.topics) == 0 || s.topics[topic]
+}
+
+// Publish delivers an event to every interested subscriber. Slow subscribers miss events rather than block the publisher
+func Publish(topic string, eventType string, data interface{}) {
+	mu.Lock()
+	defer mu.Unlock()
+	nextID++
+	event := Event{ID: nextID, Topic: topic, Type: eventType, Time: time.Now().Format(time.RFC3339Nano), Data: data}
+	history = append(history, event)
+	if len(history) > historySize {
+		history = history[len(history)-historySize:]
+	}
+	for sub := range subscribers {
+		if !sub.wants(topic) {
+			continue
+		}
+		select {
+		case sub.ch <- event:
+		default:
+		}
+	}
+}
+
+// Subscribe returns a channel receiving events for the given topics (all topics if none) and a function that ends
+// the subscription. Events after lastID still in history are replayed first
+func Subscribe(topics []string, lastID uint64) (<-chan Event, func()) {
+	sub := &subscriber{ch: make(chan Event, subscriberBuffer+historySize), topics: map[string]bool{}}
+	for _, topic := range topics {
+		if topic = strings.TrimSpace(topic); topic != "" {
+			sub.topics[topic] = true
+		}
+	}
+
+	mu.Lock()
+	if lastID > 0 {
+		for _, event := range history {
+			if event.ID > lastID && sub.wants(event.Topic) {
+				sub.ch <- event
+			}
+		}
+	}
+	subscribers[sub] = struct{}{}
+	mu.Unlock()
+
+	var once sync.Once
+	return sub.ch, func() {
+		once.Do(func() {
+			mu.Lock()
+			delete(subscribers, sub)
+			mu.Unlock()
+		})
+	}
+}
+
+// StreamEvents serves events as Server-Sent Events. Query: topics=download,payment (comma separated, default all).
+// Reconnecting clients send Last-Event-ID to receive what they missed
+func StreamEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	var topics []string
+	if t := r.URL.Query().Get("topics"); t != "" {
+		topics = strings.Split(t, ",")
+	}
+	lastID, _ := strconv.ParseUint(r.Header.Get("Last-Event-ID"), 10, 64)
+
+	ch, unsubscribe := Subscribe(topics, lastID)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(keepAliveEvery)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case event := <-ch:
+			data, err := json.Marshal(event)
+			if err != nil {
+				fmt.Printf("Error marshalling event: %v\n", err)
+				continue
+			}
+			fmt.Fprintf(w, "id: %d\nevent: %s.%s\ndata: %s\n\n", event.ID, event.Topic, event.Type, data)
+			flusher.Flush()
+		}
+	}
+}
+
+// GetRecentEvents returns buffered events for clients that poll instead of streaming. Query: topics, after (event ID)
+func GetRecentEvents(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	after, _ := strconv.ParseUint(r.URL.Query().Get("after"), 10, 64)
+	topics := map[string]bool{}
+	if t := r.URL.Query().Get("topics"); t != "" {
+		for _, topic := range strings.Split(t, ",") {
+			topics[strings.TrimSpace(topic)] = true
+		}
+	}
+	mu.Lock()
+	recent := []Event{}
+	for _, event := range history {
+		if event.ID > after && (len(topics) == 0 || topics[event.Topic]) {
+			recent = append(recent, event)
+		}
+	}
+	mu.Unlock()
+	json.NewEncoder(w).Encode(recent)
+}
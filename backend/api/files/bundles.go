@@ -0,0 +1,172 @@
+package files
+
+import (
+	"Otternet/backend/api/bundle"
+	"Otternet/backend/api/handlers"
+	"Otternet/backend/global"
+	"Otternet/backend/global_wallet"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// UploadBundle publishes a directory or set of files as one item. The bundle hash is derived from its manifest and
+// the whole bundle is sold at Price. Body: {"name", "paths": [...], "price", "fileType"}
+func UploadBundle(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var req struct {
+		Name     string   `json:"name"`
+		Paths    []string `json:"paths"`
+		Price    float64  `json:"price"`
+		FileType string   `json:"fileType"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Paths) == 0 || req.Price < 0 {
+		http.Error(w, "Invalid JSON body; 'paths' is required", http.StatusBadRequest)
+		return
+	}
+	if global.DHTNode == nil {
+		http.Error(w, "DHT node is not running", http.StatusServiceUnavailable)
+		return
+	}
+	if req.Name == "" {
+		req.Name = filepath.Base(filepath.Clean(req.Paths[0]))
+	}
+	if req.FileType == "" {
+		req.FileType = "bundle"
+	}
+
+	manifest, err := bundle.Build(req.Name, req.Paths)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error building bundle: %v", err), http.StatusBadRequest)
+		return
+	}
+	if err := manifest.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	bundleHash, err := bundle.Save(manifest)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error saving bundle manifest: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	postData := FormData{
+		WalletID:   global_wallet.WalletAddr,
+		SrcID:      global.DHTNode.Host.ID().String(),
+		Price:      req.Price,
+		FileName:   manifest.Name,
+		FilePath:   filepath.Dir(manifest.Members[0].LocalPath),
+		FileSize:   manifest.TotalSize(),
+		FileType:   req.FileType,
+		Timestamp:  time.Now().Format(time.RFC3339),
+		FileHash:   bundleHash,
+		BundleMode: true,
+	}
+	err = PublishFile(postData)
+	if errors.Is(err, errNotProvided) {
+		http.Error(w, "Error storing bundle in DHT", http.StatusInternalServerError)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"fileHash": bundleHash,
+		"manifest": manifest.Public(),
+		"status":   "success",
+	})
+}
+
+// GetBundleManifest returns the manifest of a bundle this node publishes
+func GetBundleManifest(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	manifest, err := bundle.Load(mux.Vars(r)["fileHash"])
+	if err != nil {
+		http.Error(w, "Bundle not found", http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(manifest.Public())
+}
+
+// FetchBundleManifest asks a provider for a bundle's manifest so members can be chosen before downloading
+func FetchBundleManifest(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	vars := mux.Vars(r)
+	bundleHash := vars["fileHash"]
+	peerID, err := peer.Decode(vars["providerID"])
+	if err != nil {
+		http.Error(w, "Invalid provider ID", http.StatusBadRequest)
+		return
+	}
+	if global.DHTNode == nil {
+		http.Error(w, "DHT node is not running", http.StatusServiceUnavailable)
+		return
+	}
+
+	ctx := global.DHTNode.Ctx
+	peerInfo, err := global.DHTNode.DHT.FindPeer(ctx, peerID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error finding peer: %v", err), http.StatusBadGateway)
+		return
+	}
+	stream, err := global.DHTNode.Host.NewStream(ctx, peerInfo.ID, handlers.FileRequestProtocol)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error opening stream: %v", err), http.StatusBadGateway)
+		return
+	}
+	defer stream.Close()
+
+	if _, err := stream.Write([]byte(bundleHash + " " + handlers.BundleManifestRequest + "\n")); err != nil {
+		http.Error(w, "Error sending request", http.StatusBadGateway)
+		return
+	}
+	// like a file request, a busy or off-schedule provider answers with a ServeStatus instead of the metadata
+	decoder := json.NewDecoder(stream)
+	var reply json.RawMessage
+	if err := decoder.Decode(&reply); err != nil {
+		http.Error(w, "Provider did not return the bundle", http.StatusBadGateway)
+		return
+	}
+	var status handlers.ServeStatus
+	if json.Unmarshal(reply, &status) == nil && status.Busy {
+		w.Header().Set("Retry-After", strconv.Itoa(status.RetryAfter))
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]interface{}{"busy": true, "reason": status.Reason, "retryAfter": status.RetryAfter})
+		return
+	}
+	if status.Error != "" {
+		http.Error(w, fmt.Sprintf("Provider cannot serve the bundle: %s", status.Error), http.StatusBadGateway)
+		return
+	}
+	var metadata FormData
+	if err := json.Unmarshal(reply, &metadata); err != nil || !metadata.BundleMode || metadata.FileHash != bundleHash {
+		http.Error(w, "Provider did not return the bundle", http.StatusBadGateway)
+		return
+	}
+	var wallet WalletAddress
+	var manifest bundle.Manifest
+	if err := decoder.Decode(&wallet); err != nil || decoder.Decode(&manifest) != nil {
+		http.Error(w, "Provider did not return a manifest", http.StatusBadGateway)
+		return
+	}
+	if err := manifest.Validate(); err != nil {
+		http.Error(w, fmt.Sprintf("Provider returned an invalid manifest: %v", err), http.StatusBadGateway)
+		return
+	}
+	if manifest.Hash() != bundleHash {
+		http.Error(w, "Manifest does not match the bundle hash", http.StatusBadGateway)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"price":    metadata.Price,
+		"manifest": manifest,
+	})
+}
@@ -1,18 +1,20 @@
 package files
 
 import (
-	"Otternet/backend/api/download"
+	"Otternet/backend/api/bundle"
 	"Otternet/backend/api/handlers"
+	"Otternet/backend/api/identify"
+	"Otternet/backend/api/reputation"
 	"Otternet/backend/global"
 	"Otternet/backend/global_wallet"
 	"bufio"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"path/filepath"
-	"strings"
 	"sync"
 	"time"
 
@@ -31,6 +33,9 @@ type FormData struct {
 	Timestamp  string  `json:"timestamp"`
 	FileHash   string  `json:"fileHash"`
 	BundleMode bool    `json:"bundleMode"`
+
+	PaymentTxID   string `json:"paymentTxID,omitempty"`
+	PaymentStatus string `json:"paymentStatus,omitempty"` // "pending", "confirmed" or "failed"
 }
 
 type ProviderList struct {
@@ -72,6 +77,26 @@ func UploadFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	err = PublishFile(postData)
+	if errors.Is(err, errNotProvided) {
+		http.Error(w, "Error storing file in DHT", http.StatusInternalServerError)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	response := map[string]string{"message": "File uploaded successfully", "status": "success"}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+	fmt.Println("File Upload API Response Sent")
+}
+
+var errNotProvided = errors.New("file stored but could not be provided in the DHT")
+
+// PublishFile adds file metadata to files.json, or replaces the entry with the same hash, and provides new files in
+// the DHT. The metadata is kept even if providing fails
+func PublishFile(postData FormData) error {
 	mutex.Lock()
 	defer mutex.Unlock()
 
@@ -83,32 +108,29 @@ func UploadFile(w http.ResponseWriter, r *http.Request) {
 		emptyData := []FormData{}
 		firstData, err := json.MarshalIndent(emptyData, "", " ")
 		if err != nil {
-			http.Error(w, "Error marshalling empty file data", http.StatusInternalServerError)
-			return
+			return errors.New("Error marshalling empty file data")
 		}
 
 		err = os.WriteFile(jsonFilePath, firstData, 0644) // 0644 means read/write permissions for owner, read permissions for all others
 		if err != nil {
-			http.Error(w, "Error writing empty file data", http.StatusInternalServerError)
 			fmt.Println("Error writing empty file data")
-			return
+			return errors.New("Error writing empty file data")
 		}
 	}
 
 	existingData, err := os.ReadFile(jsonFilePath)
 	if err != nil {
-		http.Error(w, "Error reading existing file data", http.StatusInternalServerError)
-		return
+		return errors.New("Error reading existing file data")
 	}
 	err = json.Unmarshal(existingData, &postDatas)
 	if err != nil {
-		http.Error(w, "Error unmarshalling existing file data", http.StatusInternalServerError)
-		return
+		return errors.New("Error unmarshalling existing file data")
 	}
 	walletAddr := global_wallet.WalletAddr
 
 	// Check if file already exists. If it does, replace it with this new entry
 	found := false
+	var provideErr error
 	for i, data := range postDatas {
 		if data.FileHash == postData.FileHash && data.WalletID == walletAddr {
 			postDatas[i] = postData // Replace existing file metadata with new file metadata
@@ -118,10 +140,10 @@ func UploadFile(w http.ResponseWriter, r *http.Request) {
 	}
 	if !found {
 		// Append new file metadata to existing file metadata
-		result := insertFileinDHT(postData.FileHash)
-		if result == -1 {
-			http.Error(w, "Error storing file in DHT", http.StatusInternalServerError)
-			// return
+		if global.DHTNode == nil {
+			provideErr = errNotProvided
+		} else if result := insertFileinDHT(postData.FileHash); result == -1 {
+			provideErr = errNotProvided
 		}
 		fmt.Printf("File %s stored in DHT\n", postData.FileHash)
 		postDatas = append(postDatas, postData)
@@ -129,18 +151,13 @@ func UploadFile(w http.ResponseWriter, r *http.Request) {
 
 	newData, err := json.MarshalIndent(postDatas, "", " ")
 	if err != nil {
-		http.Error(w, "Error marshalling new file data", http.StatusInternalServerError)
-		return
+		return errors.New("Error marshalling new file data")
 	}
 	err = os.WriteFile(jsonFilePath, newData, 0644)
 	if err != nil {
-		http.Error(w, "Error writing new file data", http.StatusInternalServerError)
-		return
+		return errors.New("Error writing new file data")
 	}
-	response := map[string]string{"message": "File uploaded successfully", "status": "success"}
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(response)
-	fmt.Println("File Upload API Response Sent")
+	return provideErr
 }
 
 func DeleteFile(w http.ResponseWriter, r *http.Request) {
@@ -156,45 +173,73 @@ func DeleteFile(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid file hash", http.StatusBadRequest)
 		return
 	}
+	err := UnpublishFile(fileHash)
+	if errors.Is(err, errFileNotFound) {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	response := map[string]string{"message": "File deleted successfully", "status": "success"}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+	fmt.Println("File Delete API Response Sent")
+}
+
+var errFileNotFound = errors.New("file not found")
+
+// UnpublishFile removes a file from files.json so it is no longer served or reprovided. Provider records already in
+// the DHT expire on their own
+func UnpublishFile(fileHash string) error {
+	removed, err := removeFromCatalog(fileHash)
+	if err != nil {
+		return err
+	}
+	if removed.BundleMode {
+		if err := bundle.Delete(fileHash); err != nil {
+			fmt.Printf("Error deleting bundle manifest: %v\n", err)
+		}
+	}
+	return nil
+}
+
+// Removes the entry for fileHash from files.json and returns it
+func removeFromCatalog(fileHash string) (FormData, error) {
 	mutex.Lock()
 	defer mutex.Unlock()
 	existingData, err := os.ReadFile(jsonFilePath)
 	if err != nil {
-		http.Error(w, "Error reading existing file data", http.StatusInternalServerError)
-		return
+		return FormData{}, errors.New("Error reading existing file data")
 	}
 	var postDatas []FormData
 	err = json.Unmarshal(existingData, &postDatas)
 	if err != nil {
-		http.Error(w, "Error unmarshalling existing file data", http.StatusInternalServerError)
-		return
+		return FormData{}, errors.New("Error unmarshalling existing file data")
 	}
+	var removed FormData
 	found := false
 	for i, data := range postDatas {
 		if data.FileHash == fileHash {
+			removed = data
 			postDatas = append(postDatas[:i], postDatas[i+1:]...)
 			found = true
 			break
 		}
 	}
 	if !found {
-		http.Error(w, "File not found", http.StatusNotFound)
-		return
+		return FormData{}, errFileNotFound
 	}
 	newData, err := json.MarshalIndent(postDatas, "", " ")
 	if err != nil {
-		http.Error(w, "Error marshalling new file data", http.StatusInternalServerError)
-		return
+		return FormData{}, errors.New("Error marshalling new file data")
 	}
 	err = os.WriteFile(jsonFilePath, newData, 0644)
 	if err != nil {
-		http.Error(w, "Error writing new file data", http.StatusInternalServerError)
-		return
+		return FormData{}, errors.New("Error writing new file data")
 	}
-	response := map[string]string{"message": "File deleted successfully", "status": "success"}
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(response)
-	fmt.Println("File Delete API Response Sent")
+	return removed, nil
 }
 
 func GetAllFiles(w http.ResponseWriter, r *http.Request) {
@@ -281,17 +326,6 @@ func insertFileinDHT(fileHash string) int {
 	return 0
 }
 
-// can be used to set provider or reset expiration timer for provider
-func setProvider(fileHash string) int {
-	err := global.DHTNode.ProvideKey(fileHash)
-	if err != nil {
-		fmt.Printf("Failed to provide key: %v\n", err)
-		return -1
-	}
-	fmt.Printf("File %s stored successfully\n", fileHash)
-	return 0
-}
-
 func GetProviders(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "GET" {
 		http.Error(w, "Invalid request method. Use GET.", http.StatusMethodNotAllowed)
@@ -321,6 +355,14 @@ func GetProviders(w http.ResponseWriter, r *http.Request) {
 	fmt.Println("Get Providers API Response Sent")
 }
 
+// ProviderPrice is one provider's quote for a file, with its reputation
+type ProviderPrice struct {
+	ProviderID string  `json:"providerID"`
+	Price      float64 `json:"price"`
+	Score      float64 `json:"score"`
+	LatencyMs  float64 `json:"latencyMs"`
+}
+
 // Call FindProviders from dht.go to get list of providers, iterate through list of providers and send a ping to each provider
 // Receive price from each provider and store in a map, return map of providers with prices
 func GetFilePrices(w http.ResponseWriter, r *http.Request) {
@@ -330,20 +372,60 @@ func GetFilePrices(w http.ResponseWriter, r *http.Request) {
 	}
 	w.Header().Set("Content-Type", "application/json")
 
+	prices, ok := requestFilePrices(w, r)
+	if !ok {
+		return
+	}
+
+	// send map of providers with prices back
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(prices)
+}
+
+// GetRankedFilePrices asks every provider of a file for its price like GetFilePrices, but returns the quotes as a list
+// ordered best reputation first, with each provider's score and latency
+func GetRankedFilePrices(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Invalid request method. Use GET.", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+
+	prices, ok := requestFilePrices(w, r)
+	if !ok {
+		return
+	}
+
+	quoted := make([]string, 0, len(prices))
+	for providerID := range prices {
+		quoted = append(quoted, providerID)
+	}
+	ranked := make([]ProviderPrice, 0, len(quoted))
+	for _, providerID := range reputation.Rank(quoted) {
+		rep := reputation.Lookup(providerID)
+		ranked = append(ranked, ProviderPrice{ProviderID: providerID, Price: prices[providerID], Score: rep.Score, LatencyMs: rep.LatencyMs})
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(ranked)
+}
+
+// Finds the providers of the requested file hash and asks each for its price, recording the round trip as the
+// provider's latency. Writes an error response and returns false when the request cannot be answered
+func requestFilePrices(w http.ResponseWriter, r *http.Request) (map[string]float64, bool) {
 	vars := mux.Vars(r)
 
 	// get file hash from request
 	fileHash, exists := vars["fileHash"]
 	if !exists || fileHash == "" {
 		http.Error(w, "No file hash provided", http.StatusBadRequest)
-		return
+		return nil, false
 	}
 	fmt.Printf("Searching Providers of File Hash: %s\n", fileHash)
 
 	providers, err := global.DHTNode.FindProviders(fileHash)
 	if err != nil {
 		http.Error(w, "Error finding providers", http.StatusInternalServerError)
-		return
+		return nil, false
 	}
 
 	var providerIDs []string
@@ -355,6 +437,7 @@ func GetFilePrices(w http.ResponseWriter, r *http.Request) {
 	prices := make(map[string]float64)
 	for _, provider := range providers {
 		// open stream to provider using priceRequest protocol
+		requested := time.Now()
 		stream, err := global.DHTNode.Host.NewStream(global.DHTNode.Ctx, provider.ID, handlers.PriceRequestProtocol)
 		if err != nil {
 			fmt.Printf("Error opening stream: %v\n", err)
@@ -379,6 +462,7 @@ func GetFilePrices(w http.ResponseWriter, r *http.Request) {
 			fmt.Printf("Error decoding price: %v\n", err)
 			continue
 		}
+		reputation.RecordLatency(provider.ID.String(), time.Since(requested))
 
 		fmt.Printf("File priced at %f OTTC from provider %s\n", price, provider.ID.String())
 
@@ -386,10 +470,7 @@ func GetFilePrices(w http.ResponseWriter, r *http.Request) {
 
 		prices[provider.ID.String()] = price
 	}
-
-	// send map of providers with prices back
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(prices)
+	return prices, true
 }
 
 func AppendProviderIDs(providerIDs []string) error {
@@ -530,7 +611,9 @@ func PutPeersInCache(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
-// Handles downloading file metadata and file
+// Handles downloading file metadata and file. The download goes through the queue like any other and the request
+// blocks until it finishes; use StartDownloadJob to get a job ID back immediately. If the job is paused or has to wait
+// for a busy provider, 202 is returned with the job ID to follow; if the client goes away, the job is cancelled
 func DownloadFile(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
 		http.Error(w, "Invalid request method. Use POST.", http.StatusMethodNotAllowed)
@@ -538,126 +621,42 @@ func DownloadFile(w http.ResponseWriter, r *http.Request) {
 	}
 	w.Header().Set("Content-Type", "application/json")
 
-	// get details from request
-	body, err := io.ReadAll(r.Body)
+	req, err := decodeDownloadRequest(r)
 	if err != nil {
-		http.Error(w, "Error reading request body", http.StatusBadRequest)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	defer r.Body.Close()
-	var postData = struct {
-		WalletID     string `json:"walletID"`
-		ProviderID   string `json:"providerID"`
-		DownloadPath string `json:"downloadPath"`
-		FileHash     string `json:"fileHash"`
-	}{}
-	err = json.Unmarshal(body, &postData)
-	if err != nil {
-		http.Error(w, "Error unmarshalling request body", http.StatusBadRequest)
+	if global.DHTNode == nil {
+		http.Error(w, "DHT node is not running", http.StatusServiceUnavailable)
 		return
 	}
 
-	walletID := postData.WalletID
-	providerID := postData.ProviderID
-	downloadPath := postData.DownloadPath
-	fileHash := postData.FileHash
-
-	// Obtain peer info from DHT using providerID
-	peerID, err := peer.Decode(providerID)
-	if err != nil {
-		http.Error(w, "Invalid provider ID", http.StatusBadRequest)
+	job := EnqueueDownload(req)
+	select {
+	case <-job.done:
+	case <-job.interrupted:
+		result := job.snapshot()
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]string{"jobID": result.ID, "status": result.Status, "message": result.Error})
 		return
-	}
-
-	peerInfo, err := global.DHTNode.DHT.FindPeer(global.DHTNode.Ctx, peerID)
-	if err != nil {
-		fmt.Printf("Error finding peer: %v\n", err)
-		return
-	}
-
-	// Open connection to peer using fileRequest protocol - consider using connect function from dht.go
-	stream, err := global.DHTNode.Host.NewStream(global.DHTNode.Ctx, peerInfo.ID, handlers.FileRequestProtocol)
-	if err != nil {
-		fmt.Printf("Error opening stream: %v\n", err)
+	case <-r.Context().Done():
+		jobsMutex.Lock()
+		job.cancelLocked()
+		jobsMutex.Unlock()
 		return
 	}
-	defer stream.Close()
-
-	fmt.Printf("Connected to provider %s\n", providerID)
-
-	// Send file hash to peer
-	_, err = stream.Write([]byte(fileHash + "\n"))
-	if err != nil {
-		fmt.Printf("Error sending file hash: %v\n", err)
-		return
-	}
-
-	fmt.Println("Sending the File Hash to Provider")
-
-	// Receive file metadata from peer
-	decoder := json.NewDecoder(stream)
-	var metadata FormData
-	err = decoder.Decode(&metadata)
-	if err != nil {
-		fmt.Printf("Error decoding metadata: %v\n", err)
-		return
-	}
-
-	fmt.Printf("Received metadata: %v\n", metadata)
-
-	// get destination wallet address
-	var wallet WalletAddress
-	err = decoder.Decode(&wallet)
-	if err != nil {
-		fmt.Printf("Error decoding wallet address: %v\n", err)
-		return
-	}
-	fmt.Printf("Received wallet address: %v\n", wallet)
-
-	// Download file from peer
-
-	fmt.Println("Creating the File in Download Location")
-	fmt.Println("Download Path: ", downloadPath)
-	fmt.Println("File Name: ", metadata.FileName)
-
-	file, err := os.Create(downloadPath + "/" + metadata.FileName)
-	if err != nil {
-		fmt.Printf("Error creating file: %v\n", err)
-		return
-	}
-
-	fmt.Println("Downloading in Progress")
-
-	_, err = io.Copy(file, stream)
-	if err != nil {
-		http.Error(w, "Error downloading file", http.StatusInternalServerError)
-		return
-	}
-
-	fmt.Println("File Downloaded Successfully")
-
-	// Save file metadata to local file.json
-	downloadedFile := FormData{
-		WalletID:   walletID,
-		SrcID:      providerID,
-		Price:      metadata.Price,
-		FileName:   metadata.FileName,
-		FilePath:   downloadPath,
-		FileSize:   metadata.FileSize,
-		FileType:   metadata.FileType,
-		Timestamp:  time.Now().Format(time.RFC3339),
-		FileHash:   fileHash,
-		BundleMode: metadata.BundleMode,
-	}
-
-	res := download.StoreFile(download.FormData(downloadedFile)) // ???!!!
-	if res != 0 {
-		http.Error(w, "Error storing file in downloads.json", http.StatusInternalServerError)
+	result := job.snapshot()
+	if result.Status != JobCompleted {
+		fmt.Printf("Error downloading file: %s\n", result.Error)
+		status := http.StatusInternalServerError
+		if errors.Is(job.err, errPayeeMismatch) {
+			status = http.StatusConflict
+		}
+		http.Error(w, result.Error, status)
 		return
 	}
-	file.Close()
 
-	response := map[string]string{"message": "File downloaded successfully", "status": "success", "walletAddress": wallet.WalletID}
+	response := map[string]string{"message": "File downloaded successfully", "status": "success", "walletAddress": result.WalletAddress, "jobID": result.ID}
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(response)
 }
@@ -712,50 +711,80 @@ func GetCatalog(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(catalog)
 }
 
+// Peers worth asking for an Otternet description: cached providers and everyone currently connected
+func otternetCandidates() ([]peer.ID, error) {
+	providerIDs, _, err := readProviders()
+	if err != nil {
+		return nil, err
+	}
+	seen := map[peer.ID]bool{global.DHTNode.Host.ID(): true}
+	var candidates []peer.ID
+	for _, providerID := range providerIDs.List {
+		id, err := peer.Decode(providerID)
+		if err != nil {
+			fmt.Printf("Error decoding peer ID: %v\n", err)
+			continue
+		}
+		if !seen[id] {
+			seen[id] = true
+			candidates = append(candidates, id)
+		}
+	}
+	for _, id := range global.DHTNode.Host.Network().Peers() {
+		if !seen[id] {
+			seen[id] = true
+			candidates = append(candidates, id)
+		}
+	}
+	return candidates, nil
+}
+
+// GetOtternetPeers lists the IDs of peers on this network that describe themselves as file providers
 func GetOtternetPeers(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "GET" {
 		http.Error(w, "Invalid request method. Use GET.", http.StatusMethodNotAllowed)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
-	providerIDs, _, err := readProviders()
+	if global.DHTNode == nil {
+		http.Error(w, "DHT node is not running", http.StatusServiceUnavailable)
+		return
+	}
+	candidates, err := otternetCandidates()
 	if err != nil {
 		http.Error(w, "Error reading providers file", http.StatusInternalServerError)
 		return
 	}
 	returnIDs := make([]string, 0)
-	for _, peerID := range providerIDs.List {
-		peerID_, err := peer.Decode(peerID)
-		if err != nil {
-			fmt.Printf("Error decoding peer ID: %v\n", err)
-			continue
-		}
-		stream, err := global.DHTNode.Host.NewStream(global.DHTNode.Ctx, peerID_, handlers.OtternetPeersProtocol)
-		if err != nil {
-			fmt.Printf("Error opening stream: %v\n", err)
-			continue
-		}
-		defer stream.Close()
-		_, err = stream.Write([]byte("otternet1\n"))
-		if err != nil {
-			fmt.Printf("Error sending secret message: %v\n", err)
-			continue
-		}
-		r := bufio.NewReader(stream)
-		secretMessage, err := r.ReadString('\n')
-		if err != nil {
-			fmt.Printf("Error reading from stream: %v\n", err)
-			continue
-		}
-		secretMessage = strings.ToLower(strings.TrimSpace(secretMessage))
-		if secretMessage == "otternet2" {
-			fmt.Printf("Peer %s is an otternet peer\n", peerID)
-			returnIDs = append(returnIDs, peerID)
+	for _, desc := range identify.DescribeAll(r.Context(), candidates) {
+		if desc.HasService(identify.ServiceFileProvider) {
+			returnIDs = append(returnIDs, desc.PeerID)
 		}
 	}
-	response := returnIDs
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(response)
+	json.NewEncoder(w).Encode(returnIDs)
+}
+
+// GetOtternetPeerDetails returns the verified description of every reachable Otternet peer. Query: service (optional)
+func GetOtternetPeerDetails(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if global.DHTNode == nil {
+		http.Error(w, "DHT node is not running", http.StatusServiceUnavailable)
+		return
+	}
+	candidates, err := otternetCandidates()
+	if err != nil {
+		http.Error(w, "Error reading providers file", http.StatusInternalServerError)
+		return
+	}
+	service := r.URL.Query().Get("service")
+	descriptions := make([]*identify.Description, 0)
+	for _, desc := range identify.DescribeAll(r.Context(), candidates) {
+		if service == "" || desc.HasService(service) {
+			descriptions = append(descriptions, desc)
+		}
+	}
+	json.NewEncoder(w).Encode(descriptions)
 }
 
 // Gets the goods from peerstore
@@ -766,11 +795,19 @@ func GetPeers(w http.ResponseWriter, r *http.Request) {
 	}
 	w.Header().Set("Content-Type", "application/json")
 	peers := global.DHTNode.Host.Peerstore().Peers()
+	// peers found on the local network are listed on their own
+	lanPeers := global.DHTNode.LANPeers()
+	onLAN := make(map[string]bool, len(lanPeers))
+	for _, lanPeer := range lanPeers {
+		onLAN[lanPeer.ID] = true
+	}
 	var peerIDs []string
 	for _, peer := range peers {
-		peerIDs = append(peerIDs, peer.String())
+		if !onLAN[peer.String()] {
+			peerIDs = append(peerIDs, peer.String())
+		}
 	}
-	response := map[string][]string{"peers": peerIDs}
+	response := map[string]interface{}{"peers": peerIDs, "lanPeers": lanPeers}
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(response)
 }
@@ -796,4 +833,51 @@ func GetClosestPeers(w http.ResponseWriter, r *http.Request) {
 	response := map[string][]string{"peers": peerIDs}
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(response)
-}
\ No newline at end of file
+}
+
+// LoadFiles returns every file this node publishes
+func LoadFiles() ([]FormData, error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	fileData, err := os.ReadFile(jsonFilePath)
+	if os.IsNotExist(err) {
+		return []FormData{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading file data: %w", err)
+	}
+	var postDatas []FormData
+	if err := json.Unmarshal(fileData, &postDatas); err != nil {
+		return nil, fmt.Errorf("error unmarshalling file data: %w", err)
+	}
+	return postDatas, nil
+}
+
+// Replaces the catalog entry with the same hash, e.g. after its file was found at a new path
+func replaceCatalogEntry(entry FormData) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	existingData, err := os.ReadFile(jsonFilePath)
+	if err != nil {
+		return fmt.Errorf("error reading file data: %w", err)
+	}
+	var postDatas []FormData
+	if err := json.Unmarshal(existingData, &postDatas); err != nil {
+		return fmt.Errorf("error unmarshalling file data: %w", err)
+	}
+	found := false
+	for i, data := range postDatas {
+		if data.FileHash == entry.FileHash {
+			postDatas[i] = entry
+			found = true
+		}
+	}
+	if !found {
+		return errFileNotFound
+	}
+	newData, err := json.MarshalIndent(postDatas, "", " ")
+	if err != nil {
+		return fmt.Errorf("error marshalling file data: %w", err)
+	}
+	return os.WriteFile(jsonFilePath, newData, 0644)
+}
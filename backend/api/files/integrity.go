@@ -0,0 +1,374 @@
+package files
+
+import (
+	"Otternet/backend/api/bundle"
+	"Otternet/backend/api/events"
+	"Otternet/backend/config"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const integrityFilePath = "./api/files/integrity.json"
+
+const (
+	ProblemMissing  = "missing"
+	ProblemModified = "modified"
+)
+
+// IntegrityIssue is a published file that failed its check. The entry is taken out of files.json, so it is neither
+// served, listed in the catalog nor reprovided, until the file is back or the issue is dismissed
+type IntegrityIssue struct {
+	Entry      FormData `json:"entry"`
+	Problem    string   `json:"problem"` // ProblemMissing or ProblemModified
+	Path       string   `json:"path"`    // the file that failed; a member's path for bundles
+	DetectedAt string   `json:"detectedAt"`
+	LastCheck  string   `json:"lastCheck"`
+}
+
+// IntegrityRepair records a file found again by hash at a new path
+type IntegrityRepair struct {
+	FileHash   string `json:"fileHash"`
+	From       string `json:"from"`
+	To         string `json:"to"`
+	RepairedAt string `json:"repairedAt"`
+}
+
+// verifiedFile is the stat of a path when its hash last matched, so unchanged files are not re-hashed every scan
+type verifiedFile struct {
+	FileHash string `json:"fileHash"`
+	Size     int64  `json:"size"`
+	ModTime  int64  `json:"modTime"` // unix nanoseconds
+}
+
+type integrityState struct {
+	LastScan string                     `json:"lastScan,omitempty"`
+	Issues   map[string]*IntegrityIssue `json:"issues"` // file hash -> issue
+	Repairs  []IntegrityRepair          `json:"repairs"`
+	Verified map[string]verifiedFile    `json:"verified"` // path -> last good stat
+}
+
+// Repairs kept in the log
+const maxRepairs = 100
+
+var (
+	integrityMutex = &sync.Mutex{}
+	integrityWake  = make(chan struct{}, 1)
+)
+
+func readIntegrity() (integrityState, error) {
+	state := integrityState{}
+	data, err := os.ReadFile(integrityFilePath)
+	if err == nil {
+		err = json.Unmarshal(data, &state)
+	} else if os.IsNotExist(err) {
+		err = nil
+	}
+	if state.Issues == nil {
+		state.Issues = make(map[string]*IntegrityIssue)
+	}
+	if state.Verified == nil {
+		state.Verified = make(map[string]verifiedFile)
+	}
+	return state, err
+}
+
+func writeIntegrity(state integrityState) error {
+	data, err := json.MarshalIndent(state, "", " ")
+	if err != nil {
+		return fmt.Errorf("error marshalling integrity state: %w", err)
+	}
+	return os.WriteFile(integrityFilePath, data, 0644)
+}
+
+// Checks that filePath still holds content with the given hash and size. Returns "" if it does, otherwise the problem
+func (state *integrityState) checkPath(filePath string, hash string, size int64) string {
+	info, err := os.Stat(filePath)
+	if err != nil || !info.Mode().IsRegular() {
+		delete(state.Verified, filePath)
+		return ProblemMissing
+	}
+	if size > 0 && info.Size() != size {
+		delete(state.Verified, filePath)
+		return ProblemModified
+	}
+	seen := verifiedFile{FileHash: hash, Size: info.Size(), ModTime: info.ModTime().UnixNano()}
+	if state.Verified[filePath] == seen {
+		return ""
+	}
+	actual, _, err := bundle.HashFile(filePath)
+	if err != nil {
+		delete(state.Verified, filePath)
+		return ProblemMissing
+	}
+	if actual != hash {
+		delete(state.Verified, filePath)
+		return ProblemModified
+	}
+	state.Verified[filePath] = seen
+	return ""
+}
+
+// relocator finds files by content in the configured search folders. The folders are walked at most once per scan
+type relocator struct {
+	folders []string
+	bySize  map[int64][]string
+	hashes  map[string]string // path -> hash, filled in as candidates are hashed
+}
+
+func newRelocator(cfg *config.Config) *relocator {
+	if !cfg.RelocateMissingFiles {
+		return nil
+	}
+	folders := append([]string{}, cfg.RelocateSearchFolders...)
+	if shared, err := readShared(); err == nil {
+		folders = append(folders, shared.Folders...)
+	}
+	return &relocator{folders: folders}
+}
+
+func (r *relocator) find(hash string, size int64) (string, bool) {
+	if r == nil {
+		return "", false
+	}
+	if r.bySize == nil {
+		r.bySize = make(map[int64][]string)
+		r.hashes = make(map[string]string)
+		for _, folder := range r.folders {
+			filepath.WalkDir(folder, func(filePath string, d fs.DirEntry, err error) error {
+				if err != nil || !d.Type().IsRegular() {
+					return nil
+				}
+				if info, err := d.Info(); err == nil {
+					r.bySize[info.Size()] = append(r.bySize[info.Size()], filePath)
+				}
+				return nil
+			})
+		}
+	}
+	for _, candidate := range r.bySize[size] {
+		candidateHash, ok := r.hashes[candidate]
+		if !ok {
+			candidateHash, _, _ = bundle.HashFile(candidate)
+			r.hashes[candidate] = candidateHash
+		}
+		if candidateHash == hash {
+			return candidate, true
+		}
+	}
+	return "", false
+}
+
+// Checks a catalog entry, relocating missing or modified files where possible. Returns the possibly updated entry, the
+// failing path and the problem, or "" if the entry is healthy
+func (state *integrityState) checkEntry(entry FormData, finder *relocator) (FormData, string, string) {
+	now := time.Now().Format(time.RFC3339)
+	if !entry.BundleMode {
+		problem := state.checkPath(entry.FilePath, entry.FileHash, entry.FileSize)
+		if problem == "" {
+			return entry, "", ""
+		}
+		if found, ok := finder.find(entry.FileHash, entry.FileSize); ok {
+			state.logRepair(IntegrityRepair{FileHash: entry.FileHash, From: entry.FilePath, To: found, RepairedAt: now})
+			entry.FilePath = found
+			return entry, "", ""
+		}
+		return entry, entry.FilePath, problem
+	}
+
+	manifest, err := bundle.Load(entry.FileHash)
+	if err != nil {
+		return entry, entry.FilePath, ProblemMissing
+	}
+	changed := false
+	for i, member := range manifest.Members {
+		problem := state.checkPath(member.LocalPath, member.Hash, member.Size)
+		if problem == "" {
+			continue
+		}
+		found, ok := finder.find(member.Hash, member.Size)
+		if !ok {
+			return entry, member.LocalPath, problem
+		}
+		state.logRepair(IntegrityRepair{FileHash: entry.FileHash, From: member.LocalPath, To: found, RepairedAt: now})
+		manifest.Members[i].LocalPath = found
+		changed = true
+	}
+	if changed {
+		if _, err := bundle.Save(manifest); err != nil {
+			fmt.Printf("Integrity scan: error saving relocated bundle %s: %v\n", entry.FileHash, err)
+		}
+	}
+	return entry, "", ""
+}
+
+func (state *integrityState) logRepair(repair IntegrityRepair) {
+	fmt.Printf("Integrity scan: found %s at %s\n", repair.FileHash, repair.To)
+	state.Repairs = append(state.Repairs, repair)
+	if len(state.Repairs) > maxRepairs {
+		state.Repairs = state.Repairs[len(state.Repairs)-maxRepairs:]
+	}
+}
+
+// ScanIntegrity checks every published file. Failing entries are taken out of the catalog and recorded as issues;
+// earlier issues whose files are back, or were found elsewhere, are published again
+func ScanIntegrity() error {
+	integrityMutex.Lock()
+	defer integrityMutex.Unlock()
+	state, err := readIntegrity()
+	if err != nil {
+		return fmt.Errorf("error reading integrity state: %w", err)
+	}
+	entries, err := LoadFiles()
+	if err != nil {
+		return err
+	}
+	finder := newRelocator(config.NewConfig())
+	now := time.Now().Format(time.RFC3339)
+
+	for _, entry := range entries {
+		checked, failedPath, problem := state.checkEntry(entry, finder)
+		if problem == "" {
+			if checked.FilePath != entry.FilePath {
+				if err := replaceCatalogEntry(checked); err != nil {
+					fmt.Printf("Integrity scan: error updating %s: %v\n", entry.FileHash, err)
+				}
+			}
+			continue
+		}
+		if _, err := removeFromCatalog(entry.FileHash); err != nil && !errors.Is(err, errFileNotFound) {
+			fmt.Printf("Integrity scan: error withdrawing %s: %v\n", entry.FileHash, err)
+			continue
+		}
+		state.Issues[entry.FileHash] = &IntegrityIssue{Entry: entry, Problem: problem, Path: failedPath, DetectedAt: now, LastCheck: now}
+		fmt.Printf("Integrity scan: %s is %s (%s); no longer advertised\n", entry.FileHash, problem, failedPath)
+		events.Publish(events.TopicUpload, "unavailable", map[string]string{"fileHash": entry.FileHash, "problem": problem, "path": failedPath})
+	}
+
+	for hash, issue := range state.Issues {
+		if issue.LastCheck == now && issue.DetectedAt == now {
+			continue
+		}
+		checked, failedPath, problem := state.checkEntry(issue.Entry, finder)
+		issue.LastCheck = now
+		if problem != "" {
+			issue.Problem, issue.Path = problem, failedPath
+			continue
+		}
+		if err := PublishFile(checked); err != nil && !errors.Is(err, errNotProvided) {
+			fmt.Printf("Integrity scan: error restoring %s: %v\n", hash, err)
+			continue
+		}
+		delete(state.Issues, hash)
+		fmt.Printf("Integrity scan: %s is available again\n", hash)
+		events.Publish(events.TopicUpload, "restored", map[string]string{"fileHash": hash})
+	}
+
+	// forget paths that are no longer published
+	known := make(map[string]bool)
+	for _, entry := range entries {
+		known[entry.FilePath] = true
+		if entry.BundleMode {
+			if manifest, err := bundle.Load(entry.FileHash); err == nil {
+				for _, member := range manifest.Members {
+					known[member.LocalPath] = true
+				}
+			}
+		}
+	}
+	for filePath := range state.Verified {
+		if !known[filePath] {
+			delete(state.Verified, filePath)
+		}
+	}
+
+	state.LastScan = now
+	return writeIntegrity(state)
+}
+
+// RunIntegrityScans checks published files at startup and then every IntegrityScanInterval
+func RunIntegrityScans(ctx context.Context) {
+	ticker := time.NewTicker(config.NewConfig().IntegrityScanInterval)
+	defer ticker.Stop()
+	for {
+		if err := ScanIntegrity(); err != nil {
+			fmt.Printf("Integrity scan: %v\n", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-integrityWake:
+		}
+	}
+}
+
+// GetIntegrityReport lists withdrawn files and recent relocations
+func GetIntegrityReport(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	integrityMutex.Lock()
+	state, err := readIntegrity()
+	integrityMutex.Unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	issues := []*IntegrityIssue{}
+	for _, issue := range state.Issues {
+		issues = append(issues, issue)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"lastScan": state.LastScan,
+		"issues":   issues,
+		"repairs":  state.Repairs,
+	})
+}
+
+// StartIntegrityScan runs a scan now instead of waiting for the next interval
+func StartIntegrityScan(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	select {
+	case integrityWake <- struct{}{}:
+	default:
+	}
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{"status": "scan scheduled"})
+}
+
+// DismissIntegrityIssue forgets a withdrawn file for good instead of waiting for it to come back
+func DismissIntegrityIssue(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	fileHash := mux.Vars(r)["fileHash"]
+	integrityMutex.Lock()
+	defer integrityMutex.Unlock()
+	state, err := readIntegrity()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	issue, ok := state.Issues[fileHash]
+	if !ok {
+		http.Error(w, "No integrity issue for this file", http.StatusNotFound)
+		return
+	}
+	if issue.Entry.BundleMode {
+		if err := bundle.Delete(fileHash); err != nil {
+			fmt.Printf("Error deleting bundle manifest: %v\n", err)
+		}
+	}
+	delete(state.Issues, fileHash)
+	if err := writeIntegrity(state); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"message": "Issue dismissed", "status": "success"})
+}
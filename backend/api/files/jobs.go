@@ -0,0 +1,698 @@
+package files
+
+import (
+	"Otternet/backend/api/addressbook"
+	"Otternet/backend/api/bandwidth"
+	"Otternet/backend/api/bundle"
+	"Otternet/backend/api/download"
+	"Otternet/backend/api/handlers"
+	"Otternet/backend/api/reputation"
+	"Otternet/backend/global"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobPaused    = "paused"
+	JobCompleted = "completed"
+	JobFailed    = "failed"
+	JobCancelled = "cancelled"
+)
+
+// Finished jobs kept for status queries before the oldest are dropped
+const maxFinishedJobs = 100
+
+// A job turned away by a busy provider is retried this many times before it fails
+const maxBusyRetries = 5
+
+var errPayeeMismatch = errors.New("provider wallet address does not match its attestation")
+
+var errHashMismatch = errors.New("received content does not match the requested hash")
+
+type providerBusyError struct {
+	reason     string
+	retryAfter time.Duration
+}
+
+func (e *providerBusyError) Error() string {
+	return fmt.Sprintf("provider busy (%s), retry after %s", e.reason, e.retryAfter)
+}
+
+// DownloadJob is a download managed by the queue. Status is one of the Job* constants
+type DownloadJob struct {
+	ID            string   `json:"id"`
+	FileHash      string   `json:"fileHash"`
+	FileName      string   `json:"fileName,omitempty"`
+	Member        string   `json:"member,omitempty"`
+	ProviderID    string   `json:"providerID"` // provider the file is being, or was, fetched from
+	Providers     []string `json:"providers,omitempty"`
+	DownloadPath  string   `json:"downloadPath"`
+	Priority      int      `json:"priority"` // higher runs first
+	Status        string   `json:"status"`
+	BytesReceived int64    `json:"bytesReceived"`
+	FileSize      int64    `json:"fileSize"`
+	Rate          float64  `json:"rate"`       // bytes per second
+	ETASeconds    float64  `json:"etaSeconds"` // 0 when unknown or finished
+	WalletAddress string   `json:"walletAddress,omitempty"`
+	Error         string   `json:"error,omitempty"`
+	AddedAt       string   `json:"addedAt"`
+	StartedAt     string   `json:"startedAt,omitempty"`
+	FinishedAt    string   `json:"finishedAt,omitempty"`
+
+	walletID          string
+	requestedProvider string // set when the request named a provider, so no other is tried
+	started           time.Time
+	cancel            context.CancelFunc
+	nodeCtx           context.Context // context of the DHT node the running transfer uses; done once the node stops
+	pausing           bool            // the running transfer is being stopped to pause, not cancel
+	cancelling        bool            // the running transfer is being stopped because the job was cancelled
+	err               error           // why the job failed
+	retries           int             // times the provider has answered busy
+	notBefore         time.Time       // a queued job is not started before this, e.g. when a provider asked us to wait
+	done              chan struct{}   // closed once the job is completed, failed or cancelled
+	interrupted       chan struct{}   // signalled when the job is paused or requeued to retry, so /download stops waiting
+}
+
+// DownloadRequest is the body accepted by /download and /downloads
+type DownloadRequest struct {
+	WalletID     string   `json:"walletID"`
+	ProviderID   string   `json:"providerID,omitempty"` // download from this provider only
+	Providers    []string `json:"providers,omitempty"`  // candidates when no providerID is given; empty asks the DHT
+	DownloadPath string   `json:"downloadPath"`
+	FileHash     string   `json:"fileHash"`
+	Member       string   `json:"member,omitempty"` // manifest path of a single bundle member to download instead of the whole bundle
+	Priority     int      `json:"priority"`
+}
+
+var (
+	jobsMutex = &sync.Mutex{}
+	jobs      = make(map[string]*DownloadJob)
+	jobOrder  []string // job IDs, oldest first
+)
+
+func newJobID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (job *DownloadJob) request() DownloadRequest {
+	return DownloadRequest{
+		WalletID:     job.walletID,
+		ProviderID:   job.requestedProvider,
+		Providers:    job.Providers,
+		DownloadPath: job.DownloadPath,
+		FileHash:     job.FileHash,
+		Member:       job.Member,
+		Priority:     job.Priority,
+	}
+}
+
+// Reports whether the job is still queued, paused or running. Caller holds jobsMutex
+func (job *DownloadJob) active() bool {
+	return job.Status == JobQueued || job.Status == JobRunning || job.Status == JobPaused
+}
+
+// Registers a queued job for req. Caller holds jobsMutex
+func addJobLocked(id string, req DownloadRequest, status string, addedAt string) *DownloadJob {
+	job := &DownloadJob{
+		ID:                id,
+		FileHash:          req.FileHash,
+		Member:            req.Member,
+		ProviderID:        req.ProviderID,
+		Providers:         req.Providers,
+		DownloadPath:      req.DownloadPath,
+		Priority:          req.Priority,
+		Status:            status,
+		AddedAt:           addedAt,
+		walletID:          req.WalletID,
+		requestedProvider: req.ProviderID,
+		done:              make(chan struct{}),
+		interrupted:       make(chan struct{}, 1),
+	}
+	jobs[job.ID] = job
+	jobOrder = append(jobOrder, job.ID)
+	pruneJobs()
+	return job
+}
+
+// Drops the oldest finished jobs beyond maxFinishedJobs. Caller holds jobsMutex
+func pruneJobs() {
+	finished := 0
+	for _, id := range jobOrder {
+		if !jobs[id].active() {
+			finished++
+		}
+	}
+	kept := jobOrder[:0]
+	for _, id := range jobOrder {
+		if finished > maxFinishedJobs && !jobs[id].active() {
+			delete(jobs, id)
+			finished--
+			continue
+		}
+		kept = append(kept, id)
+	}
+	jobOrder = kept
+}
+
+// Returns a copy of the job that is safe to encode
+func (job *DownloadJob) snapshot() DownloadJob {
+	jobsMutex.Lock()
+	defer jobsMutex.Unlock()
+	return *job
+}
+
+// Tells a request waiting for the job that it will not finish soon. Caller holds jobsMutex
+func (job *DownloadJob) interruptLocked() {
+	select {
+	case job.interrupted <- struct{}{}:
+	default:
+	}
+}
+
+// Stops a queued, paused or running job and reports whether it was still unfinished. Caller holds jobsMutex
+func (job *DownloadJob) cancelLocked() bool {
+	switch job.Status {
+	case JobRunning:
+		// run() records the cancellation once the transfer has stopped
+		job.pausing = false
+		job.cancelling = true
+		job.cancel()
+	case JobQueued, JobPaused:
+		job.finishLocked(context.Canceled)
+	default:
+		return false
+	}
+	return true
+}
+
+// Marks a queued job as running and returns the context that stops it. Caller holds jobsMutex
+func (job *DownloadJob) beginLocked() context.Context {
+	ctx, cancel := context.WithCancel(global.DHTNode.Ctx)
+	job.nodeCtx = global.DHTNode.Ctx
+	job.cancelling = false
+	job.Status = JobRunning
+	job.StartedAt = time.Now().Format(time.RFC3339)
+	job.started = time.Now()
+	job.cancel = cancel
+	job.BytesReceived = 0
+	job.Rate = 0
+	job.ETASeconds = 0
+	job.Error = ""
+	return ctx
+}
+
+func (job *DownloadJob) addBytes(n int64) {
+	jobsMutex.Lock()
+	defer jobsMutex.Unlock()
+	job.BytesReceived += n
+	elapsed := time.Since(job.started).Seconds()
+	if elapsed > 0 {
+		job.Rate = float64(job.BytesReceived) / elapsed
+	}
+	if job.Rate > 0 && job.FileSize > job.BytesReceived {
+		job.ETASeconds = float64(job.FileSize-job.BytesReceived) / job.Rate
+	} else {
+		job.ETASeconds = 0
+	}
+}
+
+func (job *DownloadJob) finish(err error) {
+	jobsMutex.Lock()
+	defer jobsMutex.Unlock()
+	job.finishLocked(err)
+}
+
+// Records the outcome of a transfer. A job stopped to pause goes back to paused rather than finishing, unless the
+// transfer completed before the pause took effect, and one stopped because the DHT node stopped waits in the queue
+// for the node to start again. Caller holds jobsMutex
+func (job *DownloadJob) finishLocked(err error) {
+	if job.cancel != nil {
+		job.cancel()
+		job.cancel = nil
+	}
+	job.Rate = 0
+	job.ETASeconds = 0
+	if job.pausing && err == nil {
+		job.pausing = false
+	}
+	nodeStopped := job.nodeCtx != nil && job.nodeCtx.Err() != nil
+	job.nodeCtx = nil
+	if err != nil && nodeStopped && !job.pausing && !job.cancelling {
+		// dispatchQueue starts it again once a node is running
+		job.Status = JobQueued
+		job.BytesReceived = 0
+		job.Error = "waiting for the DHT node to start"
+		job.interruptLocked()
+		saveQueueLocked()
+		return
+	}
+	job.cancelling = false
+	if job.pausing {
+		job.pausing = false
+		job.Status = JobPaused
+		job.BytesReceived = 0
+		job.interruptLocked()
+		saveQueueLocked()
+		return
+	}
+	var busy *providerBusyError
+	if errors.As(err, &busy) && job.retries < maxBusyRetries {
+		job.retries++
+		job.Status = JobQueued
+		job.Error = busy.Error()
+		job.notBefore = time.Now().Add(busy.retryAfter)
+		job.interruptLocked()
+		saveQueueLocked()
+		return
+	}
+	job.FinishedAt = time.Now().Format(time.RFC3339)
+	job.err = err
+	switch {
+	case err == nil:
+		job.Status = JobCompleted
+	case errors.Is(err, context.Canceled):
+		job.Status = JobCancelled
+		job.Error = "cancelled"
+	default:
+		job.Status = JobFailed
+		job.Error = err.Error()
+	}
+	close(job.done)
+	saveQueueLocked()
+}
+
+// Fetches the file into DownloadPath and records it in the download history. Without an explicit provider every
+// candidate is tried, best reputation first, until one delivers the file. A partial file is removed if a transfer
+// fails or is cancelled
+func (job *DownloadJob) run(ctx context.Context) (err error) {
+	defer func() {
+		job.finish(err)
+		wakeQueue()
+	}()
+
+	candidates, err := job.candidates(ctx)
+	if err != nil {
+		return err
+	}
+	var busyErr error
+	for _, providerID := range candidates {
+		err = job.attempt(ctx, providerID)
+		var busy *providerBusyError
+		switch {
+		case err == nil:
+			reputation.Record(providerID, reputation.OutcomeDownloadSuccess)
+			return nil
+		case ctx.Err() != nil:
+			return err
+		case errors.As(err, &busy):
+			busyErr = err
+		case errors.Is(err, errHashMismatch):
+			reputation.Record(providerID, reputation.OutcomeHashMismatch)
+		case errors.Is(err, errPayeeMismatch):
+			reputation.Record(providerID, reputation.OutcomeWalletMismatch)
+		default:
+			reputation.Record(providerID, reputation.OutcomeTransferFailed)
+		}
+		if len(candidates) > 1 {
+			fmt.Printf("Download of %s from %s failed: %v\n", job.FileHash, providerID, err)
+		}
+	}
+	// a busy provider asked us to come back, so retry later rather than fail
+	if busyErr != nil {
+		return busyErr
+	}
+	return err
+}
+
+// Providers to try, best first: the requested provider alone, or the given list or the DHT's providers ranked by
+// reputation
+func (job *DownloadJob) candidates(ctx context.Context) ([]string, error) {
+	if job.requestedProvider != "" {
+		return []string{job.requestedProvider}, nil
+	}
+	providerIDs := job.Providers
+	if len(providerIDs) == 0 {
+		providers, err := global.DHTNode.FindProviders(job.FileHash)
+		if err != nil {
+			return nil, fmt.Errorf("error finding providers: %w", ctxErr(ctx, err))
+		}
+		self := global.DHTNode.Host.ID()
+		for _, provider := range providers {
+			if provider.ID != self {
+				providerIDs = append(providerIDs, provider.ID.String())
+			}
+		}
+	}
+	if len(providerIDs) == 0 {
+		return nil, errors.New("no providers found for the file")
+	}
+	return reputation.Rank(providerIDs), nil
+}
+
+// Fetches the file from one provider
+func (job *DownloadJob) attempt(ctx context.Context, providerID string) (err error) {
+	jobsMutex.Lock()
+	job.ProviderID = providerID
+	job.BytesReceived = 0
+	jobsMutex.Unlock()
+
+	peerID, err := peer.Decode(providerID)
+	if err != nil {
+		return fmt.Errorf("invalid provider ID: %w", err)
+	}
+	peerInfo, err := global.DHTNode.DHT.FindPeer(ctx, peerID)
+	if err != nil {
+		return fmt.Errorf("error finding peer: %w", ctxErr(ctx, err))
+	}
+
+	stream, err := global.DHTNode.Host.NewStream(ctx, peerInfo.ID, handlers.FileRequestProtocol)
+	if err != nil {
+		return fmt.Errorf("error opening stream: %w", ctxErr(ctx, err))
+	}
+	defer stream.Close()
+	// cancelling the job resets the stream, which unblocks any pending read
+	stop := context.AfterFunc(ctx, func() { stream.Reset() })
+	defer stop()
+
+	fmt.Printf("Connected to provider %s\n", providerID)
+
+	request := job.FileHash
+	if job.Member != "" {
+		request += " " + handlers.BundleMemberRequest + " " + job.Member
+	}
+	if _, err := stream.Write([]byte(request + "\n")); err != nil {
+		return fmt.Errorf("error sending file hash: %w", ctxErr(ctx, err))
+	}
+
+	// A busy provider answers with a ServeStatus instead of the metadata
+	decoder := json.NewDecoder(stream)
+	var reply json.RawMessage
+	requested := time.Now()
+	if err := decoder.Decode(&reply); err != nil {
+		return fmt.Errorf("error decoding metadata: %w", ctxErr(ctx, err))
+	}
+	reputation.RecordLatency(providerID, time.Since(requested))
+	var status handlers.ServeStatus
+	if json.Unmarshal(reply, &status) == nil && status.Busy {
+		return &providerBusyError{reason: status.Reason, retryAfter: time.Duration(status.RetryAfter) * time.Second}
+	}
+	if status.Error != "" {
+		return fmt.Errorf("provider cannot serve the file: %s", status.Error)
+	}
+	var metadata FormData
+	if err := json.Unmarshal(reply, &metadata); err != nil {
+		return fmt.Errorf("error decoding metadata: %w", err)
+	}
+	fmt.Printf("Received metadata: %v\n", metadata)
+
+	var wallet WalletAddress
+	if err := decoder.Decode(&wallet); err != nil {
+		return fmt.Errorf("error decoding wallet address: %w", ctxErr(ctx, err))
+	}
+	wallet.WalletID = strings.TrimSpace(wallet.WalletID)
+
+	// Bundles send their manifest next; it must hash to the bundle hash we asked for
+	var manifest *bundle.Manifest
+	if metadata.BundleMode {
+		if err := decoder.Decode(&manifest); err != nil {
+			return fmt.Errorf("error decoding bundle manifest: %w", ctxErr(ctx, err))
+		}
+		if manifest.Hash() != job.FileHash {
+			return errors.New("bundle manifest does not match the requested hash")
+		}
+		if err := manifest.Validate(); err != nil {
+			return err
+		}
+	} else if job.Member != "" {
+		return errors.New("a member was requested but the file is not a bundle")
+	}
+
+	// Pay the address the provider has attested to; the in-band address must not contradict it
+	payee, err := addressbook.Resolve(peerInfo.ID)
+	if err != nil {
+		fmt.Printf("Could not verify wallet address of %s: %v\n", providerID, err)
+		if err := addressbook.CheckClaim(providerID, wallet.WalletID); err != nil {
+			return fmt.Errorf("%w: %v", errPayeeMismatch, err)
+		}
+	} else if payee != wallet.WalletID {
+		return fmt.Errorf("%w: provider sent wallet %s but has attested to %s", errPayeeMismatch, wallet.WalletID, payee)
+	}
+
+	jobsMutex.Lock()
+	job.FileName = metadata.FileName
+	job.FileSize = metadata.FileSize
+	job.WalletAddress = wallet.WalletID
+	jobsMutex.Unlock()
+
+	fmt.Println("Downloading in Progress")
+	progress := newProgressWriter(job)
+	progress.publish("started", nil)
+	// the decoder may already hold the first bytes of the file
+	body := bandwidth.NewReader(ctx, io.MultiReader(decoder.Buffered(), stream), downloadLimiter)
+	if manifest != nil {
+		err = job.writeBundle(manifest, body, progress)
+	} else {
+		err = job.writeFile(filepath.Base(metadata.FileName), body, progress)
+	}
+	if err != nil {
+		err = ctxErr(ctx, err)
+		if errors.Is(err, context.Canceled) {
+			progress.publish("stopped", nil)
+		} else {
+			progress.publish("failed", err)
+		}
+		return fmt.Errorf("error downloading file: %w", err)
+	}
+	progress.publish("completed", nil)
+	fmt.Println("File Downloaded Successfully")
+
+	fileName := metadata.FileName
+	if manifest != nil && job.Member == "" {
+		fileName = manifest.Name
+	}
+	downloadedFile := FormData{
+		WalletID:   job.walletID,
+		SrcID:      providerID,
+		Price:      metadata.Price,
+		FileName:   fileName,
+		FilePath:   job.DownloadPath,
+		FileSize:   metadata.FileSize,
+		FileType:   metadata.FileType,
+		Timestamp:  time.Now().Format(time.RFC3339),
+		FileHash:   job.FileHash,
+		BundleMode: metadata.BundleMode,
+	}
+	if res := download.StoreFile(download.FormData(downloadedFile)); res != 0 {
+		return errors.New("error storing file in downloads.json")
+	}
+	return nil
+}
+
+// Writes a single file into the download directory. Nothing appears under the file's name until the content hashes to
+// the requested file hash
+func (job *DownloadJob) writeFile(name string, body io.Reader, progress io.Writer) error {
+	filePath := filepath.Join(job.DownloadPath, name)
+	tempPath, hash, err := receiveTemp(filePath, body, -1, progress)
+	if err != nil {
+		return err
+	}
+	if hash != job.FileHash {
+		os.Remove(tempPath)
+		return errHashMismatch
+	}
+	if err := os.Rename(tempPath, filePath); err != nil {
+		os.Remove(tempPath)
+		return fmt.Errorf("error saving file: %w", err)
+	}
+	return nil
+}
+
+// Receives a file into a dot-prefixed temporary file beside filePath, so the shared-folder scan never publishes a
+// partial download. A negative size reads to the end of body. Returns the temporary path and the hex sha256 of what was
+// written; the caller renames the file into place or removes it
+func receiveTemp(filePath string, body io.Reader, size int64, progress io.Writer) (string, string, error) {
+	file, err := os.CreateTemp(filepath.Dir(filePath), "."+filepath.Base(filePath)+".*.part")
+	if err != nil {
+		return "", "", fmt.Errorf("error creating file: %w", err)
+	}
+	hasher := sha256.New()
+	dst := io.MultiWriter(file, hasher, progress)
+	if size < 0 {
+		_, err = io.Copy(dst, body)
+	} else {
+		_, err = io.CopyN(dst, body, size)
+	}
+	if err == nil {
+		err = file.Chmod(0644)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return "", "", err
+	}
+	return file.Name(), hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// Rebuilds a bundle under <download path>/<bundle name>, checking every member against its manifest hash. A whole
+// bundle is streamed in manifest order; a single member is the only thing on the stream. Members are staged beside
+// their targets and renamed into place only once all of them check out, so a failed transfer never touches files
+// already in the download directory
+func (job *DownloadJob) writeBundle(manifest *bundle.Manifest, body io.Reader, progress io.Writer) (err error) {
+	members := manifest.Members
+	if job.Member != "" {
+		member, ok := manifest.Member(job.Member)
+		if !ok {
+			return fmt.Errorf("bundle has no member %s", job.Member)
+		}
+		members = []bundle.Member{member}
+	}
+
+	root := filepath.Join(job.DownloadPath, manifest.Name)
+	_, statErr := os.Stat(root)
+	createdRoot := os.IsNotExist(statErr)
+	type stagedFile struct{ tempPath, filePath string }
+	var staged []stagedFile
+	defer func() {
+		if err == nil {
+			return
+		}
+		for _, file := range staged {
+			os.Remove(file.tempPath)
+		}
+		if createdRoot {
+			os.RemoveAll(root)
+		}
+	}()
+
+	for _, member := range members {
+		filePath := filepath.Join(root, filepath.FromSlash(member.Path))
+		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+			return fmt.Errorf("error creating directory: %w", err)
+		}
+		tempPath, hash, err := receiveTemp(filePath, body, member.Size, progress)
+		if err != nil {
+			return fmt.Errorf("error receiving %s: %w", member.Path, err)
+		}
+		staged = append(staged, stagedFile{tempPath, filePath})
+		if hash != member.Hash {
+			return fmt.Errorf("%w: %s does not match its manifest hash", errHashMismatch, member.Path)
+		}
+	}
+	for i, file := range staged {
+		if err := os.Rename(file.tempPath, file.filePath); err != nil {
+			staged = staged[i:]
+			return fmt.Errorf("error saving %s: %w", file.filePath, err)
+		}
+	}
+	return nil
+}
+
+// A reset stream surfaces as a stream error; report it as a cancellation when the job was cancelled
+func ctxErr(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+func decodeDownloadRequest(r *http.Request) (DownloadRequest, error) {
+	var req DownloadRequest
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return req, errors.New("Error reading request body")
+	}
+	defer r.Body.Close()
+	if err := json.Unmarshal(body, &req); err != nil {
+		return req, errors.New("Error unmarshalling request body")
+	}
+	if req.FileHash == "" || req.DownloadPath == "" {
+		return req, errors.New("'fileHash' and 'downloadPath' are required")
+	}
+	if req.ProviderID != "" {
+		if _, err := peer.Decode(req.ProviderID); err != nil {
+			return req, errors.New("Invalid provider ID")
+		}
+	}
+	for _, providerID := range req.Providers {
+		if _, err := peer.Decode(providerID); err != nil {
+			return req, errors.New("Invalid provider ID")
+		}
+	}
+	return req, nil
+}
+
+// StartDownloadJob queues a download and returns its job ID. Body as for /download, plus an optional priority
+func StartDownloadJob(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	req, err := decodeDownloadRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	job := EnqueueDownload(req)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{"jobID": job.ID, "status": JobQueued})
+}
+
+// ListDownloadJobs returns running and recently finished jobs, oldest first
+func ListDownloadJobs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	jobsMutex.Lock()
+	list := make([]DownloadJob, 0, len(jobOrder))
+	for _, id := range jobOrder {
+		list = append(list, *jobs[id])
+	}
+	jobsMutex.Unlock()
+	json.NewEncoder(w).Encode(list)
+}
+
+// GetDownloadJob reports bytes received, rate, ETA and provider of one job
+func GetDownloadJob(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	jobsMutex.Lock()
+	job, ok := jobs[mux.Vars(r)["jobID"]]
+	jobsMutex.Unlock()
+	if !ok {
+		http.Error(w, "Download job not found", http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(job.snapshot())
+}
+
+// CancelDownloadJob aborts a queued, paused or running job; a partial file is removed
+func CancelDownloadJob(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	jobsMutex.Lock()
+	defer jobsMutex.Unlock()
+	job, ok := jobs[mux.Vars(r)["jobID"]]
+	if !ok {
+		http.Error(w, "Download job not found", http.StatusNotFound)
+		return
+	}
+	if !job.cancelLocked() {
+		http.Error(w, "Download job has already finished", http.StatusConflict)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"jobID": job.ID, "status": JobCancelled})
+}
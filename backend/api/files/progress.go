@@ -0,0 +1,46 @@
+package files
+
+import (
+	"Otternet/backend/api/events"
+	"time"
+)
+
+// How often download progress events are published
+const progressInterval = 500 * time.Millisecond
+
+// progressWriter counts bytes written to a download job and periodically publishes progress events
+type progressWriter struct {
+	job         *DownloadJob
+	lastPublish time.Time
+}
+
+func newProgressWriter(job *DownloadJob) *progressWriter {
+	return &progressWriter{job: job}
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	p.job.addBytes(int64(len(b)))
+	if time.Since(p.lastPublish) >= progressInterval {
+		p.lastPublish = time.Now()
+		p.publish("progress", nil)
+	}
+	return len(b), nil
+}
+
+func (p *progressWriter) publish(eventType string, err error) {
+	job := p.job.snapshot()
+	data := map[string]interface{}{
+		"jobID":         job.ID,
+		"fileHash":      job.FileHash,
+		"fileName":      job.FileName,
+		"providerID":    job.ProviderID,
+		"bytesReceived": job.BytesReceived,
+		"fileSize":      job.FileSize,
+		"rate":          job.Rate,
+		"etaSeconds":    job.ETASeconds,
+	}
+	if err != nil {
+		data["error"] = err.Error()
+	}
+	events.Publish(events.TopicDownload, eventType, data)
+}
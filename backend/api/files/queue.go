@@ -0,0 +1,291 @@
+package files
+
+import (
+	"Otternet/backend/api/bandwidth"
+	"Otternet/backend/api/events"
+	"Otternet/backend/config"
+	"Otternet/backend/global"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const queueFilePath = "./api/files/queue.json"
+
+// queuedDownload is how a pending download is persisted so it restarts after the backend restarts
+type queuedDownload struct {
+	ID      string          `json:"id"`
+	Request DownloadRequest `json:"request"`
+	Status  string          `json:"status"` // JobQueued or JobPaused; running downloads are saved as queued
+	AddedAt string          `json:"addedAt"`
+}
+
+var (
+	maxConcurrentDownloads = config.NewConfig().MaxConcurrentDownloads
+	downloadLimiter        = bandwidth.NewLimiter(config.NewConfig().DownloadRateLimit)
+	queueWake              = make(chan struct{}, 1)
+)
+
+// Nudges the dispatcher to look for work
+func wakeQueue() {
+	select {
+	case queueWake <- struct{}{}:
+	default:
+	}
+}
+
+// Writes every unfinished job to the queue file. Caller holds jobsMutex
+func saveQueueLocked() {
+	pending := []queuedDownload{}
+	for _, id := range jobOrder {
+		job := jobs[id]
+		if !job.active() {
+			continue
+		}
+		status := job.Status
+		if status == JobRunning {
+			status = JobQueued
+			if job.pausing {
+				status = JobPaused
+			}
+		}
+		pending = append(pending, queuedDownload{ID: job.ID, Request: job.request(), Status: status, AddedAt: job.AddedAt})
+	}
+	data, err := json.MarshalIndent(pending, "", " ")
+	if err != nil {
+		fmt.Printf("Error marshalling download queue: %v\n", err)
+		return
+	}
+	if err := os.WriteFile(queueFilePath, data, 0644); err != nil {
+		fmt.Printf("Error writing download queue: %v\n", err)
+	}
+}
+
+// Restores downloads left in the queue file by a previous run
+func loadQueue() error {
+	data, err := os.ReadFile(queueFilePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading download queue: %w", err)
+	}
+	var pending []queuedDownload
+	if err := json.Unmarshal(data, &pending); err != nil {
+		return fmt.Errorf("error unmarshalling download queue: %w", err)
+	}
+	jobsMutex.Lock()
+	defer jobsMutex.Unlock()
+	for _, entry := range pending {
+		if _, exists := jobs[entry.ID]; exists {
+			continue
+		}
+		status := JobQueued
+		if entry.Status == JobPaused {
+			status = JobPaused
+		}
+		addJobLocked(entry.ID, entry.Request, status, entry.AddedAt)
+	}
+	return nil
+}
+
+// ReloadQueue adds the downloads in the queue file that are not already known, e.g. after a backup restored the file.
+// Jobs already in the queue are kept, and the merged queue is saved
+func ReloadQueue() error {
+	if err := loadQueue(); err != nil {
+		return err
+	}
+	jobsMutex.Lock()
+	saveQueueLocked()
+	jobsMutex.Unlock()
+	wakeQueue()
+	return nil
+}
+
+// EnqueueDownload adds a download to the queue. It starts once a slot is free and the DHT node is running
+func EnqueueDownload(req DownloadRequest) *DownloadJob {
+	jobsMutex.Lock()
+	job := addJobLocked(newJobID(), req, JobQueued, time.Now().Format(time.RFC3339))
+	saveQueueLocked()
+	jobsMutex.Unlock()
+	events.Publish(events.TopicDownload, "queued", map[string]interface{}{
+		"jobID":      job.ID,
+		"fileHash":   req.FileHash,
+		"providerID": req.ProviderID,
+		"priority":   req.Priority,
+	})
+	wakeQueue()
+	return job
+}
+
+// Starts queued jobs, highest priority first and oldest first within a priority, while slots are free
+func dispatchQueue() {
+	jobsMutex.Lock()
+	defer jobsMutex.Unlock()
+	if global.DHTNode == nil {
+		return
+	}
+	running := 0
+	for _, job := range jobs {
+		if job.Status == JobRunning {
+			running++
+		}
+	}
+	now := time.Now()
+	for running < maxConcurrentDownloads {
+		var next *DownloadJob
+		for _, id := range jobOrder {
+			job := jobs[id]
+			if job.Status != JobQueued || now.Before(job.notBefore) {
+				continue
+			}
+			if next == nil || job.Priority > next.Priority {
+				next = job
+			}
+		}
+		if next == nil {
+			return
+		}
+		ctx := next.beginLocked()
+		running++
+		go func(job *DownloadJob) {
+			if err := job.run(ctx); err != nil {
+				fmt.Printf("Download job %s: %v\n", job.ID, err)
+			}
+		}(next)
+	}
+}
+
+// RunDownloadQueue restores the persisted queue and starts downloads as slots free up until ctx is done
+func RunDownloadQueue(ctx context.Context) {
+	if err := loadQueue(); err != nil {
+		fmt.Printf("Download queue: %v\n", err)
+	}
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		dispatchQueue()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-queueWake:
+		}
+	}
+}
+
+// Looks up a job by the {jobID} route variable, writing a 404 if it does not exist. Caller holds jobsMutex
+func routeJobLocked(w http.ResponseWriter, r *http.Request) (*DownloadJob, bool) {
+	job, ok := jobs[mux.Vars(r)["jobID"]]
+	if !ok {
+		http.Error(w, "Download job not found", http.StatusNotFound)
+	}
+	return job, ok
+}
+
+// PauseDownloadJob holds a queued job back or stops a running one. Transfers cannot be resumed mid-file, so a paused
+// download starts over when resumed
+func PauseDownloadJob(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	jobsMutex.Lock()
+	defer jobsMutex.Unlock()
+	job, ok := routeJobLocked(w, r)
+	if !ok {
+		return
+	}
+	switch job.Status {
+	case JobQueued:
+		job.Status = JobPaused
+		job.interruptLocked()
+		saveQueueLocked()
+	case JobRunning:
+		job.pausing = true
+		job.cancel()
+	case JobPaused:
+	default:
+		http.Error(w, "Download job has already finished", http.StatusConflict)
+		return
+	}
+	events.Publish(events.TopicDownload, "paused", map[string]string{"jobID": job.ID, "fileHash": job.FileHash})
+	json.NewEncoder(w).Encode(map[string]string{"jobID": job.ID, "status": JobPaused})
+}
+
+// ResumeDownloadJob puts a paused job back in the queue
+func ResumeDownloadJob(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	jobsMutex.Lock()
+	defer jobsMutex.Unlock()
+	job, ok := routeJobLocked(w, r)
+	if !ok {
+		return
+	}
+	if job.Status != JobPaused {
+		http.Error(w, "Download job is not paused", http.StatusConflict)
+		return
+	}
+	job.Status = JobQueued
+	saveQueueLocked()
+	wakeQueue()
+	json.NewEncoder(w).Encode(map[string]string{"jobID": job.ID, "status": JobQueued})
+}
+
+// SetDownloadPriority changes the priority of an unfinished job. Body: {"priority": n}
+func SetDownloadPriority(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var req struct {
+		Priority int `json:"priority"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	jobsMutex.Lock()
+	defer jobsMutex.Unlock()
+	job, ok := routeJobLocked(w, r)
+	if !ok {
+		return
+	}
+	if !job.active() {
+		http.Error(w, "Download job has already finished", http.StatusConflict)
+		return
+	}
+	job.Priority = req.Priority
+	saveQueueLocked()
+	wakeQueue()
+	json.NewEncoder(w).Encode(map[string]interface{}{"jobID": job.ID, "priority": job.Priority})
+}
+
+type downloadSettings struct {
+	MaxConcurrent int   `json:"maxConcurrent"`
+	RateLimit     int64 `json:"rateLimit"` // bytes per second, 0 for unlimited
+}
+
+func GetDownloadSettings(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	jobsMutex.Lock()
+	settings := downloadSettings{MaxConcurrent: maxConcurrentDownloads, RateLimit: downloadLimiter.Rate()}
+	jobsMutex.Unlock()
+	json.NewEncoder(w).Encode(settings)
+}
+
+// SetDownloadSettings changes the concurrency limit and the global bandwidth cap. Lowering the limit lets running
+// downloads finish rather than stopping them
+func SetDownloadSettings(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var settings downloadSettings
+	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil || settings.MaxConcurrent < 1 || settings.RateLimit < 0 {
+		http.Error(w, "Invalid JSON body; 'maxConcurrent' must be at least 1 and 'rateLimit' not negative", http.StatusBadRequest)
+		return
+	}
+	jobsMutex.Lock()
+	maxConcurrentDownloads = settings.MaxConcurrent
+	jobsMutex.Unlock()
+	downloadLimiter.SetRate(settings.RateLimit)
+	wakeQueue()
+	json.NewEncoder(w).Encode(settings)
+}
@@ -0,0 +1,130 @@
+package files
+
+import (
+	"Otternet/backend/api/bundle"
+	"Otternet/backend/global"
+	"Otternet/backend/global_wallet"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// FileType is how every catalog entry records its type: the file name's extension including the dot, such as ".pdf",
+// or "" when it has none. /uploadFile receives it this way from the frontend, so files registered by path and by the
+// shared-folder watcher use the same convention
+func FileType(filePath string) string {
+	return filepath.Ext(filePath)
+}
+
+// DescribeLocalFile hashes a local file and fills in the metadata the backend can vouch for: hash, size, name, type
+// (see FileType) and absolute path
+func DescribeLocalFile(filePath string) (FormData, error) {
+	abs, err := filepath.Abs(filePath)
+	if err != nil {
+		return FormData{}, err
+	}
+	info, err := os.Stat(abs)
+	if err != nil {
+		return FormData{}, err
+	}
+	if !info.Mode().IsRegular() {
+		return FormData{}, fmt.Errorf("%s is not a regular file", abs)
+	}
+	hash, size, err := bundle.HashFile(abs)
+	if err != nil {
+		return FormData{}, fmt.Errorf("error hashing file: %w", err)
+	}
+	return FormData{
+		FileName: filepath.Base(abs),
+		FilePath: abs,
+		FileSize: size,
+		FileType: FileType(abs),
+		FileHash: hash,
+	}, nil
+}
+
+// RegisterFile publishes a file from a path on this machine. The backend computes the hash, size and type itself; any
+// of fileHash, fileSize or fileType sent by the client must agree with them. fileType is the extension, as the
+// frontend sends it, though a MIME type matching the extension is accepted too.
+// Body: {"filePath", "price", "fileName", "fileHash", "fileSize", "fileType"}
+func RegisterFile(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var req struct {
+		FilePath string  `json:"filePath"`
+		Price    float64 `json:"price"`
+		FileName string  `json:"fileName"`
+		FileHash string  `json:"fileHash"`
+		FileSize int64   `json:"fileSize"`
+		FileType string  `json:"fileType"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.FilePath == "" || req.Price < 0 {
+		http.Error(w, "Invalid JSON body; 'filePath' is required", http.StatusBadRequest)
+		return
+	}
+	if global.DHTNode == nil {
+		http.Error(w, "DHT node is not running", http.StatusServiceUnavailable)
+		return
+	}
+
+	postData, err := DescribeLocalFile(req.FilePath)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var mismatches []string
+	if req.FileHash != "" && !strings.EqualFold(req.FileHash, postData.FileHash) {
+		mismatches = append(mismatches, fmt.Sprintf("fileHash is %s, not %s", postData.FileHash, req.FileHash))
+	}
+	if req.FileSize != 0 && req.FileSize != postData.FileSize {
+		mismatches = append(mismatches, fmt.Sprintf("fileSize is %d, not %d", postData.FileSize, req.FileSize))
+	}
+	if req.FileType != "" && !sameFileType(req.FileType, postData.FileType) {
+		mismatches = append(mismatches, fmt.Sprintf("fileType is %s, not %s", postData.FileType, req.FileType))
+	}
+	if len(mismatches) > 0 {
+		http.Error(w, "Client metadata does not match the file: "+strings.Join(mismatches, "; "), http.StatusConflict)
+		return
+	}
+
+	if req.FileName != "" {
+		postData.FileName = req.FileName
+	}
+	postData.WalletID = global_wallet.WalletAddr
+	postData.SrcID = global.DHTNode.Host.ID().String()
+	postData.Price = req.Price
+	postData.Timestamp = time.Now().Format(time.RFC3339)
+
+	err = PublishFile(postData)
+	if errors.Is(err, errNotProvided) {
+		http.Error(w, "Error storing file in DHT", http.StatusInternalServerError)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "success", "file": postData})
+}
+
+// Reports whether a client's fileType names the same type as the extension ext. Extensions compare case-insensitively;
+// a MIME type is compared, ignoring parameters such as charset, with the type registered for ext
+func sameFileType(claimed string, ext string) bool {
+	if strings.EqualFold(claimed, ext) {
+		return true
+	}
+	if strings.HasPrefix(claimed, ".") {
+		return false
+	}
+	claimedType, _, err := mime.ParseMediaType(claimed)
+	if err != nil {
+		return false
+	}
+	extType, _, err := mime.ParseMediaType(mime.TypeByExtension(ext))
+	return err == nil && claimedType == extType
+}
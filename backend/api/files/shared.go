@@ -0,0 +1,422 @@
+package files
+
+import (
+	"Otternet/backend/config"
+	"Otternet/backend/global"
+	"Otternet/backend/global_wallet"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const sharedFilePath = "./api/files/shared.json"
+
+// sharedFile is what the folder watcher last saw of a file it published
+type sharedFile struct {
+	FileHash string `json:"fileHash"`
+	Size     int64  `json:"size"`
+	ModTime  int64  `json:"modTime"`           // unix nanoseconds
+	Adopted  bool   `json:"adopted,omitempty"` // the hash was already published by hand; its entry and price are kept
+}
+
+type sharedState struct {
+	Folders      []string              `json:"folders"`
+	Removed      []string              `json:"removed,omitempty"` // folders from the config that were unshared through the API
+	DefaultPrice float64               `json:"defaultPrice"`
+	Files        map[string]sharedFile `json:"files"` // absolute path -> last published version
+	LastScan     string                `json:"lastScan,omitempty"`
+	LastError    string                `json:"lastError,omitempty"`
+}
+
+var (
+	sharedMutex = &sync.Mutex{}
+	sharedWake  = make(chan struct{}, 1)
+)
+
+// Reads the watcher's state. Folders added to the config later are shared too, unless they were unshared through the
+// API
+func readShared() (sharedState, error) {
+	cfg := config.NewConfig()
+	state := sharedState{DefaultPrice: cfg.SharedFolderPrice}
+	data, err := os.ReadFile(sharedFilePath)
+	if err == nil {
+		err = json.Unmarshal(data, &state)
+	} else if os.IsNotExist(err) {
+		err = nil
+	}
+	if state.Files == nil {
+		state.Files = make(map[string]sharedFile)
+	}
+	for _, folder := range cfg.SharedFolders {
+		if abs, absErr := filepath.Abs(folder); absErr == nil {
+			folder = abs
+		}
+		if !containsFolder(state.Folders, folder) && !containsFolder(state.Removed, folder) {
+			state.Folders = append(state.Folders, folder)
+		}
+	}
+	return state, err
+}
+
+func containsFolder(folders []string, folder string) bool {
+	for _, existing := range folders {
+		if existing == folder {
+			return true
+		}
+	}
+	return false
+}
+
+func removeFolder(folders []string, folder string) []string {
+	kept := folders[:0]
+	for _, existing := range folders {
+		if existing != folder {
+			kept = append(kept, existing)
+		}
+	}
+	return kept
+}
+
+func writeShared(state sharedState) error {
+	data, err := json.MarshalIndent(state, "", " ")
+	if err != nil {
+		return fmt.Errorf("error marshalling shared folders: %w", err)
+	}
+	return os.WriteFile(sharedFilePath, data, 0644)
+}
+
+func wakeSharedWatcher() {
+	select {
+	case sharedWake <- struct{}{}:
+	default:
+	}
+}
+
+// Reports whether filePath is inside folder
+func inFolder(folder string, filePath string) bool {
+	rel, err := filepath.Rel(folder, filePath)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// Returns another tracked path with the same contents as filePath. Caller holds sharedMutex
+func sharedCopy(state *sharedState, filePath string, fileHash string) (string, bool) {
+	for otherPath, other := range state.Files {
+		if otherPath != filePath && other.FileHash == fileHash {
+			return otherPath, true
+		}
+	}
+	return "", false
+}
+
+// Returns this wallet's catalog entry for fileHash, if any
+func catalogEntry(fileHash string) (FormData, bool) {
+	postDatas, err := LoadFiles()
+	if err != nil {
+		return FormData{}, false
+	}
+	for _, data := range postDatas {
+		if data.FileHash == fileHash && data.WalletID == global_wallet.WalletAddr {
+			return data, true
+		}
+	}
+	return FormData{}, false
+}
+
+// Publishes the file at filePath, replacing an earlier version if its contents changed. Files that are already in the
+// catalog, whether published by hand or from another shared path, keep their entry and price. Caller holds sharedMutex
+func publishShared(state *sharedState, filePath string, info fs.FileInfo) error {
+	postData, err := DescribeLocalFile(filePath)
+	if err != nil {
+		return err
+	}
+	previous, known := state.Files[filePath]
+	seen := sharedFile{FileHash: postData.FileHash, Size: info.Size(), ModTime: info.ModTime().UnixNano()}
+	if known && previous.FileHash == postData.FileHash {
+		// touched but unchanged
+		seen.Adopted = previous.Adopted
+		state.Files[filePath] = seen
+		return nil
+	}
+	if known {
+		releaseShared(state, filePath)
+	}
+
+	if _, ok := sharedCopy(state, filePath, postData.FileHash); ok {
+		// the catalog entry belongs to the copy that was published first
+		seen.Adopted = true
+		state.Files[filePath] = seen
+		return nil
+	}
+	if _, ok := catalogEntry(postData.FileHash); ok {
+		seen.Adopted = true
+		state.Files[filePath] = seen
+		fmt.Printf("Shared folders: %s is already published; keeping its price\n", filePath)
+		return nil
+	}
+
+	postData.WalletID = global_wallet.WalletAddr
+	postData.SrcID = global.DHTNode.Host.ID().String()
+	postData.Price = state.DefaultPrice
+	postData.Timestamp = time.Now().Format(time.RFC3339)
+	if err := PublishFile(postData); err != nil && !errors.Is(err, errNotProvided) {
+		return err
+	}
+	state.Files[filePath] = seen
+	if known {
+		fmt.Printf("Shared folders: republished modified file %s as %s\n", filePath, postData.FileHash)
+	} else {
+		fmt.Printf("Shared folders: published %s as %s\n", filePath, postData.FileHash)
+	}
+	return nil
+}
+
+// Stops tracking filePath. Its catalog entry moves to another shared copy of the same file if there is one, and is
+// only unpublished when the watcher published it and no copy is left. Caller holds sharedMutex
+func releaseShared(state *sharedState, filePath string) {
+	previous := state.Files[filePath]
+	delete(state.Files, filePath)
+
+	if otherPath, ok := sharedCopy(state, filePath, previous.FileHash); ok {
+		other := state.Files[otherPath]
+		if !previous.Adopted {
+			// the remaining copy inherits the entry the watcher published
+			other.Adopted = false
+			state.Files[otherPath] = other
+		}
+		if entry, ok := catalogEntry(previous.FileHash); ok && entry.FilePath == filePath {
+			entry.FilePath = otherPath
+			if err := replaceCatalogEntry(entry); err != nil {
+				fmt.Printf("Shared folders: error moving %s to %s: %v\n", filePath, otherPath, err)
+			}
+		}
+		return
+	}
+	if previous.Adopted {
+		return
+	}
+	if err := UnpublishFile(previous.FileHash); err != nil && !errors.Is(err, errFileNotFound) {
+		fmt.Printf("Shared folders: error unpublishing %s: %v\n", filePath, err)
+		return
+	}
+	fmt.Printf("Shared folders: unpublished %s\n", filePath)
+}
+
+// Walks every shared folder once, publishing new files, republishing modified ones and unpublishing files that are
+// gone. Size and modification time decide whether a file needs re-hashing
+func scanSharedFolders() error {
+	sharedMutex.Lock()
+	defer sharedMutex.Unlock()
+	state, err := readShared()
+	if err != nil {
+		return fmt.Errorf("error reading shared folders: %w", err)
+	}
+
+	present := make(map[string]bool)
+	var scanErrors []string
+	for _, folder := range state.Folders {
+		err := filepath.WalkDir(folder, func(filePath string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			// skip hidden files, which include downloads still in progress
+			if strings.HasPrefix(d.Name(), ".") && filePath != folder {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !d.Type().IsRegular() {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return nil
+			}
+			present[filePath] = true
+			previous, known := state.Files[filePath]
+			if known && previous.Size == info.Size() && previous.ModTime == info.ModTime().UnixNano() {
+				return nil
+			}
+			if err := publishShared(&state, filePath, info); err != nil {
+				scanErrors = append(scanErrors, fmt.Sprintf("%s: %v", filePath, err))
+			}
+			return nil
+		})
+		if err != nil {
+			// an unreadable folder must not unpublish everything in it
+			scanErrors = append(scanErrors, fmt.Sprintf("%s: %v", folder, err))
+			for filePath := range state.Files {
+				if inFolder(folder, filePath) {
+					present[filePath] = true
+				}
+			}
+		}
+	}
+	for filePath := range state.Files {
+		if !present[filePath] {
+			releaseShared(&state, filePath)
+		}
+	}
+
+	state.LastScan = time.Now().Format(time.RFC3339)
+	state.LastError = strings.Join(scanErrors, "; ")
+	return writeShared(state)
+}
+
+// WatchSharedFolders scans the shared folders at startup and then periodically while the DHT node is running
+func WatchSharedFolders(ctx context.Context) {
+	cfg := config.NewConfig()
+	ticker := time.NewTicker(cfg.SharedFolderScan)
+	defer ticker.Stop()
+	for {
+		if global.DHTNode != nil {
+			if err := scanSharedFolders(); err != nil {
+				fmt.Printf("Shared folders: %v\n", err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-sharedWake:
+		}
+	}
+}
+
+func GetSharedFolders(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	sharedMutex.Lock()
+	state, err := readShared()
+	sharedMutex.Unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(state)
+}
+
+// AddSharedFolder starts watching a directory. Body: {"path"}
+func AddSharedFolder(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var req struct {
+		Path string `json:"path"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Path == "" {
+		http.Error(w, "Invalid JSON body; 'path' is required", http.StatusBadRequest)
+		return
+	}
+	folder, err := filepath.Abs(req.Path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if info, err := os.Stat(folder); err != nil || !info.IsDir() {
+		http.Error(w, "Path is not a directory", http.StatusBadRequest)
+		return
+	}
+
+	sharedMutex.Lock()
+	defer sharedMutex.Unlock()
+	state, err := readShared()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if containsFolder(state.Folders, folder) {
+		http.Error(w, "Folder is already shared", http.StatusConflict)
+		return
+	}
+	state.Folders = append(state.Folders, folder)
+	state.Removed = removeFolder(state.Removed, folder)
+	if err := writeShared(state); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	wakeSharedWatcher()
+	json.NewEncoder(w).Encode(state)
+}
+
+// RemoveSharedFolder stops watching a directory and unpublishes the files it contributed. Body: {"path"}
+func RemoveSharedFolder(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var req struct {
+		Path string `json:"path"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Path == "" {
+		http.Error(w, "Invalid JSON body; 'path' is required", http.StatusBadRequest)
+		return
+	}
+	folder, err := filepath.Abs(req.Path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	sharedMutex.Lock()
+	defer sharedMutex.Unlock()
+	state, err := readShared()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !containsFolder(state.Folders, folder) {
+		http.Error(w, "Folder is not shared", http.StatusNotFound)
+		return
+	}
+	state.Folders = removeFolder(state.Folders, folder)
+	if !containsFolder(state.Removed, folder) {
+		// keeps a folder listed in the config from being shared again on the next read
+		state.Removed = append(state.Removed, folder)
+	}
+	for filePath := range state.Files {
+		if !inFolder(folder, filePath) {
+			continue
+		}
+		// still covered by another shared folder
+		covered := false
+		for _, other := range state.Folders {
+			covered = covered || inFolder(other, filePath)
+		}
+		if !covered {
+			releaseShared(&state, filePath)
+		}
+	}
+	if err := writeShared(state); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(state)
+}
+
+// SetSharedFolderPrice sets the price given to files published from now on. Body: {"defaultPrice"}
+func SetSharedFolderPrice(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var req struct {
+		DefaultPrice float64 `json:"defaultPrice"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.DefaultPrice < 0 {
+		http.Error(w, "Invalid JSON body; 'defaultPrice' must not be negative", http.StatusBadRequest)
+		return
+	}
+	sharedMutex.Lock()
+	defer sharedMutex.Unlock()
+	state, err := readShared()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	state.DefaultPrice = req.DefaultPrice
+	if err := writeShared(state); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(state)
+}
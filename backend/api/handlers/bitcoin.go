@@ -1,21 +1,104 @@
 package handlers
 
 import (
+	"Otternet/backend/api/streams"
 	"Otternet/backend/global_wallet"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
 	"log"
+	"strings"
+	"time"
 
+	"github.com/libp2p/go-libp2p/core/crypto"
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/network"
-	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/libp2p/go-libp2p/core/peer"
 )
 
-var WalletAddressReqHandler = protocol.ID("/otternet/walletAddressRequest")
+var WalletAddressReqHandler = streams.Protocol("/otternet/walletAddressRequest", streams.Limits{
+	Timeout:        30 * time.Second,
+	MaxMessageSize: 1 << 10,
+})
+
+// WalletAttestation is a peer's signed claim that payments to it should go to WalletAddress
+type WalletAttestation struct {
+	PeerID        string `json:"peerID"`
+	WalletAddress string `json:"walletAddress"`
+	Timestamp     int64  `json:"timestamp"`
+	PublicKey     string `json:"publicKey"` // base64 marshalled libp2p public key
+	Signature     string `json:"signature"` // base64 signature over SignedBytes
+}
+
+// Bytes covered by the attestation signature
+func (a WalletAttestation) SignedBytes() []byte {
+	return []byte(fmt.Sprintf("otternet-wallet-attestation:%s:%s:%d", a.PeerID, a.WalletAddress, a.Timestamp))
+}
+
+// Signs the node's current wallet address with its libp2p identity key
+func NewWalletAttestation(h host.Host) (WalletAttestation, error) {
+	privKey := h.Peerstore().PrivKey(h.ID())
+	if privKey == nil {
+		return WalletAttestation{}, fmt.Errorf("no private key for host %s", h.ID())
+	}
+	pubKey, err := crypto.MarshalPublicKey(privKey.GetPublic())
+	if err != nil {
+		return WalletAttestation{}, fmt.Errorf("error marshalling public key: %w", err)
+	}
+	attestation := WalletAttestation{
+		PeerID:        h.ID().String(),
+		WalletAddress: strings.TrimSpace(global_wallet.WalletAddr),
+		Timestamp:     time.Now().Unix(),
+		PublicKey:     base64.StdEncoding.EncodeToString(pubKey),
+	}
+	signature, err := privKey.Sign(attestation.SignedBytes())
+	if err != nil {
+		return WalletAttestation{}, fmt.Errorf("error signing wallet attestation: %w", err)
+	}
+	attestation.Signature = base64.StdEncoding.EncodeToString(signature)
+	return attestation, nil
+}
+
+// Checks that the attestation was signed by the key belonging to from
+func (a WalletAttestation) Verify(from peer.ID) error {
+	if a.PeerID != from.String() {
+		return fmt.Errorf("attestation is for peer %s, not %s", a.PeerID, from)
+	}
+	if a.WalletAddress == "" {
+		return fmt.Errorf("attestation has no wallet address")
+	}
+	pubKeyBytes, err := base64.StdEncoding.DecodeString(a.PublicKey)
+	if err != nil {
+		return fmt.Errorf("invalid public key encoding: %w", err)
+	}
+	pubKey, err := crypto.UnmarshalPublicKey(pubKeyBytes)
+	if err != nil {
+		return fmt.Errorf("invalid public key: %w", err)
+	}
+	keyID, err := peer.IDFromPublicKey(pubKey)
+	if err != nil || keyID != from {
+		return fmt.Errorf("public key does not belong to peer %s", from)
+	}
+	signature, err := base64.StdEncoding.DecodeString(a.Signature)
+	if err != nil {
+		return fmt.Errorf("invalid signature encoding: %w", err)
+	}
+	ok, err := pubKey.Verify(a.SignedBytes(), signature)
+	if err != nil || !ok {
+		return fmt.Errorf("invalid attestation signature")
+	}
+	return nil
+}
 
 func HandleWalletAddressRequests(h host.Host) {
-	h.SetStreamHandler(WalletAddressReqHandler, func(s network.Stream) {
+	streams.Handle(h, WalletAddressReqHandler, func(s network.Stream) {
 		defer s.Close()
-		walletAddr := global_wallet.WalletAddr
-		_, err := s.Write([]byte(walletAddr))
+		attestation, err := NewWalletAttestation(h)
+		if err != nil {
+			log.Printf("Error creating wallet attestation: %v", err)
+			return
+		}
+		err = json.NewEncoder(s).Encode(attestation)
 		if err != nil {
 			log.Printf("Error sending wallet address: %v", err)
 		}
@@ -1,27 +1,55 @@
 package handlers
 
 import (
+	"Otternet/backend/api/bandwidth"
+	"Otternet/backend/api/blocklist"
+	"Otternet/backend/api/bundle"
+	"Otternet/backend/api/events"
+	"Otternet/backend/api/streams"
 	"Otternet/backend/global_wallet"
 	"bufio"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"path"
 	"strings"
 	"path/filepath"
 	"strconv"
+	"time"
 
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/network"
-	"github.com/libp2p/go-libp2p/core/protocol"
 )
 
-var FileRequestProtocol = protocol.ID("/otternet/fileRequest")
-var PriceRequestProtocol = protocol.ID("/otternet/priceRequest")
-var CatalogRequestProtocol = protocol.ID("/otternet/catalogRequest")
-var OtternetPeersProtocol = protocol.ID("/otternet/peers")
+// A file request is one short line; serving it has no overall deadline, but the requester must keep reading
+var FileRequestProtocol = streams.Protocol("/otternet/fileRequest", streams.Limits{
+	ReadTimeout:       30 * time.Second,
+	IdleTimeout:       2 * time.Minute,
+	MaxMessageSize:    1 << 10,
+	MaxStreams:        64,
+	MaxStreamsPerPeer: 8,
+	Memory:            256 << 20,
+})
+var PriceRequestProtocol = streams.Protocol("/otternet/priceRequest", streams.Limits{
+	Timeout:           30 * time.Second,
+	MaxMessageSize:    1 << 10,
+	MaxStreams:        128,
+	MaxStreamsPerPeer: 16,
+})
+var CatalogRequestProtocol = streams.Protocol("/otternet/catalogRequest", streams.Limits{
+	Timeout:        time.Minute,
+	MaxMessageSize: 1 << 10,
+})
+
+// Words that may follow the hash on a file request line for bundles
+const (
+	BundleManifestRequest = "manifest" // send the metadata and manifest only
+	BundleMemberRequest   = "member"   // followed by a member path; send just that member
+)
 
 type FormData struct {
 	WalletID   string  `json:"walletID"`
@@ -42,16 +70,27 @@ type WalletAddress struct {
 
 // Handles incoming file requests using a stream handler
 func HandleFileRequests(h host.Host) {
-	h.SetStreamHandler(FileRequestProtocol, func(s network.Stream) {
+	streams.Handle(h, FileRequestProtocol, func(s network.Stream) {
 		defer s.Close()
 
-		// Read the incoming file hash from the stream
+		// Read the incoming request: a file hash, optionally followed by "manifest" or "member <path>" for bundles. A
+		// member path may contain spaces, so it is everything after the second separator
 		r := bufio.NewReader(s)
-		fileHash, err := r.ReadString('\n')
+		line, err := r.ReadString('\n')
 		if err != nil {
 			log.Printf("Error reading from stream: %v", err)
 		}
-		fileHash = strings.TrimSpace(fileHash)
+		fields := strings.SplitN(strings.TrimRight(line, "\r\n"), " ", 3)
+		if fields[0] == "" {
+			return
+		}
+		fileHash := fields[0]
+		manifestOnly := len(fields) == 2 && fields[1] == BundleManifestRequest
+		memberRequest := len(fields) == 3 && fields[1] == BundleMemberRequest
+		if len(fields) > 1 && !manifestOnly && !memberRequest {
+			blocklist.ReportViolation(s.Conn().RemotePeer(), "malformed file request")
+			return
+		}
 
 		// Check if the file hash exists in our local file.json and retrieve the file path from metadata
 		metadata, err := getMetadataByHash(fileHash)
@@ -60,10 +99,64 @@ func HandleFileRequests(h host.Host) {
 			return
 		}
 
-		// Get the file from the file path in metadata
-		file, err := os.Open(metadata.FilePath)
-		if err != nil {
-			log.Printf("Error opening file: %v", err)
+		// Bundles are served whole or one member at a time; a member is priced at its share of the bundle
+		var manifest *bundle.Manifest
+		var members []bundle.Member
+		if metadata.BundleMode {
+			manifest, err = bundle.Load(fileHash)
+			if err != nil {
+				log.Printf("Error loading bundle manifest: %v", err)
+				return
+			}
+			members = manifest.Members
+			metadata.FileSize = manifest.TotalSize()
+			if memberRequest {
+				member, ok := manifest.Member(fields[2])
+				if !ok {
+					log.Printf("Bundle %s has no member %s", fileHash, fields[2])
+					return
+				}
+				members = []bundle.Member{member}
+				metadata.Price = manifest.MemberPrice(metadata.Price, member)
+				metadata.FileSize = member.Size
+				metadata.FileName = path.Base(member.Path)
+			}
+		} else if manifestOnly {
+			log.Printf("Manifest requested for %s, which is not a bundle", fileHash)
+			return
+		}
+
+		// Wait for a free serve slot; busy or off-schedule providers tell the requester when to come back
+		remote := s.Conn().RemotePeer()
+		var peerRate *bandwidth.Limiter
+		if !manifestOnly {
+			var release func()
+			var refused *ServeStatus
+			peerRate, release, refused = acquireServeSlot(remote)
+			if refused != nil {
+				log.Printf("Refusing file request from %s: %s", remote, refused.Reason)
+				json.NewEncoder(s).Encode(refused)
+				return
+			}
+			defer release()
+		}
+
+		// Get the file from the file path in metadata, or stream the requested bundle members
+		var content io.ReadCloser
+		if metadata.BundleMode {
+			content = bundle.NewReader(members)
+		} else if !manifestOnly {
+			file, err := os.Open(metadata.FilePath)
+			if err != nil {
+				// the integrity scan withdraws the entry; until then tell the requester instead of sending nothing
+				log.Printf("Error opening file: %v", err)
+				json.NewEncoder(s).Encode(ServeStatus{Error: "file is no longer available"})
+				return
+			}
+			content = file
+		}
+		if content != nil {
+			defer content.Close()
 		}
 
 		// Send the file metadata back to the requester
@@ -79,7 +172,7 @@ func HandleFileRequests(h host.Host) {
 		}
 
 		// Send the wallet address back to the requester
-		wallet := WalletAddress{WalletID: global_wallet.WalletAddr + "\n"}
+		wallet := WalletAddress{WalletID: strings.TrimSpace(global_wallet.WalletAddr)}
 		var walletBytes []byte
 		walletBytes, err = json.Marshal(wallet)
 		if err != nil {
@@ -91,11 +184,34 @@ func HandleFileRequests(h host.Host) {
 			log.Printf("Error sending wallet address: %v", err)
 		}
 
-		//Send the file back to the requester
-		_, err = io.Copy(s, file)
+		// Bundles are followed by their manifest so the requester can rebuild the tree
+		if manifest != nil {
+			if err := json.NewEncoder(s).Encode(manifest.Public()); err != nil {
+				log.Printf("Error sending bundle manifest: %v", err)
+				return
+			}
+		}
+		if manifestOnly {
+			return
+		}
+
+		//Send the file back to the requester, throttled by the global and per-peer upload limits
+		serve := &activeServe{PeerID: remote.String(), FileHash: fileHash, FileName: metadata.FileName, StartedAt: time.Now().Format(time.RFC3339)}
+		untrack := trackServe(serve)
+		out := bandwidth.NewWriter(context.Background(), io.MultiWriter(s, countingWriter{serve}), uploadLimiter, peerRate)
+		sent, err := io.Copy(out, content)
+		untrack()
 		if err != nil {
 			log.Printf("Error sending file: %v", err)
 		}
+		events.Publish(events.TopicUpload, "served", map[string]interface{}{
+			"peerID":    remote.String(),
+			"fileHash":  fileHash,
+			"fileName":  metadata.FileName,
+			"bytesSent": sent,
+			"price":     metadata.Price,
+			"complete":  err == nil,
+		})
 			// Define file paths accordingly
 		bytesFilePath := "./api/statistics/statistics.txt"
 
@@ -149,7 +265,7 @@ func getMetadataByHash(fileHash string) (FormData, error) {
 
 // Handles incoming price requests using a stream handler
 func HandlePriceRequests(h host.Host) {
-	h.SetStreamHandler(PriceRequestProtocol, func(s network.Stream) {
+	streams.Handle(h, PriceRequestProtocol, func(s network.Stream) {
 		defer s.Close()
 
 		// Read the incoming file hash from the stream
@@ -177,7 +293,7 @@ func HandlePriceRequests(h host.Host) {
 }
 
 func HandleCatalogRequests(h host.Host) {
-	h.SetStreamHandler(CatalogRequestProtocol, func(s network.Stream) {
+	streams.Handle(h, CatalogRequestProtocol, func(s network.Stream) {
 		defer s.Close()
 		fmt.Print("Catalog request received\n")
 		// send files.json to requester
@@ -220,61 +336,6 @@ func HandleCatalogRequests(h host.Host) {
 	})
 }
 
-// Handles incoming isOtternet requesets. If receive "otternet1" then send "otternet2"
-func HandleOtternetPeersRequests(h host.Host) {
-	h.SetStreamHandler(OtternetPeersProtocol, func(s network.Stream) {
-		defer s.Close()
-		walletAddr := global_wallet.WalletAddr
-		r := bufio.NewReader(s)
-		fmt.Printf("Otternet peers request received\n")
-
-		secretMessage, err := r.ReadString('\n')
-		if err != nil {
-			log.Printf("Error reading from stream: %v", err)
-			return
-		}
-		fmt.Printf("Secret message: %v\n", secretMessage)
-
-		secretMessage = strings.ToLower(strings.TrimSpace(secretMessage))
-
-		if secretMessage != "otternet1" {
-			log.Printf("Invalid secret message: %v", secretMessage)
-			return
-		}
-
-		returnMessage := ""
-
-		file, err := os.Open("./api/files/files.json")
-		if err != nil {
-			log.Printf("Error opening files.json: %v", err)
-		} else {
-			defer file.Close()
-			var files []FormData
-			decoder := json.NewDecoder(file)
-			err = decoder.Decode(&files)
-			if err != nil {
-				log.Printf("Error decoding JSON: %v", err)
-				return
-			}
-			for _, fileData := range files {
-				if fileData.WalletID == walletAddr {
-					returnMessage = "otternet2\n"
-					break
-				}
-			}
-		}
-
-		fmt.Printf("Generated eturn message: %v\n", returnMessage)
-
-		// Send the appropriate return message back through the stream
-		_, err = s.Write([]byte(returnMessage))
-		if err != nil {
-			log.Printf("Error sending message: %v", err)
-		}
-		fmt.Printf("Sent return message: %v\n", returnMessage)
-	})
-}
-
 // Function to handle file operations
 // func handleFile(filePath string) ([]byte, error) {
 // 	// Ensure the directory exists
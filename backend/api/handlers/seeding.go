@@ -0,0 +1,262 @@
+package handlers
+
+import (
+	"Otternet/backend/api/bandwidth"
+	"Otternet/backend/config"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// ServeStatus is sent instead of file metadata when a provider will not serve a request. A busy provider asks the
+// requester to try again after RetryAfter seconds; Error means the file cannot be served at all
+type ServeStatus struct {
+	Busy       bool   `json:"busy"`
+	Reason     string `json:"reason"`
+	RetryAfter int    `json:"retryAfter"`
+	Error      string `json:"error,omitempty"`
+}
+
+const (
+	busyRetryAfter = 30 // seconds suggested to peers turned away because every serve slot is taken
+	slotPollEvery  = 250 * time.Millisecond
+)
+
+// SeedingSettings controls how much this node uploads. Rates are bytes per second; 0 means unlimited
+type SeedingSettings struct {
+	RateLimit     int64    `json:"rateLimit"`
+	PeerRateLimit int64    `json:"peerRateLimit"`
+	MaxConcurrent int      `json:"maxConcurrent"`
+	WaitSeconds   int      `json:"waitSeconds"` // how long a request waits for a free slot
+	Schedule      []string `json:"schedule"`    // local time windows such as "22:00-06:00"; empty means always
+}
+
+type seedWindow struct {
+	start, end int // minutes after midnight; end < start wraps past midnight
+}
+
+// activeServe is a file transfer currently being served
+type activeServe struct {
+	PeerID    string `json:"peerID"`
+	FileHash  string `json:"fileHash"`
+	FileName  string `json:"fileName"`
+	BytesSent int64  `json:"bytesSent"`
+	StartedAt string `json:"startedAt"`
+}
+
+type peerLimiter struct {
+	limiter *bandwidth.Limiter
+	active  int
+}
+
+var (
+	seedingMutex  = &sync.Mutex{}
+	seeding       = defaultSeedingSettings()
+	seedWindows   []seedWindow
+	uploadLimiter = bandwidth.NewLimiter(seeding.RateLimit)
+	peerLimiters  = make(map[peer.ID]*peerLimiter)
+	servesInUse   int
+	activeServes  = make(map[*activeServe]struct{})
+)
+
+func defaultSeedingSettings() SeedingSettings {
+	cfg := config.NewConfig()
+	return SeedingSettings{
+		RateLimit:     cfg.UploadRateLimit,
+		PeerRateLimit: cfg.PeerUploadRateLimit,
+		MaxConcurrent: cfg.MaxConcurrentServes,
+		WaitSeconds:   int(cfg.ServeSlotWait / time.Second),
+		Schedule:      cfg.SeedingSchedule,
+	}
+}
+
+func init() {
+	windows, err := parseSchedule(seeding.Schedule)
+	if err != nil {
+		fmt.Printf("Ignoring seeding schedule: %v\n", err)
+		seeding.Schedule = nil
+	}
+	seedWindows = windows
+}
+
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func parseSchedule(schedule []string) ([]seedWindow, error) {
+	var windows []seedWindow
+	for _, entry := range schedule {
+		start, end, ok := strings.Cut(entry, "-")
+		if !ok {
+			return nil, fmt.Errorf("invalid window %q, expected HH:MM-HH:MM", entry)
+		}
+		var w seedWindow
+		var err error
+		if w.start, err = parseClock(start); err != nil {
+			return nil, err
+		}
+		if w.end, err = parseClock(end); err != nil {
+			return nil, err
+		}
+		windows = append(windows, w)
+	}
+	return windows, nil
+}
+
+func (w seedWindow) contains(minute int) bool {
+	if w.start <= w.end {
+		return minute >= w.start && minute < w.end
+	}
+	return minute >= w.start || minute < w.end
+}
+
+// Reports whether the schedule allows seeding at now and, if not, how long until it does. Caller holds seedingMutex
+func seedingOpenLocked(now time.Time) (bool, time.Duration) {
+	if len(seedWindows) == 0 {
+		return true, 0
+	}
+	minute := now.Hour()*60 + now.Minute()
+	for _, w := range seedWindows {
+		if w.contains(minute) {
+			return true, 0
+		}
+	}
+	wait := 24 * time.Hour
+	for _, w := range seedWindows {
+		until := time.Duration((w.start-minute+24*60)%(24*60)) * time.Minute
+		if until < wait {
+			wait = until
+		}
+	}
+	return false, wait - time.Duration(now.Second())*time.Second
+}
+
+// Takes a serve slot for a transfer to p, waiting up to the configured time for one to free up. The returned
+// release function must be called when the transfer ends. A non-nil ServeStatus means the request is refused
+func acquireServeSlot(p peer.ID) (*bandwidth.Limiter, func(), *ServeStatus) {
+	seedingMutex.Lock()
+	open, wait := seedingOpenLocked(time.Now())
+	waitFor := time.Duration(seeding.WaitSeconds) * time.Second
+	seedingMutex.Unlock()
+	if !open {
+		return nil, nil, &ServeStatus{Busy: true, Reason: "outside seeding hours", RetryAfter: int(wait.Seconds()) + 1}
+	}
+
+	deadline := time.Now().Add(waitFor)
+	for {
+		seedingMutex.Lock()
+		if servesInUse < seeding.MaxConcurrent {
+			servesInUse++
+			pl, ok := peerLimiters[p]
+			if !ok {
+				pl = &peerLimiter{limiter: bandwidth.NewLimiter(seeding.PeerRateLimit)}
+				peerLimiters[p] = pl
+			}
+			pl.active++
+			seedingMutex.Unlock()
+			var once sync.Once
+			return pl.limiter, func() {
+				once.Do(func() {
+					seedingMutex.Lock()
+					defer seedingMutex.Unlock()
+					servesInUse--
+					if pl.active--; pl.active == 0 {
+						delete(peerLimiters, p)
+					}
+				})
+			}, nil
+		}
+		seedingMutex.Unlock()
+		if time.Now().After(deadline) {
+			return nil, nil, &ServeStatus{Busy: true, Reason: "all serve slots are in use", RetryAfter: busyRetryAfter}
+		}
+		time.Sleep(slotPollEvery)
+	}
+}
+
+func trackServe(serve *activeServe) func() {
+	seedingMutex.Lock()
+	activeServes[serve] = struct{}{}
+	seedingMutex.Unlock()
+	return func() {
+		seedingMutex.Lock()
+		delete(activeServes, serve)
+		seedingMutex.Unlock()
+	}
+}
+
+// countingWriter records bytes sent for an active serve
+type countingWriter struct {
+	serve *activeServe
+}
+
+func (c countingWriter) Write(b []byte) (int, error) {
+	seedingMutex.Lock()
+	c.serve.BytesSent += int64(len(b))
+	seedingMutex.Unlock()
+	return len(b), nil
+}
+
+func GetSeedingSettings(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	seedingMutex.Lock()
+	defer seedingMutex.Unlock()
+	open, wait := seedingOpenLocked(time.Now())
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"settings":      seeding,
+		"seedingNow":    open,
+		"opensInSecs":   int(wait.Seconds()),
+		"activeServes":  servesInUse,
+		"peersInFlight": len(peerLimiters),
+	})
+}
+
+// SetSeedingSettings replaces the upload limits and schedule. Transfers in progress pick up new rates immediately
+func SetSeedingSettings(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var settings SeedingSettings
+	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	if settings.RateLimit < 0 || settings.PeerRateLimit < 0 || settings.MaxConcurrent < 1 || settings.WaitSeconds < 0 {
+		http.Error(w, "Rates must not be negative and 'maxConcurrent' must be at least 1", http.StatusBadRequest)
+		return
+	}
+	windows, err := parseSchedule(settings.Schedule)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	seedingMutex.Lock()
+	seeding = settings
+	seedWindows = windows
+	for _, pl := range peerLimiters {
+		pl.limiter.SetRate(settings.PeerRateLimit)
+	}
+	seedingMutex.Unlock()
+	uploadLimiter.SetRate(settings.RateLimit)
+	json.NewEncoder(w).Encode(settings)
+}
+
+// GetActiveServes lists the transfers this node is currently serving
+func GetActiveServes(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	seedingMutex.Lock()
+	serves := []activeServe{}
+	for serve := range activeServes {
+		serves = append(serves, *serve)
+	}
+	seedingMutex.Unlock()
+	json.NewEncoder(w).Encode(serves)
+}
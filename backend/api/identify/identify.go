@@ -0,0 +1,325 @@
+package identify
+
+import (
+	"Otternet/backend/api/blocklist"
+	"Otternet/backend/api/handlers"
+	"Otternet/backend/api/proxy"
+	"Otternet/backend/api/streams"
+	"Otternet/backend/config"
+	"Otternet/backend/global"
+	"Otternet/backend/global_wallet"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// IdentifyProtocol answers every stream with this node's SignedDescription
+var IdentifyProtocol = streams.Protocol("/otternet/identify/1.0.0", streams.Limits{
+	Timeout:        streamTimeout,
+	MaxMessageSize: 1 << 10,
+})
+
+// ProtocolVersion is the Otternet protocol version reported in descriptions
+const ProtocolVersion = 1
+
+// Services a peer can offer
+const (
+	ServiceFileProvider = "file-provider"
+	ServiceProxy        = "proxy"
+	ServiceRelay        = "relay"
+)
+
+// Prepended to the description before signing so the signature cannot be replayed as another kind of message
+const signaturePrefix = "otternet-identify:"
+
+const (
+	maxDescriptionSize = 64 << 10
+	streamTimeout      = 15 * time.Second
+	cacheTTL           = time.Minute
+	maxDescriptionAge  = 10 * time.Minute // older descriptions, or ones dated this far ahead, are treated as replays
+)
+
+// Protocol a host handles while it can relay circuits for others
+const relayHopProtocol = "/libp2p/circuit/relay/0.2.0/hop"
+
+// PriceRange is the cheapest and most expensive of a peer's shared files
+type PriceRange struct {
+	Min float64 `json:"min"`
+	Max float64 `json:"max"`
+}
+
+// Description is what a peer says about itself
+type Description struct {
+	ProtocolVersion   int         `json:"protocolVersion"`
+	NetworkID         string      `json:"networkID"`
+	PeerID            string      `json:"peerID"`
+	WalletAddress     string      `json:"walletAddress"`
+	Services          []string    `json:"services"`
+	SharedFiles       int         `json:"sharedFiles"`
+	FilePrices        *PriceRange `json:"filePrices,omitempty"`
+	ProxyPricePerHour float64     `json:"proxyPricePerHour,omitempty"`
+	Timestamp         string      `json:"timestamp"`
+}
+
+// SignedDescription carries the exact description bytes that were signed with the peer's libp2p key
+type SignedDescription struct {
+	Description json.RawMessage `json:"description"`
+	Signature   []byte          `json:"signature"`
+}
+
+// HasService reports whether the peer offers service
+func (d *Description) HasService(service string) bool {
+	for _, s := range d.Services {
+		if s == service {
+			return true
+		}
+	}
+	return false
+}
+
+type cachedDescription struct {
+	description *Description
+	fetched     time.Time
+}
+
+var (
+	cacheMutex = &sync.Mutex{}
+	cache      = make(map[peer.ID]cachedDescription)
+)
+
+// Describes this node from its published files, proxy state and relay service
+func describeSelf(h host.Host) Description {
+	walletAddr := strings.TrimSpace(global_wallet.WalletAddr)
+	desc := Description{
+		ProtocolVersion: ProtocolVersion,
+		NetworkID:       config.NewConfig().NetworkID,
+		PeerID:          h.ID().String(),
+		WalletAddress:   walletAddr,
+		Services:        make([]string, 0),
+		Timestamp:       time.Now().Format(time.RFC3339),
+	}
+
+	var files []handlers.FormData
+	if data, err := os.ReadFile("./api/files/files.json"); err == nil {
+		if err := json.Unmarshal(data, &files); err != nil {
+			fmt.Printf("Identify: error decoding files.json: %v\n", err)
+		}
+	}
+	for _, file := range files {
+		if strings.TrimSpace(file.WalletID) != walletAddr {
+			continue
+		}
+		desc.SharedFiles++
+		if desc.FilePrices == nil {
+			desc.FilePrices = &PriceRange{Min: file.Price, Max: file.Price}
+		}
+		if file.Price < desc.FilePrices.Min {
+			desc.FilePrices.Min = file.Price
+		}
+		if file.Price > desc.FilePrices.Max {
+			desc.FilePrices.Max = file.Price
+		}
+	}
+	if desc.SharedFiles > 0 {
+		desc.Services = append(desc.Services, ServiceFileProvider)
+	}
+	if price, ok := proxy.SelfPricePerHour(); ok {
+		desc.Services = append(desc.Services, ServiceProxy)
+		desc.ProxyPricePerHour = price
+	}
+	for _, id := range h.Mux().Protocols() {
+		if id == relayHopProtocol {
+			desc.Services = append(desc.Services, ServiceRelay)
+			break
+		}
+	}
+	return desc
+}
+
+// Signs a description with the host's identity key, which is random and never leaves the node (see
+// dhtnode.LoadIdentity), so only the peer itself can produce the signature
+func sign(h host.Host, desc Description) (SignedDescription, error) {
+	raw, err := json.Marshal(desc)
+	if err != nil {
+		return SignedDescription{}, err
+	}
+	privKey := h.Peerstore().PrivKey(h.ID())
+	if privKey == nil {
+		return SignedDescription{}, fmt.Errorf("no private key for %s", h.ID())
+	}
+	signature, err := privKey.Sign(append([]byte(signaturePrefix), raw...))
+	if err != nil {
+		return SignedDescription{}, err
+	}
+	return SignedDescription{Description: raw, Signature: signature}, nil
+}
+
+// Returned for descriptions outside maxDescriptionAge, which may just mean the peer's clock is off
+var errStale = errors.New("description is stale")
+
+// Checks that signed was signed by the key behind id, describes id and is recent
+func verify(id peer.ID, signed SignedDescription) (*Description, error) {
+	pubKey, err := id.ExtractPublicKey()
+	if err != nil {
+		return nil, fmt.Errorf("cannot get public key of %s: %w", id, err)
+	}
+	ok, err := pubKey.Verify(append([]byte(signaturePrefix), signed.Description...), signed.Signature)
+	if err != nil || !ok {
+		return nil, fmt.Errorf("invalid signature from %s", id)
+	}
+	var desc Description
+	if err := json.Unmarshal(signed.Description, &desc); err != nil {
+		return nil, fmt.Errorf("invalid description from %s: %w", id, err)
+	}
+	if desc.PeerID != id.String() {
+		return nil, fmt.Errorf("description from %s is for %s", id, desc.PeerID)
+	}
+	desc.WalletAddress = strings.TrimSpace(desc.WalletAddress)
+	signedAt, err := time.Parse(time.RFC3339, desc.Timestamp)
+	if err != nil {
+		return nil, fmt.Errorf("description from %s has an invalid timestamp", id)
+	}
+	if age := time.Since(signedAt); age > maxDescriptionAge || age < -maxDescriptionAge {
+		return nil, fmt.Errorf("description from %s signed %s: %w", id, desc.Timestamp, errStale)
+	}
+	return &desc, nil
+}
+
+// HandleIdentifyRequests answers identify streams with this node's signed description
+func HandleIdentifyRequests(h host.Host) {
+	streams.Handle(h, IdentifyProtocol, func(s network.Stream) {
+		defer s.Close()
+		signed, err := sign(h, describeSelf(h))
+		if err != nil {
+			fmt.Printf("Identify: error signing description: %v\n", err)
+			s.Reset()
+			return
+		}
+		if err := json.NewEncoder(s).Encode(signed); err != nil {
+			fmt.Printf("Identify: error sending description: %v\n", err)
+		}
+	})
+}
+
+// Describe asks a peer for its signed description, reusing one fetched within the last minute
+func Describe(ctx context.Context, id peer.ID) (*Description, error) {
+	if global.DHTNode == nil {
+		return nil, fmt.Errorf("DHT node is not running")
+	}
+	cacheMutex.Lock()
+	cached, ok := cache[id]
+	cacheMutex.Unlock()
+	if ok && time.Since(cached.fetched) < cacheTTL {
+		return cached.description, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, streamTimeout)
+	defer cancel()
+	stream, err := global.DHTNode.Host.NewStream(ctx, id, IdentifyProtocol)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+	stream.SetDeadline(time.Now().Add(streamTimeout))
+	var signed SignedDescription
+	if err := json.NewDecoder(io.LimitReader(stream, maxDescriptionSize)).Decode(&signed); err != nil {
+		if blocklist.IsMalformed(err) {
+			blocklist.ReportViolation(id, "malformed identify reply")
+		}
+		stream.Reset()
+		return nil, fmt.Errorf("error reading description from %s: %w", id, err)
+	}
+	desc, err := verify(id, signed)
+	if err != nil {
+		if !errors.Is(err, errStale) {
+			blocklist.ReportViolation(id, err.Error())
+		}
+		return nil, err
+	}
+	now := time.Now()
+	cacheMutex.Lock()
+	// expired entries would be fetched again anyway, so drop them rather than keep every peer ever described
+	for other, entry := range cache {
+		if now.Sub(entry.fetched) >= cacheTTL {
+			delete(cache, other)
+		}
+	}
+	cache[id] = cachedDescription{description: desc, fetched: now}
+	cacheMutex.Unlock()
+	return desc, nil
+}
+
+// DescribeAll describes several peers concurrently and keeps the ones on this node's network. Peers that do not
+// answer are left out
+func DescribeAll(ctx context.Context, ids []peer.ID) []*Description {
+	networkID := config.NewConfig().NetworkID
+	results := make([]*Description, len(ids))
+	var wg sync.WaitGroup
+	limit := make(chan struct{}, 8)
+	for i, id := range ids {
+		wg.Add(1)
+		go func(i int, id peer.ID) {
+			defer wg.Done()
+			limit <- struct{}{}
+			defer func() { <-limit }()
+			desc, err := Describe(ctx, id)
+			if err != nil {
+				fmt.Printf("Identify: %s: %v\n", id, err)
+				return
+			}
+			if desc.NetworkID == networkID && desc.ProtocolVersion == ProtocolVersion {
+				results[i] = desc
+			}
+		}(i, id)
+	}
+	wg.Wait()
+	descriptions := make([]*Description, 0, len(ids))
+	for _, desc := range results {
+		if desc != nil {
+			descriptions = append(descriptions, desc)
+		}
+	}
+	return descriptions
+}
+
+// GetSelfDescription returns the description this node hands to other peers
+func GetSelfDescription(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if global.DHTNode == nil {
+		http.Error(w, "DHT node is not running", http.StatusServiceUnavailable)
+		return
+	}
+	json.NewEncoder(w).Encode(describeSelf(global.DHTNode.Host))
+}
+
+// GetPeerDescription asks one peer for its description, signed with the peer's identity key
+func GetPeerDescription(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if global.DHTNode == nil {
+		http.Error(w, "DHT node is not running", http.StatusServiceUnavailable)
+		return
+	}
+	id, err := peer.Decode(strings.TrimSpace(mux.Vars(r)["peerID"]))
+	if err != nil {
+		http.Error(w, "Invalid peer ID", http.StatusBadRequest)
+		return
+	}
+	desc, err := Describe(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	json.NewEncoder(w).Encode(desc)
+}
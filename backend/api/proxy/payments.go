@@ -0,0 +1,99 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// ProxyPayment records a payment made to a proxy node
+type ProxyPayment struct {
+	ProxyID   string  `json:"proxyID"`
+	TxID      string  `json:"txID"`
+	Amount    float64 `json:"amount"`
+	Status    string  `json:"status"` // "pending", "confirmed" or "failed"
+	Timestamp string  `json:"timestamp"`
+	UpdatedAt string  `json:"updatedAt"`
+}
+
+const paymentsFilePath = "./api/proxy/payments.json"
+
+var paymentsMutex = &sync.Mutex{}
+
+func readPayments() ([]ProxyPayment, error) {
+	payments := []ProxyPayment{}
+	data, err := os.ReadFile(paymentsFilePath)
+	if os.IsNotExist(err) {
+		return payments, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading proxy payments: %w", err)
+	}
+	if err := json.Unmarshal(data, &payments); err != nil {
+		return nil, fmt.Errorf("error unmarshalling proxy payments: %w", err)
+	}
+	return payments, nil
+}
+
+func writePayments(payments []ProxyPayment) error {
+	data, err := json.MarshalIndent(payments, "", " ")
+	if err != nil {
+		return fmt.Errorf("error marshalling proxy payments: %w", err)
+	}
+	return os.WriteFile(paymentsFilePath, data, 0644)
+}
+
+// UpdatePaymentStatus records or updates the payment txid made to proxyID. If replacedTxID is set the payment
+// previously recorded under that txid is moved to the new one (fee bumps)
+func UpdatePaymentStatus(proxyID string, txid string, replacedTxID string, amount float64, status string) error {
+	paymentsMutex.Lock()
+	defer paymentsMutex.Unlock()
+
+	payments, err := readPayments()
+	if err != nil {
+		return err
+	}
+	now := time.Now().Format(time.RFC3339)
+	found := false
+	for i, payment := range payments {
+		if payment.TxID == txid || (replacedTxID != "" && payment.TxID == replacedTxID) {
+			payments[i].TxID = txid
+			payments[i].Status = status
+			payments[i].UpdatedAt = now
+			found = true
+			break
+		}
+	}
+	if !found {
+		payments = append(payments, ProxyPayment{
+			ProxyID:   proxyID,
+			TxID:      txid,
+			Amount:    amount,
+			Status:    status,
+			Timestamp: now,
+			UpdatedAt: now,
+		})
+	}
+	return writePayments(payments)
+}
+
+// LoadPayments returns all recorded proxy payments
+func LoadPayments() ([]ProxyPayment, error) {
+	paymentsMutex.Lock()
+	defer paymentsMutex.Unlock()
+	return readPayments()
+}
+
+// GetProxyPayments returns all recorded proxy payments
+func GetProxyPayments(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	payments, err := LoadPayments()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(payments)
+}
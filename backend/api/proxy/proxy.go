@@ -1,6 +1,9 @@
 package proxy
 
 import (
+	"Otternet/backend/api/events"
+	"Otternet/backend/api/reputation"
+	"Otternet/backend/api/streams"
 	"Otternet/backend/global"
 	"bufio"
 
@@ -15,12 +18,13 @@ import (
 
 	//"net"
 	"net/http"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/elazarl/goproxy"
 	"github.com/libp2p/go-libp2p/core/network"
-	"github.com/libp2p/go-libp2p/core/protocol"
 
 	//"github.com/multiformats/go-multiaddr"
 	"github.com/gorilla/mux"
@@ -40,9 +44,18 @@ var (
 
 // Constants
 var ProxyProviderHash = "proxy-louis-x9"
-var proxyConnectProtocol = protocol.ID("otternet/proxy/connect")
-var proxyDisconnectProtocol = protocol.ID("otternet/proxy/disconnect")
-var activeProxyProtocol = protocol.ID("/otternet/activeProxy")
+var proxyConnectProtocol = streams.Protocol("otternet/proxy/connect", streams.Limits{
+	Timeout:        30 * time.Second,
+	MaxMessageSize: 4 << 10,
+})
+var proxyDisconnectProtocol = streams.Protocol("otternet/proxy/disconnect", streams.Limits{
+	Timeout:        30 * time.Second,
+	MaxMessageSize: 4 << 10,
+})
+var activeProxyProtocol = streams.Protocol("/otternet/activeProxy", streams.Limits{
+	Timeout:        10 * time.Second,
+	MaxMessageSize: 1 << 10,
+})
 
 // ProxyNode represents a proxy node's details
 type ProxyNode struct {
@@ -53,6 +66,16 @@ type ProxyNode struct {
 	Status       string  `json:"status"` // "available", "busy"
 }
 
+// ProxyProvideKey is the key proxy nodes provide in the DHT: the CID of the hashed ProxyProviderHash
+func ProxyProvideKey() (string, error) {
+	hash := sha256.Sum256([]byte(ProxyProviderHash))
+	mh, err := multihash.EncodeName(hash[:], "sha2-256")
+	if err != nil {
+		return "", fmt.Errorf("failed to create multihash: %v", err)
+	}
+	return cid.NewCidV1(cid.Raw, mh).String(), nil
+}
+
 // AdvertiseSelfAsNode advertises the current server as a provider for the ProxyProviderHash
 func AdvertiseSelfAsNode(ctx context.Context, ip, port string, pricePerHour float64) error {
 	fmt.Printf("Advertising as proxy node. Host ID: %s", global.DHTNode.Host.ID())
@@ -60,16 +83,12 @@ func AdvertiseSelfAsNode(ctx context.Context, ip, port string, pricePerHour floa
 		return fmt.Errorf("DHT node is not initialized")
 	}
 
-	// Hash the ProxyProviderHash to create a CID
-	hash := sha256.Sum256([]byte(ProxyProviderHash))
-	mh, err := multihash.EncodeName(hash[:], "sha2-256")
+	// Announce this node as a provider for the CID in the DHT
+	c, err := ProxyProvideKey()
 	if err != nil {
-		return fmt.Errorf("failed to create multihash: %v", err)
+		return err
 	}
-	c := cid.NewCidV1(cid.Raw, mh)
-
-	// Announce this node as a provider for the CID in the DHT
-	err = global.DHTNode.ProvideKey(c.String())
+	err = global.DHTNode.ProvideKey(c)
 	if err != nil {
 		return fmt.Errorf("failed to advertise proxy node in DHT: %v", err)
 	}
@@ -107,6 +126,21 @@ func AdvertiseSelfAsNode(ctx context.Context, ip, port string, pricePerHour floa
 	return nil
 }
 
+// SelfPricePerHour returns the price this node advertises as a proxy, if it is serving as one
+func SelfPricePerHour() (float64, bool) {
+	if global.DHTNode == nil || !global.ActiveProxy {
+		return 0, false
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for _, node := range proxyNodes {
+		if node.ID == global.DHTNode.Host.ID().String() {
+			return node.PricePerHour, true
+		}
+	}
+	return 0, false
+}
+
 func GetPublicIP() (string, error) {
 	resp, err := http.Get("https://api.ipify.org?format=text") // API to fetch public IP
 	if err != nil {
@@ -307,6 +341,8 @@ func FetchAvailableProxies(ctx context.Context) ([]ProxyNode, error) {
 	}
 	mu.Unlock()
 
+	// Most reliable proxies first
+	sort.SliceStable(proxyList, func(i, j int) bool { return reputation.Less(proxyList[i].ID, proxyList[j].ID) })
 	return proxyList, nil
 }
 
@@ -527,7 +563,7 @@ func GetClientCount(w http.ResponseWriter, r *http.Request) {
 
 // LIBP2P SECTION
 func StartLibp2pStreamHandler(host host.Host) {
-	host.SetStreamHandler(proxyConnectProtocol, func(s network.Stream) {
+	streams.Handle(host, proxyConnectProtocol, func(s network.Stream) {
 		defer s.Close()
 
 		var req struct {
@@ -571,7 +607,7 @@ func RegisterProxyHandlers(h host.Host) {
 
 // Handles proxy connection requests
 func HandleProxyConnectRequests(h host.Host) {
-	h.SetStreamHandler(proxyConnectProtocol, func(s network.Stream) {
+	streams.Handle(h, proxyConnectProtocol, func(s network.Stream) {
 		defer s.Close()
 
 		var req struct {
@@ -596,6 +632,10 @@ func HandleProxyConnectRequests(h host.Host) {
 		mu.Unlock()
 
 		log.Printf("Client %s authorized via proxy connect stream.", req.ClientAddr)
+		events.Publish(events.TopicProxy, "client_joined", map[string]string{
+			"clientAddr": req.ClientAddr,
+			"peerID":     s.Conn().RemotePeer().String(),
+		})
 
 		// Send a response back to the client
 		response := map[string]string{"message": "Client authorized successfully"}
@@ -606,7 +646,7 @@ func HandleProxyConnectRequests(h host.Host) {
 }
 
 func HandleActiveProxyRequests(h host.Host) {
-	h.SetStreamHandler(activeProxyProtocol, func(s network.Stream) {
+	streams.Handle(h, activeProxyProtocol, func(s network.Stream) {
 		defer s.Close()
 
 		fmt.Println("Received Request!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!")
@@ -624,7 +664,7 @@ func HandleActiveProxyRequests(h host.Host) {
 
 // Handles proxy disconnection requests
 func HandleProxyDisconnectRequests(h host.Host) {
-	h.SetStreamHandler(proxyDisconnectProtocol, func(s network.Stream) {
+	streams.Handle(h, proxyDisconnectProtocol, func(s network.Stream) {
 		defer s.Close()
 
 		var req struct {
@@ -648,6 +688,10 @@ func HandleProxyDisconnectRequests(h host.Host) {
 		if _, exists := authorizedClients[req.ClientAddr]; exists {
 			delete(authorizedClients, req.ClientAddr)
 			log.Printf("Client %s disconnected and removed from authorized list.", req.ClientAddr)
+			events.Publish(events.TopicProxy, "client_left", map[string]string{
+				"clientAddr": req.ClientAddr,
+				"peerID":     s.Conn().RemotePeer().String(),
+			})
 		} else {
 			log.Printf("Client %s not found in authorized list; ignoring request.", req.ClientAddr)
 		}
@@ -675,15 +719,25 @@ func GetActiveProxies(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	activeProxies := []ProxyNode{}
+	// a proxy is listed once per address; count each probe once in its uptime
+	probedProxies := make(map[string]bool)
+	recordProbe := func(proxyID string, outcome string) {
+		if !probedProxies[proxyID] {
+			probedProxies[proxyID] = true
+			reputation.Record(proxyID, outcome)
+		}
+	}
 	for _, proxy := range proxies {
 		proxyID, err := peer.Decode(proxy.ID)
 		if err != nil {
 			fmt.Printf("Failed to decode peerID\n")
 			continue
 		}
+		probed := time.Now()
 		stream, err := global.DHTNode.Host.NewStream(global.DHTNode.Ctx, proxyID, activeProxyProtocol)
 		if err != nil {
 			log.Printf("Failed to open stream to %s: %v", proxy.ID, err)
+			recordProbe(proxy.ID, reputation.OutcomeProxyDown)
 			continue
 		}
 		defer stream.Close()
@@ -696,9 +750,12 @@ func GetActiveProxies(w http.ResponseWriter, r *http.Request) {
 		response, err := r.ReadString('\n')
 		if err != nil {
 			log.Printf("Failed to read response from %s: %v", proxy.ID, err)
+			recordProbe(proxy.ID, reputation.OutcomeProxyDown)
 			continue
 		}
 		if response == "Proxy is active\n" {
+			recordProbe(proxy.ID, reputation.OutcomeProxyUp)
+			reputation.RecordLatency(proxy.ID, time.Since(probed))
 			activeProxies = append(activeProxies, proxy)
 		}
 	}
@@ -0,0 +1,332 @@
+package reprovider
+
+import (
+	"Otternet/backend/api/files"
+	"Otternet/backend/api/proxy"
+	"Otternet/backend/config"
+	"Otternet/backend/global"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const statusFilePath = "./api/reprovider/reprovider.json"
+
+const (
+	KindFile  = "file"
+	KindProxy = "proxy"
+)
+
+// KeyStatus is the provide history of one key
+type KeyStatus struct {
+	Key         string `json:"key"`
+	Kind        string `json:"kind"` // KindFile or KindProxy
+	LastAttempt string `json:"lastAttempt,omitempty"`
+	LastSuccess string `json:"lastSuccess,omitempty"`
+	LastError   string `json:"lastError,omitempty"`
+	DurationMs  int64  `json:"durationMs"` // of the last successful provide
+	Successes   int    `json:"successes"`
+	Failures    int    `json:"failures"`
+}
+
+// RunSummary describes one pass over every key
+type RunSummary struct {
+	Started  string `json:"started"`
+	Finished string `json:"finished,omitempty"`
+	Keys     int    `json:"keys"`
+	Provided int    `json:"provided"`
+	Failed   int    `json:"failed"`
+}
+
+type settings struct {
+	IntervalMinutes int `json:"intervalMinutes"`
+	BatchSize       int `json:"batchSize"`
+}
+
+type state struct {
+	Settings settings              `json:"settings"`
+	LastRun  *RunSummary           `json:"lastRun,omitempty"`
+	Keys     map[string]*KeyStatus `json:"keys"`
+}
+
+type providable struct {
+	key  string
+	kind string
+}
+
+var (
+	mutex   = &sync.Mutex{}
+	current state
+	running bool
+	wake    = make(chan struct{}, 1)
+)
+
+func init() {
+	loadLocked()
+}
+
+// Replaces current with the status file. Caller holds mutex, except during init
+func loadLocked() {
+	cfg := config.NewConfig()
+	current = state{
+		Settings: settings{IntervalMinutes: int(cfg.ReprovideInterval / time.Minute), BatchSize: cfg.ReprovideBatchSize},
+		Keys:     make(map[string]*KeyStatus),
+	}
+	data, err := os.ReadFile(statusFilePath)
+	if err != nil {
+		return
+	}
+	var saved state
+	if err := json.Unmarshal(data, &saved); err != nil {
+		fmt.Printf("Reprovider: ignoring unreadable status file: %v\n", err)
+		return
+	}
+	if saved.Keys != nil {
+		current.Keys = saved.Keys
+	}
+	current.LastRun = saved.LastRun
+	if saved.Settings.IntervalMinutes > 0 && saved.Settings.BatchSize > 0 {
+		current.Settings = saved.Settings
+	}
+}
+
+// Reload rereads the status file, e.g. after a backup restored it
+func Reload() {
+	mutex.Lock()
+	defer mutex.Unlock()
+	loadLocked()
+}
+
+// Caller holds mutex
+func saveLocked() {
+	data, err := json.MarshalIndent(current, "", " ")
+	if err != nil {
+		fmt.Printf("Reprovider: error marshalling status: %v\n", err)
+		return
+	}
+	if err := os.WriteFile(statusFilePath, data, 0644); err != nil {
+		fmt.Printf("Reprovider: error writing status: %v\n", err)
+	}
+}
+
+// Every key this node should be providing: all published files, plus the proxy key while serving as a proxy
+func activeKeys() ([]providable, error) {
+	entries, err := files.LoadFiles()
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]providable, 0, len(entries)+1)
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		if entry.FileHash == "" || seen[entry.FileHash] {
+			continue
+		}
+		seen[entry.FileHash] = true
+		keys = append(keys, providable{key: entry.FileHash, kind: KindFile})
+	}
+	if global.ActiveProxy {
+		proxyKey, err := proxy.ProxyProvideKey()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, providable{key: proxyKey, kind: KindProxy})
+	}
+	return keys, nil
+}
+
+func provideOne(ctx context.Context, cfg *config.Config, p providable) error {
+	node := global.DHTNode
+	if node == nil {
+		return fmt.Errorf("DHT node is not running")
+	}
+	keyCtx, cancel := context.WithTimeout(ctx, cfg.ReprovideTimeout)
+	defer cancel()
+	start := time.Now()
+	err := node.ProvideKeyContext(keyCtx, p.key)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	status, ok := current.Keys[p.key]
+	if !ok {
+		status = &KeyStatus{Key: p.key, Kind: p.kind}
+		current.Keys[p.key] = status
+	}
+	status.LastAttempt = time.Now().Format(time.RFC3339)
+	if err != nil {
+		status.LastError = err.Error()
+		status.Failures++
+		return err
+	}
+	status.LastSuccess = status.LastAttempt
+	status.LastError = ""
+	status.DurationMs = time.Since(start).Milliseconds()
+	status.Successes++
+	return nil
+}
+
+// Provides keys batchSize at a time and returns those that failed
+func provideBatches(ctx context.Context, cfg *config.Config, keys []providable, batchSize int) []providable {
+	var failed []providable
+	var failedMutex sync.Mutex
+	for start := 0; start < len(keys); start += batchSize {
+		if ctx.Err() != nil {
+			return append(failed, keys[start:]...)
+		}
+		end := start + batchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+		var wg sync.WaitGroup
+		for _, p := range keys[start:end] {
+			wg.Add(1)
+			go func(p providable) {
+				defer wg.Done()
+				if err := provideOne(ctx, cfg, p); err != nil {
+					failedMutex.Lock()
+					failed = append(failed, p)
+					failedMutex.Unlock()
+				}
+			}(p)
+		}
+		wg.Wait()
+	}
+	return failed
+}
+
+// RunOnce announces every active key, retrying failures, and records the outcome
+func RunOnce(ctx context.Context) error {
+	cfg := config.NewConfig()
+	mutex.Lock()
+	if running {
+		mutex.Unlock()
+		return fmt.Errorf("a reprovide run is already in progress")
+	}
+	running = true
+	batchSize := current.Settings.BatchSize
+	mutex.Unlock()
+	defer func() {
+		mutex.Lock()
+		running = false
+		mutex.Unlock()
+	}()
+
+	keys, err := activeKeys()
+	if err != nil {
+		return err
+	}
+	summary := &RunSummary{Started: time.Now().Format(time.RFC3339), Keys: len(keys)}
+
+	failed := provideBatches(ctx, cfg, keys, batchSize)
+	for attempt := 0; attempt < cfg.ReprovideRetries && len(failed) > 0; attempt++ {
+		select {
+		case <-ctx.Done():
+		case <-time.After(cfg.ReprovideRetryDelay):
+			failed = provideBatches(ctx, cfg, failed, batchSize)
+		}
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	summary.Failed = len(failed)
+	summary.Provided = len(keys) - len(failed)
+	summary.Finished = time.Now().Format(time.RFC3339)
+	current.LastRun = summary
+	// drop keys that are no longer published
+	active := make(map[string]bool, len(keys))
+	for _, p := range keys {
+		active[p.key] = true
+	}
+	for key := range current.Keys {
+		if !active[key] {
+			delete(current.Keys, key)
+		}
+	}
+	saveLocked()
+	fmt.Printf("Reprovider: provided %d of %d keys\n", summary.Provided, summary.Keys)
+	return nil
+}
+
+// Run reprovides on the configured interval. A run also starts whenever the DHT node comes up, since a node that was
+// offline may have let its provider records expire
+func Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	var lastRun time.Time
+	lastNode := global.DHTNode
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-wake:
+			lastRun = time.Time{}
+		}
+		node := global.DHTNode
+		if node == nil {
+			lastNode = nil
+			continue
+		}
+		mutex.Lock()
+		interval := time.Duration(current.Settings.IntervalMinutes) * time.Minute
+		mutex.Unlock()
+		if node == lastNode && time.Since(lastRun) < interval {
+			continue
+		}
+		lastNode = node
+		lastRun = time.Now()
+		if err := RunOnce(ctx); err != nil {
+			fmt.Printf("Reprovider: %v\n", err)
+		}
+	}
+}
+
+// GetStatus reports the settings, the last run and the last successful provide of every key
+func GetStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	mutex.Lock()
+	defer mutex.Unlock()
+	keys := make([]*KeyStatus, 0, len(current.Keys))
+	for _, status := range current.Keys {
+		keys = append(keys, status)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"settings": current.Settings,
+		"running":  running,
+		"lastRun":  current.LastRun,
+		"keys":     keys,
+	})
+}
+
+// TriggerRun starts a reprovide run now
+func TriggerRun(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if global.DHTNode == nil {
+		http.Error(w, "DHT node is not running", http.StatusServiceUnavailable)
+		return
+	}
+	select {
+	case wake <- struct{}{}:
+	default:
+	}
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{"status": "run scheduled"})
+}
+
+// SetSettings changes the interval and batch size. Body: {"intervalMinutes", "batchSize"}
+func SetSettings(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var req settings
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.IntervalMinutes < 1 || req.BatchSize < 1 {
+		http.Error(w, "Invalid JSON body; 'intervalMinutes' and 'batchSize' must be at least 1", http.StatusBadRequest)
+		return
+	}
+	mutex.Lock()
+	current.Settings = req
+	saveLocked()
+	mutex.Unlock()
+	json.NewEncoder(w).Encode(req)
+}
@@ -0,0 +1,274 @@
+package reputation
+
+import (
+	"Otternet/backend/config"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const reputationFilePath = "./api/reputation/reputation.json"
+
+// Outcomes recorded against a peer
+const (
+	OutcomeDownloadSuccess = "download_success" // a file arrived complete and matching its hash
+	OutcomeTransferFailed  = "transfer_failed"  // the provider broke off or could not be reached mid-transfer
+	OutcomeHashMismatch    = "hash_mismatch"    // the provider sent content that does not match the requested hash
+	OutcomeWalletMismatch  = "wallet_mismatch"  // the provider asked to be paid at an address it has not attested to
+	OutcomeProxyUp         = "proxy_up"         // a proxy answered a health check
+	OutcomeProxyDown       = "proxy_down"       // a proxy did not answer a health check
+)
+
+// Weight of each outcome. Positive weights add to a peer's good record, negative ones to its bad record, so dishonest
+// behaviour outweighs several successes
+var outcomeWeights = map[string]float64{
+	OutcomeDownloadSuccess: 1,
+	OutcomeTransferFailed:  -1,
+	OutcomeHashMismatch:    -5,
+	OutcomeWalletMismatch:  -5,
+	OutcomeProxyUp:         0.5,
+	OutcomeProxyDown:       -1,
+}
+
+// Weight of a new sample in the latency moving average
+const latencySmoothing = 0.3
+
+// PeerReputation is the decayed history of one peer
+type PeerReputation struct {
+	PeerID    string         `json:"peerID"`
+	Good      float64        `json:"good"`      // decayed sum of positive outcome weights
+	Bad       float64        `json:"bad"`       // decayed sum of negative outcome weights, as a positive number
+	LatencyMs float64        `json:"latencyMs"` // moving average; 0 when never measured
+	Counts    map[string]int `json:"counts"`    // undecayed number of each outcome
+	UpdatedAt string         `json:"updatedAt"`
+	Score     float64        `json:"score"` // filled in when reported
+}
+
+type state struct {
+	Peers map[string]*PeerReputation `json:"peers"`
+}
+
+var (
+	mutex   = &sync.Mutex{}
+	current = state{Peers: make(map[string]*PeerReputation)}
+	dirty   bool // current has changes not yet written to disk
+)
+
+func init() {
+	loadLocked()
+}
+
+// Replaces current with the reputation file. Caller holds mutex, except during init
+func loadLocked() {
+	current = state{Peers: make(map[string]*PeerReputation)}
+	dirty = false
+	data, err := os.ReadFile(reputationFilePath)
+	if err != nil {
+		return
+	}
+	var saved state
+	if err := json.Unmarshal(data, &saved); err != nil {
+		fmt.Printf("Reputation: ignoring unreadable file: %v\n", err)
+		return
+	}
+	if saved.Peers != nil {
+		current.Peers = saved.Peers
+	}
+}
+
+// Reload rereads the reputation file, e.g. after a backup restored it. Unsaved changes are dropped
+func Reload() {
+	mutex.Lock()
+	defer mutex.Unlock()
+	loadLocked()
+}
+
+// Writes current to disk if it changed since the last save. Caller holds mutex
+func saveLocked() {
+	if !dirty {
+		return
+	}
+	data, err := json.MarshalIndent(current, "", " ")
+	if err != nil {
+		fmt.Printf("Reputation: error marshalling: %v\n", err)
+		return
+	}
+	if err := os.WriteFile(reputationFilePath, data, 0644); err != nil {
+		fmt.Printf("Reputation: error writing: %v\n", err)
+		return
+	}
+	dirty = false
+}
+
+// Save writes any unsaved reputation changes to disk
+func Save() {
+	mutex.Lock()
+	defer mutex.Unlock()
+	saveLocked()
+}
+
+// Run saves changed reputations every ReputationSaveInterval, so recording an outcome or latency sample does not
+// rewrite the file each time. The last changes are saved when ctx is done
+func Run(ctx context.Context) {
+	ticker := time.NewTicker(config.NewConfig().ReputationSaveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			Save()
+			return
+		case <-ticker.C:
+			Save()
+		}
+	}
+}
+
+// Halves Good and Bad once per ReputationHalfLife since the last update, so old behaviour fades. Caller holds mutex
+func (p *PeerReputation) decayLocked(now time.Time, halfLife time.Duration) {
+	updated, err := time.Parse(time.RFC3339, p.UpdatedAt)
+	if err == nil && halfLife > 0 {
+		factor := math.Pow(0.5, now.Sub(updated).Hours()/halfLife.Hours())
+		p.Good *= factor
+		p.Bad *= factor
+	}
+	p.UpdatedAt = now.Format(time.RFC3339)
+}
+
+// Score in [0, 1]: the share of good outcomes with one imaginary good and bad outcome added, so unknown peers score 0.5
+func (p *PeerReputation) score() float64 {
+	return (p.Good + 1) / (p.Good + p.Bad + 2)
+}
+
+// Returns the peer's entry, creating and decaying it. Caller holds mutex
+func peerLocked(peerID string) *PeerReputation {
+	p, ok := current.Peers[peerID]
+	if !ok {
+		p = &PeerReputation{PeerID: peerID, Counts: make(map[string]int)}
+		current.Peers[peerID] = p
+	}
+	if p.Counts == nil {
+		p.Counts = make(map[string]int)
+	}
+	p.decayLocked(time.Now(), config.NewConfig().ReputationHalfLife)
+	return p
+}
+
+// Record adds an outcome to a peer's history
+func Record(peerID string, outcome string) {
+	weight, ok := outcomeWeights[outcome]
+	if !ok || peerID == "" {
+		return
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	p := peerLocked(peerID)
+	if weight > 0 {
+		p.Good += weight
+	} else {
+		p.Bad -= weight
+	}
+	p.Counts[outcome]++
+	dirty = true
+}
+
+// RecordLatency folds a round-trip measurement into a peer's moving average
+func RecordLatency(peerID string, latency time.Duration) {
+	if peerID == "" {
+		return
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	p := peerLocked(peerID)
+	ms := float64(latency) / float64(time.Millisecond)
+	if p.LatencyMs == 0 {
+		p.LatencyMs = ms
+	} else {
+		p.LatencyMs = latencySmoothing*ms + (1-latencySmoothing)*p.LatencyMs
+	}
+	dirty = true
+}
+
+// Lookup returns a peer's reputation with its current score. Unknown peers get the neutral score
+func Lookup(peerID string) PeerReputation {
+	mutex.Lock()
+	defer mutex.Unlock()
+	p, ok := current.Peers[peerID]
+	if !ok {
+		return PeerReputation{PeerID: peerID, Counts: map[string]int{}, Score: 0.5}
+	}
+	result := *p
+	result.decayLocked(time.Now(), config.NewConfig().ReputationHalfLife)
+	result.Score = result.score()
+	return result
+}
+
+// Score returns a peer's score in [0, 1]; 0.5 for peers without history
+func Score(peerID string) float64 {
+	return Lookup(peerID).Score
+}
+
+// Less reports whether peer a should be preferred over peer b: higher score first, then lower latency. Peers without
+// a latency measurement sort after measured ones with the same score
+func Less(a string, b string) bool {
+	ra, rb := Lookup(a), Lookup(b)
+	if ra.Score != rb.Score {
+		return ra.Score > rb.Score
+	}
+	if (ra.LatencyMs == 0) != (rb.LatencyMs == 0) {
+		return rb.LatencyMs == 0
+	}
+	return ra.LatencyMs < rb.LatencyMs
+}
+
+// Rank sorts peer IDs best first, keeping the given order among equals
+func Rank(peerIDs []string) []string {
+	ranked := append([]string(nil), peerIDs...)
+	sort.SliceStable(ranked, func(i, j int) bool { return Less(ranked[i], ranked[j]) })
+	return ranked
+}
+
+// GetReputation lists every known peer, best first
+func GetReputation(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	mutex.Lock()
+	peerIDs := make([]string, 0, len(current.Peers))
+	for peerID := range current.Peers {
+		peerIDs = append(peerIDs, peerID)
+	}
+	mutex.Unlock()
+	peers := make([]PeerReputation, 0, len(peerIDs))
+	for _, peerID := range Rank(peerIDs) {
+		peers = append(peers, Lookup(peerID))
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"peers": peers})
+}
+
+// GetPeerReputation returns one peer's history and score
+func GetPeerReputation(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(Lookup(mux.Vars(r)["peerID"]))
+}
+
+// ResetPeerReputation forgets a peer's history
+func ResetPeerReputation(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	peerID := mux.Vars(r)["peerID"]
+	mutex.Lock()
+	defer mutex.Unlock()
+	if _, ok := current.Peers[peerID]; !ok {
+		http.Error(w, "Peer has no reputation", http.StatusNotFound)
+		return
+	}
+	delete(current.Peers, peerID)
+	dirty = true
+	saveLocked()
+	json.NewEncoder(w).Encode(map[string]string{"status": "reset"})
+}
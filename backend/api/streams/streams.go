@@ -0,0 +1,163 @@
+package streams
+
+import (
+	"Otternet/backend/api/blocklist"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	rcmgr "github.com/libp2p/go-libp2p/p2p/host/resource-manager"
+)
+
+// ErrMessageTooLarge is returned by reads past a protocol's MaxMessageSize
+var ErrMessageTooLarge = errors.New("message exceeds the protocol's size limit")
+
+// Limits bounds the streams of one protocol. Zero values fall back to DefaultLimits
+type Limits struct {
+	ReadTimeout       time.Duration // time allowed to receive the request
+	IdleTimeout       time.Duration // longest a single write of the reply may block; the deadline moves with every write
+	Timeout           time.Duration // limit for the whole exchange; 0 leaves long transfers without one
+	MaxMessageSize    int64         // bytes read from the remote before reads fail with ErrMessageTooLarge
+	MaxStreams        int           // inbound streams of the protocol open at once, across all peers
+	MaxStreamsPerPeer int           // inbound streams of the protocol one peer may have open
+	Memory            int64         // resource manager memory for all streams of the protocol, including muxer buffers
+}
+
+// DefaultLimits applies to protocols registered without limits and fills in zero fields
+var DefaultLimits = Limits{
+	ReadTimeout:       30 * time.Second,
+	IdleTimeout:       time.Minute,
+	MaxMessageSize:    64 << 10,
+	MaxStreams:        64,
+	MaxStreamsPerPeer: 8,
+	Memory:            64 << 20,
+}
+
+var (
+	mutex     = &sync.Mutex{}
+	protocols = make(map[protocol.ID]Limits)
+)
+
+// Protocol registers the limits of a protocol and returns its ID, so they are declared together:
+//
+//	var FileRequestProtocol = streams.Protocol("/otternet/fileRequest", streams.Limits{...})
+//
+// Protocols must be registered before the host is created for the resource manager to enforce their stream limits
+func Protocol(id protocol.ID, limits Limits) protocol.ID {
+	if limits.ReadTimeout == 0 {
+		limits.ReadTimeout = DefaultLimits.ReadTimeout
+	}
+	if limits.IdleTimeout == 0 {
+		limits.IdleTimeout = DefaultLimits.IdleTimeout
+	}
+	if limits.MaxMessageSize == 0 {
+		limits.MaxMessageSize = DefaultLimits.MaxMessageSize
+	}
+	if limits.MaxStreams == 0 {
+		limits.MaxStreams = DefaultLimits.MaxStreams
+	}
+	if limits.MaxStreamsPerPeer == 0 {
+		limits.MaxStreamsPerPeer = DefaultLimits.MaxStreamsPerPeer
+	}
+	if limits.Memory == 0 {
+		limits.Memory = DefaultLimits.Memory
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	protocols[id] = limits
+	return id
+}
+
+// LimitsFor returns the limits registered for a protocol, or DefaultLimits
+func LimitsFor(id protocol.ID) Limits {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if limits, ok := protocols[id]; ok {
+		return limits
+	}
+	return DefaultLimits
+}
+
+// ResourceManager builds libp2p's default resource manager with per-protocol and per-protocol-per-peer stream and
+// memory limits for every registered protocol
+func ResourceManager() (network.ResourceManager, error) {
+	scaling := rcmgr.DefaultLimits
+	libp2p.SetDefaultServiceLimits(&scaling)
+	mutex.Lock()
+	for id, limits := range protocols {
+		// outbound streams of the same protocol, e.g. our own downloads, share the scope but are not capped here
+		scaling.AddProtocolLimit(id, rcmgr.BaseLimit{
+			Streams:         limits.MaxStreams * 4,
+			StreamsInbound:  limits.MaxStreams,
+			StreamsOutbound: limits.MaxStreams * 4,
+			Memory:          limits.Memory,
+		}, rcmgr.BaseLimitIncrease{})
+		scaling.AddProtocolPeerLimit(id, rcmgr.BaseLimit{
+			Streams:         limits.MaxStreamsPerPeer * 4,
+			StreamsInbound:  limits.MaxStreamsPerPeer,
+			StreamsOutbound: limits.MaxStreamsPerPeer * 4,
+			Memory:          limits.Memory,
+		}, rcmgr.BaseLimitIncrease{})
+	}
+	mutex.Unlock()
+	return rcmgr.NewResourceManager(rcmgr.NewFixedLimiter(scaling.AutoScale()))
+}
+
+// A stream whose reads stop at the protocol's message size and whose write deadline moves with every write
+type limitedStream struct {
+	network.Stream
+	limits    Limits
+	remaining int64
+	exceeded  bool
+}
+
+func (s *limitedStream) Read(b []byte) (int, error) {
+	if s.remaining <= 0 {
+		if !s.exceeded {
+			s.exceeded = true
+			blocklist.ReportViolation(s.Conn().RemotePeer(), fmt.Sprintf("message over %d bytes on %s", s.limits.MaxMessageSize, s.Protocol()))
+		}
+		return 0, ErrMessageTooLarge
+	}
+	if int64(len(b)) > s.remaining {
+		b = b[:s.remaining]
+	}
+	n, err := s.Stream.Read(b)
+	s.remaining -= int64(n)
+	return n, err
+}
+
+func (s *limitedStream) Write(b []byte) (int, error) {
+	if s.limits.IdleTimeout > 0 && s.limits.Timeout == 0 {
+		s.Stream.SetWriteDeadline(time.Now().Add(s.limits.IdleTimeout))
+	}
+	return s.Stream.Write(b)
+}
+
+// Handle sets the handler for a protocol on h. Streams from blocked or flooding peers are refused, the handler gets a
+// stream bounded by the protocol's limits, and the request's memory is reserved in the protocol's resource scope
+func Handle(h host.Host, id protocol.ID, handler network.StreamHandler) {
+	h.SetStreamHandler(id, blocklist.Guard(func(s network.Stream) {
+		limits := LimitsFor(id)
+		if err := s.Scope().ReserveMemory(int(limits.MaxMessageSize), network.ReservationPriorityMedium); err != nil {
+			fmt.Printf("Streams: refusing %s stream from %s: %v\n", id, s.Conn().RemotePeer(), err)
+			s.Reset()
+			return
+		}
+		defer s.Scope().ReleaseMemory(int(limits.MaxMessageSize))
+
+		now := time.Now()
+		if limits.Timeout > 0 {
+			s.SetDeadline(now.Add(limits.Timeout))
+		}
+		if limits.Timeout == 0 || limits.ReadTimeout < limits.Timeout {
+			s.SetReadDeadline(now.Add(limits.ReadTimeout))
+		}
+		handler(&limitedStream{Stream: s, limits: limits, remaining: limits.MaxMessageSize})
+	}))
+}
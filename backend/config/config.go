@@ -1,9 +1,84 @@
 package config
 
+import "time"
+
 type Config struct {
     BitcoinRPCURL      string
     BitcoinRPCUser     string
     BitcoinRPCPassword string
+
+    // Payment tracking
+    RequiredConfirmations int           // confirmations before a payment counts as confirmed
+    ReorgSafetyDepth      int           // confirmations after which a payment is no longer watched for reorgs
+    TxWatchInterval       time.Duration // how often tracked transactions are polled
+
+    // Development network (regtest only)
+    DevnetWallet          string        // wallet that receives mined coins and funds the faucet
+    AutoMineInterval      time.Duration // mine a block this often; 0 disables timed mining
+    AutoMineOnMempool     bool          // mine a block whenever the mempool has transactions
+    FaucetAmount          float64       // coins sent per faucet request
+    FaucetCooldown        time.Duration // minimum time between faucet requests for one address
+
+    // Backups
+    BackupDir             string        // where encrypted backup archives are written
+    BackupKeep            int           // number of most recent archives kept by rotation
+    BackupInterval        time.Duration // default interval for scheduled backups
+
+    // Downloads
+    MaxConcurrentDownloads int   // queued downloads beyond this many wait for a free slot
+    DownloadRateLimit      int64 // bytes per second shared by all downloads; 0 means unlimited
+
+    // Seeding
+    UploadRateLimit        int64         // bytes per second shared by all uploads; 0 means unlimited
+    PeerUploadRateLimit    int64         // bytes per second for any one peer; 0 means unlimited
+    MaxConcurrentServes    int           // simultaneous file serves before peers are told to come back later
+    ServeSlotWait          time.Duration // how long a request waits for a free serve slot before getting a busy reply
+    SeedingSchedule        []string      // local time windows such as "22:00-06:00"; empty means always seed
+
+    // Shared folders
+    SharedFolders          []string      // directories whose files are published automatically
+    SharedFolderScan       time.Duration // how often shared folders are rescanned
+    SharedFolderPrice      float64       // price given to automatically published files
+
+    // Integrity checks of published files
+    IntegrityScanInterval  time.Duration // how often every published file is checked
+    RelocateMissingFiles   bool          // search for missing files by hash and republish them from where they are found
+    RelocateSearchFolders  []string      // searched in addition to the shared folders
+
+    // Networking
+    DHTMode                string        // "auto" switches between client and server with AutoNAT reachability; "server" or "client" forces one
+    NetworkID              string        // peers only treat each other as Otternet nodes when their network IDs match; empty is the public network
+    PrivateNetworkKey      string        // path to a swarm.key pre-shared key; when set only nodes holding the same key can connect
+    DHTProtocolPrefix      string        // DHT protocol prefix; empty derives one from NetworkID, or uses the public default
+    BootstrapNodeAddr      string        // multiaddr of the bootstrap node; empty uses the public Otternet bootstrap node
+    RelayNodeAddr          string        // multiaddr of the relay node; empty uses the public Otternet relay
+    RelayNodeAddrs         []string      // further relays, used after RelayNodeAddr and whenever it is down
+    RelayReservations      int           // healthy relays a reservation is kept on at once
+    RelayHealthInterval    time.Duration // how often relays are checked and reservations renewed
+    EnableMDNS             bool          // find peers on the local network; with DHTMode "auto" this also makes the DHT run in server mode
+
+    // Peer exchange
+    PeerExchangeInterval    time.Duration // how often peer lists are swapped with connected Otternet peers
+    PeerExchangeFanout      int           // peers contacted per round
+    PeerExchangeMaxPeers    int           // peers sent in, or accepted from, one message
+    PeerExchangeMinInterval time.Duration // messages from one sender arriving sooner than this after its last are dropped
+    PeerExchangeSenderCap   int           // new peers one sender may add per hour
+
+    // Blocklist
+    StreamRateLimit        int           // inbound streams one peer may open per minute before it is banned; 0 means unlimited
+    ProtocolViolationLimit int           // malformed or badly signed messages from one peer within an hour before it is banned; 0 never bans
+    AutoBanDuration        time.Duration // length of an automatic ban
+
+    // Reputation
+    ReputationHalfLife     time.Duration // a peer's recorded outcomes count half as much after this long
+    ReputationSaveInterval time.Duration // how often changed reputations are written to disk
+
+    // Reproviding
+    ReprovideInterval      time.Duration // how often every published file and the proxy key are announced again
+    ReprovideBatchSize     int           // keys announced concurrently
+    ReprovideTimeout       time.Duration // limit for announcing one key
+    ReprovideRetries       int           // extra attempts for a key that failed within one run
+    ReprovideRetryDelay    time.Duration // wait before retrying failed keys
 }
 
 func NewConfig() *Config {
@@ -11,5 +86,66 @@ func NewConfig() *Config {
         BitcoinRPCURL:      "http://127.0.0.1:8332",
         BitcoinRPCUser:     "user",
         BitcoinRPCPassword: "password",
+
+        RequiredConfirmations: 1,
+        ReorgSafetyDepth:      6,
+        TxWatchInterval:       30 * time.Second,
+
+        DevnetWallet:      "otternet-devnet",
+        AutoMineInterval:  0,
+        AutoMineOnMempool: true,
+        FaucetAmount:      1,
+        FaucetCooldown:    time.Hour,
+
+        BackupDir:      "./backups",
+        BackupKeep:     7,
+        BackupInterval: 24 * time.Hour,
+
+        MaxConcurrentDownloads: 3,
+        DownloadRateLimit:      0,
+
+        UploadRateLimit:     0,
+        PeerUploadRateLimit: 0,
+        MaxConcurrentServes: 4,
+        ServeSlotWait:       10 * time.Second,
+        SeedingSchedule:     nil,
+
+        SharedFolders:     nil,
+        SharedFolderScan:  30 * time.Second,
+        SharedFolderPrice: 1,
+
+        IntegrityScanInterval: 10 * time.Minute,
+        RelocateMissingFiles:  true,
+        RelocateSearchFolders: nil,
+
+        DHTMode:             "auto",
+        NetworkID:           "",
+        PrivateNetworkKey:   "",
+        DHTProtocolPrefix:   "",
+        BootstrapNodeAddr:   "",
+        RelayNodeAddr:       "",
+        RelayNodeAddrs:      nil,
+        RelayReservations:   2,
+        RelayHealthInterval: time.Minute,
+        EnableMDNS:          false,
+
+        PeerExchangeInterval:    5 * time.Minute,
+        PeerExchangeFanout:      4,
+        PeerExchangeMaxPeers:    32,
+        PeerExchangeMinInterval: 30 * time.Second,
+        PeerExchangeSenderCap:   64,
+
+        StreamRateLimit:        120,
+        ProtocolViolationLimit: 5,
+        AutoBanDuration:        30 * time.Minute,
+
+        ReputationHalfLife:     7 * 24 * time.Hour,
+        ReputationSaveInterval: time.Minute,
+
+        ReprovideInterval:   12 * time.Hour,
+        ReprovideBatchSize:  8,
+        ReprovideTimeout:    2 * time.Minute,
+        ReprovideRetries:    2,
+        ReprovideRetryDelay: time.Minute,
     }
 }
@@ -1,11 +1,19 @@
 package main
 
 import (
+	"Otternet/backend/api/addressbook"
+	"Otternet/backend/api/backup"
 	"Otternet/backend/api/bitcoin"
+	"Otternet/backend/api/blocklist"
 	dhtHandlers "Otternet/backend/api/dht_handlers"
 	"Otternet/backend/api/download"
+	"Otternet/backend/api/events"
 	files "Otternet/backend/api/files"
+	fileHandlers "Otternet/backend/api/handlers"
+	"Otternet/backend/api/identify"
 	"Otternet/backend/api/proxy"
+	"Otternet/backend/api/reprovider"
+	"Otternet/backend/api/reputation"
 	"Otternet/backend/api/statistics"
 	"Otternet/backend/global"
 	"context"
@@ -112,6 +120,14 @@ func main() {
 	r.HandleFunc("/lockwallet/{walletName}", bitcoin.LockWalletHandler).Methods("GET")
 	r.HandleFunc("/backupwallet", bitcoin.BackupWalletsHandler).Methods("POST")
 
+	// Encrypted backup routes
+	r.HandleFunc("/backup/create", backup.CreateBackupHandler).Methods("POST")
+	r.HandleFunc("/backup/list", backup.ListBackupsHandler).Methods("GET")
+	r.HandleFunc("/backup/verify", backup.VerifyBackupHandler).Methods("POST")
+	r.HandleFunc("/backup/restore", backup.RestoreBackupHandler).Methods("POST")
+	r.HandleFunc("/backup/schedule", backup.GetScheduleHandler).Methods("GET")
+	r.HandleFunc("/backup/schedule", backup.SetScheduleHandler).Methods("POST")
+
 	// Register Bitcoin routes
 	r.HandleFunc("/newaddress/{walletName}", bitcoin.GenerateAddressHandler).Methods("GET")
 	r.HandleFunc("/newaddress/{walletName}/{label}", bitcoin.GenerateAddressWithLabelHandler).Methods("GET")
@@ -120,20 +136,75 @@ func main() {
 
 	r.HandleFunc("/minecoins/{address}/{amount}", bitcoin.MineCoinsHandler).Methods("GET")
 
+	// Development network routes (regtest only)
+	r.HandleFunc("/devnet/status", bitcoin.GetDevnetStatusHandler).Methods("GET")
+	r.HandleFunc("/devnet/autoMine", bitcoin.SetAutoMineHandler).Methods("POST")
+	r.HandleFunc("/devnet/faucet/{address}", bitcoin.FaucetHandler).Methods("POST")
+
 	// Label from address route
 	r.HandleFunc("/labelfromaddress/{walletName}/{address}", bitcoin.GetLabelFromAddressHandler).Methods("GET")
 
 	r.HandleFunc("/gettransactions/{walletName}", bitcoin.GetTransactionsHandler).Methods("GET")
 	// Coin transaction route
 	r.HandleFunc("/transferCoins/{walletName}/{toAddress}/{amount}/{label}", bitcoin.TransferCoinsHandler).Methods("POST")
+	r.HandleFunc("/previewTransfer/{walletName}/{toAddress}/{amount}", bitcoin.PreviewTransferHandler).Methods("POST")
+	r.HandleFunc("/bumpFee/{walletName}/{txid}", bitcoin.BumpFeeHandler).Methods("POST")
+	// PSBT routes for watch-only wallets with external signers
+	r.HandleFunc("/createWatchOnlyWallet", bitcoin.CreateWatchOnlyWalletHandler).Methods("POST")
+	r.HandleFunc("/psbt/create/{walletName}/{toAddress}/{amount}/{label}", bitcoin.CreatePSBTHandler).Methods("POST")
+	r.HandleFunc("/psbt", bitcoin.ListPSBTsHandler).Methods("GET")
+	r.HandleFunc("/psbt/{id}", bitcoin.GetPSBTHandler).Methods("GET")
+	r.HandleFunc("/psbt/{id}/finalize", bitcoin.FinalizePSBTHandler).Methods("POST")
+	r.HandleFunc("/psbt/{id}", bitcoin.CancelPSBTHandler).Methods("DELETE")
+
+	// Payment tracking routes
+	r.HandleFunc("/getPaymentStatus/{txid}", bitcoin.GetPaymentStatusHandler).Methods("GET")
+	r.HandleFunc("/getTrackedTransactions", bitcoin.GetTrackedTransactionsHandler).Methods("GET")
+	r.HandleFunc("/getProxyPayments", proxy.GetProxyPayments).Methods("GET")
+	r.HandleFunc("/getTransactionHistory/{walletName}", bitcoin.GetTransactionHistoryHandler).Methods("GET")
+	r.HandleFunc("/exportTransactions/{walletName}", bitcoin.ExportTransactionsHandler).Methods("GET")
+
+	// Event stream
+	r.HandleFunc("/events", events.StreamEvents).Methods("GET")
+	r.HandleFunc("/events/recent", events.GetRecentEvents).Methods("GET")
+
+	// Address book routes
+	r.HandleFunc("/addressBook", addressbook.GetAddressBook).Methods("GET")
+	r.HandleFunc("/addressBook", addressbook.PutAddressBookEntry).Methods("POST")
+	r.HandleFunc("/addressBook/{peerID}", addressbook.DeleteAddressBookEntry).Methods("DELETE")
+	r.HandleFunc("/addressBook/resolve/{peerID}", addressbook.ResolveAddress).Methods("GET")
 
 	// Other existing routes
 	r.HandleFunc("/uploadFile", files.UploadFile).Methods("POST")
+	r.HandleFunc("/registerFile", files.RegisterFile).Methods("POST")
+	r.HandleFunc("/sharedFolders", files.GetSharedFolders).Methods("GET")
+	r.HandleFunc("/sharedFolders", files.AddSharedFolder).Methods("POST")
+	r.HandleFunc("/sharedFolders/remove", files.RemoveSharedFolder).Methods("POST")
+	r.HandleFunc("/sharedFolders/price", files.SetSharedFolderPrice).Methods("POST")
+	r.HandleFunc("/integrity", files.GetIntegrityReport).Methods("GET")
+	r.HandleFunc("/integrity/scan", files.StartIntegrityScan).Methods("POST")
+	r.HandleFunc("/integrity/{fileHash}", files.DismissIntegrityIssue).Methods("DELETE")
+	r.HandleFunc("/reprovider", reprovider.GetStatus).Methods("GET")
+	r.HandleFunc("/reprovider/run", reprovider.TriggerRun).Methods("POST")
+	r.HandleFunc("/reprovider/settings", reprovider.SetSettings).Methods("POST")
+	r.HandleFunc("/uploadBundle", files.UploadBundle).Methods("POST")
+	r.HandleFunc("/bundle/{fileHash}/manifest", files.GetBundleManifest).Methods("GET")
+	r.HandleFunc("/bundle/{fileHash}/manifest/{providerID}", files.FetchBundleManifest).Methods("GET")
 	r.HandleFunc("/deleteFile/{fileHash}", files.DeleteFile).Methods("DELETE")
 	r.HandleFunc("/confirmFile/{fileHash}", files.ConfirmFileinDHT).Methods("GET")
 	r.HandleFunc("/getUploads/{walletAddr}", files.GetAllFiles).Methods("GET")
 	r.HandleFunc("/getPrices/{fileHash}", files.GetFilePrices).Methods("GET")
+	r.HandleFunc("/getRankedPrices/{fileHash}", files.GetRankedFilePrices).Methods("GET")
 	r.HandleFunc("/download", files.DownloadFile).Methods("POST")
+	r.HandleFunc("/downloads", files.StartDownloadJob).Methods("POST")
+	r.HandleFunc("/downloads", files.ListDownloadJobs).Methods("GET")
+	r.HandleFunc("/downloads/settings", files.GetDownloadSettings).Methods("GET")
+	r.HandleFunc("/downloads/settings", files.SetDownloadSettings).Methods("POST")
+	r.HandleFunc("/downloads/{jobID}", files.GetDownloadJob).Methods("GET")
+	r.HandleFunc("/downloads/{jobID}", files.CancelDownloadJob).Methods("DELETE")
+	r.HandleFunc("/downloads/{jobID}/pause", files.PauseDownloadJob).Methods("POST")
+	r.HandleFunc("/downloads/{jobID}/resume", files.ResumeDownloadJob).Methods("POST")
+	r.HandleFunc("/downloads/{jobID}/priority", files.SetDownloadPriority).Methods("POST")
 	r.HandleFunc("/getProviders/{fileHash}", files.GetProviders).Methods("GET")
 	// r.HandleFunc("/download", download.DownloadFile).Methods("POST")
 	r.HandleFunc("/getDownloadHistory/{walletAddr}", download.GetDownloadHistory).Methods("GET")
@@ -142,14 +213,34 @@ func main() {
 	r.HandleFunc("/getClosestPeers", files.GetClosestPeers).Methods("GET")
 	r.HandleFunc("/getCatalog/{providerID}", files.GetCatalog).Methods("GET")
 	r.HandleFunc("/getOtternetPeers", files.GetOtternetPeers).Methods("GET") // get otternet peers that HAVE FILES UPLOADED
+	r.HandleFunc("/getOtternetPeerDetails", files.GetOtternetPeerDetails).Methods("GET")
+	r.HandleFunc("/describeSelf", identify.GetSelfDescription).Methods("GET")
+	r.HandleFunc("/describePeer/{peerID}", identify.GetPeerDescription).Methods("GET")
 	r.HandleFunc("/putPeersInCache", files.PutPeersInCache).Methods("POST")
+	// Blocklist
+	r.HandleFunc("/blocklist", blocklist.GetBlocklist).Methods("GET")
+	r.HandleFunc("/blocklist/peers", blocklist.PostBlockedPeer).Methods("POST")
+	r.HandleFunc("/blocklist/peers/{peerID}", blocklist.DeleteBlockedPeer).Methods("DELETE")
+	r.HandleFunc("/blocklist/ranges", blocklist.PostBlockedRange).Methods("POST")
+	r.HandleFunc("/blocklist/ranges", blocklist.DeleteBlockedRange).Methods("DELETE")
+	// Peer reputation
+	r.HandleFunc("/reputation", reputation.GetReputation).Methods("GET")
+	r.HandleFunc("/reputation/{peerID}", reputation.GetPeerReputation).Methods("GET")
+	r.HandleFunc("/reputation/{peerID}", reputation.ResetPeerReputation).Methods("DELETE")
 
 	// DHT Routes
 	r.HandleFunc("/startDHT/{walletAddr}", dhtHandlers.StartDHTHandler).Methods("GET")
 	r.HandleFunc("/stopDHT", dhtHandlers.CloseDHTHandler).Methods("GET")
+	r.HandleFunc("/networkStatus", dhtHandlers.GetNetworkStatus).Methods("GET")
+	r.HandleFunc("/peerExchange", dhtHandlers.GetExchangedPeers).Methods("GET")
+	r.HandleFunc("/relays", dhtHandlers.GetRelays).Methods("GET")
 
 	// Accessing File for bytes uploaded
 	r.HandleFunc("/getBytesUploaded", statistics.GetBytesUploadedHandler).Methods("GET")
+	// Seeding limits and schedule
+	r.HandleFunc("/seeding/settings", fileHandlers.GetSeedingSettings).Methods("GET")
+	r.HandleFunc("/seeding/settings", fileHandlers.SetSeedingSettings).Methods("POST")
+	r.HandleFunc("/seeding/active", fileHandlers.GetActiveServes).Methods("GET")
 	// Proxy-related routes
 	r.HandleFunc("/getActiveProxies", proxy.GetActiveProxies).Methods("GET")
 	r.HandleFunc("/getClientCount", proxy.GetClientCount).Methods("GET")
@@ -290,6 +381,17 @@ func main() {
 	signal.Notify(signalChan, syscall.SIGTERM, syscall.SIGINT)
 	shutdownComplete := make(chan bool)
 	bitcoin.LoadAllWallets()
+
+	globalCtx, cancelGlobalCtx = context.WithCancel(context.Background())
+	defer cancelGlobalCtx()
+	go bitcoin.WatchTransactions(globalCtx)
+	go bitcoin.RunDevnetMiner(globalCtx)
+	go files.RunDownloadQueue(globalCtx)
+	go files.WatchSharedFolders(globalCtx)
+	go files.RunIntegrityScans(globalCtx)
+	go reprovider.Run(globalCtx)
+	go reputation.Run(globalCtx)
+
 	go func() {
 		println("Preparing to listen on port 9378")
 		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
@@ -300,9 +402,11 @@ func main() {
 	go func() {
 		sig := <-signalChan
 		fmt.Printf("Received signal: %s\n", sig)
+		cancelGlobalCtx()
 		if err := server.Shutdown(context.TODO()); err != nil {
 			log.Fatalf("Error during shutdown: %v", err)
 		}
+		reputation.Save()
 		shutdownComplete <- true
 	}()
 	<-shutdownComplete